@@ -0,0 +1,54 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/nam2184/storpc/storpc"
+)
+
+const checkUsage = "check [--proto_path dirs] [--format text|json] SCHEMA"
+
+// runCheck parses a schema and prints every diagnostic, warnings included.
+func runCheck(args map[storpc.ParseArgs]string, positional []string, stdout, stderr io.Writer) int {
+	if len(positional) != 1 {
+		fmt.Fprintln(stderr, "usage: storpc "+checkUsage)
+		return exitUsage
+	}
+
+	options, err := parserOptions(args, positional[0], stderr)
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return exitUsage
+	}
+
+	parser := storpc.NewProtoParser(options)
+	_, err = parser.Parse()
+
+	var diagnostics storpc.Diagnostics
+	if err != nil && !errors.As(err, &diagnostics) {
+		fmt.Fprintf(stderr, "%v: %v\n", positional[0], err)
+		return exitUsage
+	}
+	diagnostics = parser.Diagnostics()
+
+	switch args[Format] {
+	case "", "text":
+		err = diagnostics.WriteText(stdout)
+	case "json":
+		err = diagnostics.WriteJSON(stdout)
+	default:
+		fmt.Fprintf(stderr, "unknown format %q\n", args[Format])
+		return exitUsage
+	}
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return exitUsage
+	}
+
+	if diagnostics.HasErrors() {
+		return exitFail
+	}
+	return exitOK
+}
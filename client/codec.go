@@ -0,0 +1,83 @@
+// Package client is the runtime of generated storpc clients.
+//
+// Generated messages are plain structs with generated AppendWire and
+// ConsumeWire methods that encode them in the protobuf wire format, so no
+// reflection is involved. Well-known types are carried as their protobuf-go
+// messages.
+package client
+
+import (
+	"fmt"
+
+	"google.golang.org/protobuf/encoding/protowire"
+	"google.golang.org/protobuf/proto"
+)
+
+// Message is implemented by generated messages.
+type Message interface {
+	// AppendWire appends the encoded message to b. A nil message appends
+	// nothing.
+	AppendWire(b []byte) ([]byte, error)
+	// ConsumeWire merges an encoded message: set scalars are overwritten,
+	// lists and maps are appended to. Unknown fields, and fields of another
+	// wire type than the schema's, are skipped.
+	ConsumeWire(b []byte) error
+}
+
+// Codec encodes generated messages in the protobuf wire format. Pass it with
+// grpc.ForceCodec on every call of a generated client.
+type Codec struct{}
+
+func (Codec) Marshal(v any) ([]byte, error) {
+	return Marshal(v)
+}
+
+func (Codec) Unmarshal(data []byte, v any) error {
+	return Unmarshal(data, v)
+}
+
+func (Codec) Name() string {
+	return "proto"
+}
+
+// Marshal encodes a generated message or any proto.Message.
+func Marshal(v any) ([]byte, error) {
+	switch m := v.(type) {
+	case Message:
+		return m.AppendWire(nil)
+	case proto.Message:
+		return proto.Marshal(m)
+	}
+	return nil, fmt.Errorf("client: cannot marshal %T", v)
+}
+
+// Unmarshal decodes into a new generated message or any proto.Message.
+func Unmarshal(data []byte, v any) error {
+	if m, ok := v.(proto.Message); ok {
+		return proto.Unmarshal(data, m)
+	}
+	return Merge(data, v)
+}
+
+// Merge decodes into a generated message or a proto.Message, keeping what
+// it already holds as protobuf does for repeated occurrences of a message.
+func Merge(data []byte, v any) error {
+	switch m := v.(type) {
+	case Message:
+		return m.ConsumeWire(data)
+	case proto.Message:
+		return proto.UnmarshalOptions{Merge: true}.Unmarshal(data, m)
+	}
+	return fmt.Errorf("client: cannot unmarshal into %T", v)
+}
+
+// AppendMessage appends a length-delimited message field, a generated
+// message or a proto.Message.
+func AppendMessage(b []byte, number protowire.Number, v any) ([]byte, error) {
+	data, err := Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	b = protowire.AppendTag(b, number, protowire.BytesType)
+	return protowire.AppendBytes(b, data), nil
+}
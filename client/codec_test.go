@@ -0,0 +1,122 @@
+package client
+
+import (
+	"testing"
+
+	"google.golang.org/protobuf/encoding/protowire"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+// line is written the way the generator writes a message with a string and
+// a repeated message field.
+type line struct {
+	Sku   string
+	Parts []*line
+}
+
+func (m *line) AppendWire(b []byte) ([]byte, error) {
+	if m == nil {
+		return b, nil
+	}
+	var err error
+	if m.Sku != "" {
+		b = protowire.AppendTag(b, 1, protowire.BytesType)
+		b = protowire.AppendString(b, m.Sku)
+	}
+	for _, v := range m.Parts {
+		if b, err = AppendMessage(b, 2, v); err != nil {
+			return nil, err
+		}
+	}
+	return b, nil
+}
+
+func (m *line) ConsumeWire(b []byte) error {
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		b = b[n:]
+		switch {
+		case num == 1 && typ == protowire.BytesType:
+			v, n := protowire.ConsumeString(b)
+			if n < 0 {
+				return protowire.ParseError(n)
+			}
+			m.Sku = v
+			b = b[n:]
+		case num == 2 && typ == protowire.BytesType:
+			v, n := protowire.ConsumeBytes(b)
+			if n < 0 {
+				return protowire.ParseError(n)
+			}
+			x := new(line)
+			if err := Merge(v, x); err != nil {
+				return err
+			}
+			m.Parts = append(m.Parts, x)
+			b = b[n:]
+		default:
+			n = protowire.ConsumeFieldValue(num, typ, b)
+			if n < 0 {
+				return protowire.ParseError(n)
+			}
+			b = b[n:]
+		}
+	}
+	return nil
+}
+
+func TestCodecMessages(t *testing.T) {
+	in := &line{Sku: "x", Parts: []*line{{Sku: "y"}, nil}}
+	data, err := Codec{}.Marshal(in)
+	if err != nil {
+		t.Fatalf("Marshal failed: %v", err)
+	}
+
+	out := &line{}
+	if err := (Codec{}).Unmarshal(data, out); err != nil {
+		t.Fatalf("Unmarshal failed: %v", err)
+	}
+	if out.Sku != "x" || len(out.Parts) != 2 || out.Parts[0].Sku != "y" || out.Parts[1].Sku != "" {
+		t.Errorf("decoded %+v", out)
+	}
+
+	if err := (Codec{}).Unmarshal(data[:len(data)-1], &line{}); err == nil {
+		t.Errorf("expected error for truncated input")
+	}
+	if _, err := (Codec{}).Marshal(line{}); err == nil {
+		t.Errorf("expected error for a message that is not a pointer")
+	}
+}
+
+func TestCodecProtoMessages(t *testing.T) {
+	data, err := Codec{}.Marshal(wrapperspb.String("hello"))
+	if err != nil {
+		t.Fatalf("Marshal failed: %v", err)
+	}
+
+	// Unmarshal replaces what a protobuf message holds, Merge keeps it
+	out := wrapperspb.String("old")
+	if err := (Codec{}).Unmarshal(data, out); err != nil || out.Value != "hello" {
+		t.Errorf("Unmarshal = %v, %v", out, err)
+	}
+	merged := &line{Sku: "kept"}
+	if err := Merge(nil, merged); err != nil || merged.Sku != "kept" {
+		t.Errorf("Merge = %+v, %v", merged, err)
+	}
+
+	// a well-known type inside a generated message
+	b, err := AppendMessage(nil, 3, wrapperspb.Int32(7))
+	if err != nil {
+		t.Fatalf("AppendMessage failed: %v", err)
+	}
+	num, typ, n := protowire.ConsumeTag(b)
+	v, _ := protowire.ConsumeBytes(b[n:])
+	value := &wrapperspb.Int32Value{}
+	if num != 3 || typ != protowire.BytesType || proto.Unmarshal(v, value) != nil || value.Value != 7 {
+		t.Errorf("AppendMessage wrote %x", b)
+	}
+}
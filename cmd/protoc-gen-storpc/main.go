@@ -0,0 +1,39 @@
+// protoc-gen-storpc runs the storpc parser as a protoc or buf plugin.
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/nam2184/storpc/storpc"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/pluginpb"
+)
+
+func main() {
+	if err := run(os.Stdin, os.Stdout, os.Stderr); err != nil {
+		fmt.Fprintln(os.Stderr, "protoc-gen-storpc:", err)
+		os.Exit(1)
+	}
+}
+
+func run(stdin io.Reader, stdout, stderr io.Writer) error {
+	data, err := io.ReadAll(stdin)
+	if err != nil {
+		return err
+	}
+
+	req := &pluginpb.CodeGeneratorRequest{}
+	if err := proto.Unmarshal(data, req); err != nil {
+		return fmt.Errorf("bad CodeGeneratorRequest: %w", err)
+	}
+
+	out, err := proto.Marshal(storpc.Generate(req, stderr))
+	if err != nil {
+		return err
+	}
+
+	_, err = stdout.Write(out)
+	return err
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+
+	"github.com/nam2184/storpc/storpc"
+)
+
+const compatUsage = "compat [--proto_path dirs] [--format text|json] OLD NEW"
+
+func runCompat(args map[storpc.ParseArgs]string, positional []string, stdout, stderr io.Writer) int {
+	if len(positional) != 2 {
+		fmt.Fprintln(stderr, "usage: storpc "+compatUsage)
+		return exitUsage
+	}
+
+	prev, err := loadSchema(args, positional[0], stderr)
+	if err != nil {
+		fmt.Fprintf(stderr, "%v: %v\n", positional[0], err)
+		return exitUsage
+	}
+	next, err := loadSchema(args, positional[1], stderr)
+	if err != nil {
+		fmt.Fprintf(stderr, "%v: %v\n", positional[1], err)
+		return exitUsage
+	}
+
+	report := storpc.CompareGenIR(prev, next)
+
+	switch args[Format] {
+	case "", "text":
+		for _, change := range report.Changes {
+			fmt.Fprintln(stdout, change)
+		}
+	case "json":
+		type jsonChange struct {
+			storpc.Change
+			Level string `json:"level"`
+		}
+		out := struct {
+			Breaking bool         `json:"breaking"`
+			Changes  []jsonChange `json:"changes"`
+		}{Breaking: report.Breaking(), Changes: []jsonChange{}}
+		for _, change := range report.Changes {
+			out.Changes = append(out.Changes, jsonChange{change, storpc.CompatString(change.Level)})
+		}
+		enc := json.NewEncoder(stdout)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(out); err != nil {
+			fmt.Fprintln(stderr, err)
+			return exitUsage
+		}
+	default:
+		fmt.Fprintf(stderr, "unknown format %q\n", args[Format])
+		return exitUsage
+	}
+
+	if report.Breaking() {
+		return exitFail
+	}
+	return exitOK
+}
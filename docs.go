@@ -0,0 +1,41 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/nam2184/storpc/storpc"
+)
+
+const docsUsage = "docs [--format markdown|html] [--out file] [--proto_path dirs] SCHEMA"
+
+func runDocs(args map[storpc.ParseArgs]string, positional []string, stdout, stderr io.Writer) int {
+	if len(positional) != 1 {
+		fmt.Fprintln(stderr, "usage: storpc "+docsUsage)
+		return exitUsage
+	}
+
+	gen, err := loadSchema(args, positional[0], stderr)
+	if err != nil {
+		fmt.Fprintf(stderr, "%v: %v\n", positional[0], err)
+		return exitUsage
+	}
+
+	docs, err := storpc.GenerateDocs(gen, args[Format])
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return exitUsage
+	}
+
+	if args[Out] == "" {
+		_, err = stdout.Write(docs)
+	} else {
+		err = os.WriteFile(args[Out], docs, 0644)
+	}
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return exitFail
+	}
+	return exitOK
+}
@@ -0,0 +1,178 @@
+package driver
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+
+	"github.com/nam2184/storpc/storpc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+)
+
+// DefaultValue converts the declared default of f to the Go type its column
+// holds. It returns nil when f declares no default. enum is the type of an
+// enum field and is ignored otherwise.
+func DefaultValue(f *storpc.Field, enum *storpc.Enum) (any, error) {
+	if !f.HasDefault {
+		return nil, nil
+	}
+
+	text := f.Default
+	if f.Kind == storpc.KindEnum {
+		if enum == nil {
+			return nil, fmt.Errorf("%s: enum %s not found", f.Name, f.Type)
+		}
+		for _, v := range enum.Values {
+			if v.Name == text {
+				return protoreflect.EnumNumber(v.Value), nil
+			}
+		}
+		return nil, fmt.Errorf("%s: default %q is not a value of %s", f.Name, text, f.Type)
+	}
+
+	var value any
+	var err error
+	switch f.ScalarType() {
+	case "bool":
+		value, err = strconv.ParseBool(text)
+	case "int32", "sint32", "sfixed32":
+		var v int64
+		v, err = strconv.ParseInt(text, 0, 32)
+		value = int32(v)
+	case "int64", "sint64", "sfixed64":
+		value, err = strconv.ParseInt(text, 0, 64)
+	case "uint32", "fixed32":
+		var v uint64
+		v, err = strconv.ParseUint(text, 0, 32)
+		value = uint32(v)
+	case "uint64", "fixed64":
+		value, err = strconv.ParseUint(text, 0, 64)
+	case "float", "double":
+		var v float64
+		v, err = parseFloat(text)
+		if f.ScalarType() == "float" {
+			value = float32(v)
+		} else {
+			value = v
+		}
+	case "string":
+		value = text
+	case "bytes":
+		value, err = unescapeBytes(text)
+	default:
+		return nil, fmt.Errorf("%s: %s fields have no default", f.Name, f.Type)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: bad default %q: %w", f.Name, text, err)
+	}
+	return value, nil
+}
+
+// unescapeBytes decodes a bytes default, which descriptors C-escape: quotes,
+// control characters and octal or hex escapes of any byte.
+func unescapeBytes(text string) ([]byte, error) {
+	b := make([]byte, 0, len(text))
+	for i := 0; i < len(text); i++ {
+		c := text[i]
+		if c != '\\' {
+			b = append(b, c)
+			continue
+		}
+		i++
+		if i == len(text) {
+			return nil, errors.New("trailing backslash")
+		}
+
+		switch c = text[i]; c {
+		case 'a':
+			b = append(b, '\a')
+		case 'b':
+			b = append(b, '\b')
+		case 'f':
+			b = append(b, '\f')
+		case 'n':
+			b = append(b, '\n')
+		case 'r':
+			b = append(b, '\r')
+		case 't':
+			b = append(b, '\t')
+		case 'v':
+			b = append(b, '\v')
+		case '\\', '\'', '"', '?':
+			b = append(b, c)
+		case '0', '1', '2', '3', '4', '5', '6', '7':
+			// up to three octal digits
+			end := i + 1
+			for end < len(text) && end < i+3 && text[end] >= '0' && text[end] <= '7' {
+				end++
+			}
+			v, err := strconv.ParseUint(text[i:end], 8, 8)
+			if err != nil {
+				return nil, fmt.Errorf("bad escape \\%s", text[i:end])
+			}
+			b = append(b, byte(v))
+			i = end - 1
+		case 'x', 'X':
+			end := i + 1
+			for end < len(text) && end < i+3 && isHex(text[end]) {
+				end++
+			}
+			if end == i+1 {
+				return nil, errors.New("\\x without hex digits")
+			}
+			v, _ := strconv.ParseUint(text[i+1:end], 16, 8)
+			b = append(b, byte(v))
+			i = end - 1
+		default:
+			return nil, fmt.Errorf("unknown escape \\%c", c)
+		}
+	}
+	return b, nil
+}
+
+func isHex(c byte) bool {
+	return '0' <= c && c <= '9' || 'a' <= c && c <= 'f' || 'A' <= c && c <= 'F'
+}
+
+func parseFloat(text string) (float64, error) {
+	switch text {
+	case "inf":
+		return math.Inf(1), nil
+	case "-inf":
+		return math.Inf(-1), nil
+	case "nan":
+		return math.NaN(), nil
+	}
+	return strconv.ParseFloat(text, 64)
+}
+
+// ApplyDefaults fills the unset columns of a stored row of m with their
+// declared defaults. Rows are stored without defaults, so a default changed
+// in the schema applies to every row written before.
+func ApplyDefaults(body *storpc.GenBody, m *storpc.Message, row TableRowEntity) (TableRowEntity, error) {
+	columns := row.columns
+	copied := false
+
+	for i := range m.Fields {
+		f := &m.Fields[i]
+		if !f.HasDefault || row.Column(f.Number) != nil {
+			continue
+		}
+		value, err := DefaultValue(f, body.EnumOf(f))
+		if err != nil {
+			return row, err
+		}
+
+		if !copied {
+			columns = append([]any(nil), columns...)
+			copied = true
+		}
+		for int(f.Number) >= len(columns) {
+			columns = append(columns, nil)
+		}
+		columns[f.Number] = value
+	}
+
+	return NewTableRowEntity(row.id, columns), nil
+}
@@ -0,0 +1,104 @@
+package driver
+
+import (
+	"bytes"
+	"math"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/nam2184/storpc/storpc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+)
+
+func TestApplyDefaults(t *testing.T) {
+	body := storpc.NewGenBody()
+	body.Enums = []storpc.Enum{{
+		Name:   "shop.Size",
+		Values: []storpc.EnumValue{{Name: "SMALL", Value: 1}, {Name: "LARGE", Value: 2}},
+	}}
+	body.Messages = []storpc.Message{{
+		Name:  "shop.Item",
+		Table: "items",
+		Fields: []storpc.Field{
+			{Name: "id", Type: "int64", Number: 1, Key: true, Presence: storpc.PresenceRequired},
+			{Name: "name", Type: "string", Number: 2, Default: "unnamed", HasDefault: true},
+			{Name: "size", Type: "shop.Size", Kind: storpc.KindEnum, Number: 3, Default: "LARGE", HasDefault: true},
+			{Name: "price", Type: "double", Number: 4, Default: "-inf", HasDefault: true},
+			{Name: "blob", Type: "bytes", Number: 5, Default: `a\001`, HasDefault: true},
+			{Name: "stock", Type: "int32", Number: 6, Default: "0x10", HasDefault: true},
+		},
+	}}
+	body.Reindex()
+	item := &body.Messages[0]
+
+	stored := NewTableRowEntity(3, []any{nil, int64(3), "kept"})
+	row, err := ApplyDefaults(body, item, stored)
+	if err != nil {
+		t.Fatalf("ApplyDefaults failed: %v", err)
+	}
+
+	want := []any{nil, int64(3), "kept", protoreflect.EnumNumber(2), math.Inf(-1), []byte("a\x01"), int32(16)}
+	if !reflect.DeepEqual(row.Columns(), want) {
+		t.Errorf("columns = %#v, want %#v", row.Columns(), want)
+	}
+	if len(stored.Columns()) != 3 {
+		t.Errorf("stored row modified: %#v", stored.Columns())
+	}
+
+	data, err := EncodeRow(item, stored)
+	if err != nil {
+		t.Fatalf("EncodeRow failed: %v", err)
+	}
+	read, err := ReadRow(body, item, 3, data)
+	if err != nil {
+		t.Fatalf("ReadRow failed: %v", err)
+	}
+	if !reflect.DeepEqual(read.Columns(), want) {
+		t.Errorf("read columns = %#v, want %#v", read.Columns(), want)
+	}
+
+	item.Fields[2].Default = "HUGE"
+	if _, err := ApplyDefaults(body, item, stored); err == nil {
+		t.Errorf("expected error for unknown enum default")
+	}
+	if _, err := ReadRow(body, item, 3, data); err == nil {
+		t.Errorf("expected ReadRow error for unknown enum default")
+	}
+}
+
+func TestBytesDefaultEscapes(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "blob.proto")
+	source := `syntax = "proto2";
+package shop;
+
+message Blob {
+  optional bytes data = 1 [default = "it's\001\377x\x41\"\\"];
+}
+`
+	if err := os.WriteFile(path, []byte(source), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	gen, err := storpc.NewProtoParser(&storpc.ProtoParserOptions{Inputs: []string{path}, ImportPaths: []string{dir}, Quiet: true}).Parse()
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+	f := &gen.Body.Message("shop.Blob").Fields[0]
+
+	value, err := DefaultValue(f, nil)
+	if err != nil {
+		t.Fatalf("DefaultValue(%q) failed: %v", f.Default, err)
+	}
+	if want := []byte("it's\x01\xffxA\"\\"); !bytes.Equal(value.([]byte), want) {
+		t.Errorf("DefaultValue(%q) = %q, want %q", f.Default, value, want)
+	}
+
+	for _, bad := range []string{`a\`, `\q`, `\x`} {
+		if _, err := DefaultValue(&storpc.Field{Name: "data", Type: "bytes", Default: bad, HasDefault: true}, nil); err == nil {
+			t.Errorf("DefaultValue(%q) did not fail", bad)
+		}
+	}
+}
@@ -0,0 +1,477 @@
+package driver
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/nam2184/storpc/storpc"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/encoding/protowire"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+)
+
+/*
+Column encoding
+
+Encoded values of a column compare with bytes.Compare in the order of the
+values they hold, so indexes can be range scanned:
+
+	integers      8 bytes big endian, signed kinds with the sign bit flipped
+	floats        float64 bits, sign bit flipped for positives, all bits
+	              inverted for negatives
+	bool          1 byte
+	string/bytes  0x00 escaped as 0x00 0xFF, terminated by 0x00 0x01
+	time          seconds since the epoch as a signed integer, then nanos
+	              as 4 bytes big endian
+	duration      seconds, then nanos, both as signed integers
+	nullable      0x00 for null, else 0x01 and the wrapped scalar
+	json          the JSON text as a string
+	any           the type URL as a string, then the value as bytes
+
+Messages that are not well-known types, repeated and map columns are stored
+as the protobuf encoding of the value and are not ordered.
+*/
+
+// AnyValue is a google.protobuf.Any column: a value tagged with its type.
+type AnyValue struct {
+	TypeURL string
+	Value   []byte
+}
+
+var errNullColumn = errors.New("null value for a column that is not nullable")
+
+// EncodeColumn appends the encoding of v, a value of field f, to b. Time
+// and duration columns take time.Time and time.Duration as well as the
+// well-known messages; nullable columns take nil, the scalar or its wrapper.
+func EncodeColumn(b []byte, f *storpc.Field, v any) ([]byte, error) {
+	if f.IsRepeated() || f.IsMap() || (f.Kind == storpc.KindMessage && f.Logical == storpc.LogicalNone) {
+		return encodeOpaque(b, v)
+	}
+
+	switch f.Logical {
+	case storpc.LogicalTime:
+		return encodeTime(b, v)
+	case storpc.LogicalDuration:
+		return encodeDuration(b, v)
+	case storpc.LogicalNullable:
+		if m, ok := message(v); ok {
+			if !m.IsValid() {
+				return append(b, 0x00), nil
+			}
+			v = m.Get(m.Descriptor().Fields().ByName("value")).Interface()
+		}
+		if v == nil {
+			return append(b, 0x00), nil
+		}
+		return encodeScalar(append(b, 0x01), f.ScalarType(), v)
+	case storpc.LogicalJSON:
+		return encodeJSON(b, v)
+	case storpc.LogicalAny:
+		return encodeAny(b, v)
+	}
+
+	if f.Kind == storpc.KindEnum {
+		return encodeScalar(b, "enum", v)
+	}
+	return encodeScalar(b, f.Type, v)
+}
+
+// DecodeColumn reads a value of field f from the start of b and returns it
+// with the rest of b. Time columns decode to time.Time, durations to
+// time.Duration, null to nil, JSON to json.RawMessage and Any to AnyValue.
+func DecodeColumn(b []byte, f *storpc.Field) (any, []byte, error) {
+	if f.IsRepeated() || f.IsMap() || (f.Kind == storpc.KindMessage && f.Logical == storpc.LogicalNone) {
+		return decodeBytes(b)
+	}
+
+	switch f.Logical {
+	case storpc.LogicalTime:
+		seconds, rest, err := decodeInt(b)
+		if err != nil || len(rest) < 4 {
+			return nil, nil, errShortColumn(f)
+		}
+		nanos := int64(binary.BigEndian.Uint32(rest))
+		return time.Unix(seconds, nanos).UTC(), rest[4:], nil
+	case storpc.LogicalDuration:
+		seconds, rest, err := decodeInt(b)
+		if err != nil {
+			return nil, nil, errShortColumn(f)
+		}
+		nanos, rest, err := decodeInt(rest)
+		if err != nil {
+			return nil, nil, errShortColumn(f)
+		}
+		if seconds > math.MaxInt64/int64(time.Second) || seconds < math.MinInt64/int64(time.Second) {
+			return nil, nil, fmt.Errorf("column %v: duration of %ds overflows time.Duration", f.Name, seconds)
+		}
+		return time.Duration(seconds)*time.Second + time.Duration(nanos), rest, nil
+	case storpc.LogicalNullable:
+		if len(b) == 0 {
+			return nil, nil, errShortColumn(f)
+		}
+		if b[0] == 0x00 {
+			return nil, b[1:], nil
+		}
+		return decodeScalar(b[1:], f.ScalarType())
+	case storpc.LogicalJSON:
+		text, rest, err := decodeBytes(b)
+		if err != nil {
+			return nil, nil, err
+		}
+		return json.RawMessage(text.([]byte)), rest, nil
+	case storpc.LogicalAny:
+		url, rest, err := decodeBytes(b)
+		if err != nil {
+			return nil, nil, err
+		}
+		value, rest, err := decodeBytes(rest)
+		if err != nil {
+			return nil, nil, err
+		}
+		return AnyValue{TypeURL: string(url.([]byte)), Value: value.([]byte)}, rest, nil
+	}
+
+	if f.Kind == storpc.KindEnum {
+		return decodeScalar(b, "enum")
+	}
+	return decodeScalar(b, f.Type)
+}
+
+// EncodeRow encodes the set columns of a row of m as field number and column
+// pairs. Unset columns are left out.
+func EncodeRow(m *storpc.Message, row TableRowEntity) ([]byte, error) {
+	var b []byte
+	var err error
+	for i := range m.Fields {
+		f := &m.Fields[i]
+		v := row.Column(f.Number)
+		if v == nil {
+			continue
+		}
+		b = protowire.AppendVarint(b, uint64(f.Number))
+		if b, err = EncodeColumn(b, f, v); err != nil {
+			return nil, fmt.Errorf("column %v: %w", f.Name, err)
+		}
+	}
+	return b, nil
+}
+
+// DecodeRow reverses EncodeRow. Columns of fields m no longer has fail the
+// decode, rows must be migrated first.
+func DecodeRow(m *storpc.Message, id uint32, data []byte) (TableRowEntity, error) {
+	var columns []any
+	for len(data) > 0 {
+		number, n := protowire.ConsumeVarint(data)
+		if n < 0 {
+			return TableRowEntity{}, protowire.ParseError(n)
+		}
+		if number < 1 || number > uint64(protowire.MaxValidNumber) {
+			return TableRowEntity{}, fmt.Errorf("row %d: bad field number %d", id, number)
+		}
+		f := fieldByNumber(m, int32(number))
+		if f == nil {
+			return TableRowEntity{}, fmt.Errorf("row %d: no field %d in %v", id, number, m.Name)
+		}
+
+		v, rest, err := DecodeColumn(data[n:], f)
+		if err != nil {
+			return TableRowEntity{}, fmt.Errorf("row %d: %w", id, err)
+		}
+		data = rest
+
+		for len(columns) <= int(f.Number) {
+			columns = append(columns, nil)
+		}
+		columns[f.Number] = v
+	}
+	return NewTableRowEntity(id, columns), nil
+}
+
+// ReadRow decodes a stored row of m and fills its unset columns with the
+// declared defaults.
+func ReadRow(body *storpc.GenBody, m *storpc.Message, id uint32, data []byte) (TableRowEntity, error) {
+	row, err := DecodeRow(m, id, data)
+	if err != nil {
+		return row, err
+	}
+	row, err = ApplyDefaults(body, m, row)
+	if err != nil {
+		return TableRowEntity{}, fmt.Errorf("row %d: %w", id, err)
+	}
+	return row, nil
+}
+
+func errShortColumn(f *storpc.Field) error {
+	return fmt.Errorf("column %v: truncated value", f.Name)
+}
+
+func message(v any) (protoreflect.Message, bool) {
+	switch m := v.(type) {
+	case proto.Message:
+		return m.ProtoReflect(), true
+	case protoreflect.Message:
+		return m, true
+	}
+	return nil, false
+}
+
+// secondsNanos reads the seconds and nanos fields shared by Timestamp and
+// Duration.
+func secondsNanos(m protoreflect.Message) (int64, int64) {
+	fields := m.Descriptor().Fields()
+	return m.Get(fields.ByName("seconds")).Int(), m.Get(fields.ByName("nanos")).Int()
+}
+
+func encodeTime(b []byte, v any) ([]byte, error) {
+	var seconds, nanos int64
+	switch t := v.(type) {
+	case time.Time:
+		seconds, nanos = t.Unix(), int64(t.Nanosecond())
+	default:
+		m, ok := message(v)
+		if !ok {
+			return nil, fmt.Errorf("cannot store %T as time", v)
+		}
+		seconds, nanos = secondsNanos(m)
+	}
+	b = appendInt(b, seconds)
+	return binary.BigEndian.AppendUint32(b, uint32(nanos)), nil
+}
+
+func encodeDuration(b []byte, v any) ([]byte, error) {
+	var seconds, nanos int64
+	switch d := v.(type) {
+	case time.Duration:
+		seconds, nanos = int64(d/time.Second), int64(d%time.Second)
+	default:
+		m, ok := message(v)
+		if !ok {
+			return nil, fmt.Errorf("cannot store %T as duration", v)
+		}
+		seconds, nanos = secondsNanos(m)
+	}
+	return appendInt(appendInt(b, seconds), nanos), nil
+}
+
+func encodeJSON(b []byte, v any) ([]byte, error) {
+	var text []byte
+	var err error
+	switch j := v.(type) {
+	case json.RawMessage:
+		text = j
+	default:
+		if m, ok := message(v); ok {
+			text, err = protojson.Marshal(m.Interface())
+		} else {
+			text, err = json.Marshal(v)
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	return appendBytes(b, text), nil
+}
+
+func encodeAny(b []byte, v any) ([]byte, error) {
+	var a AnyValue
+	switch t := v.(type) {
+	case AnyValue:
+		a = t
+	default:
+		m, ok := message(v)
+		if !ok {
+			return nil, fmt.Errorf("cannot store %T as any", v)
+		}
+		fields := m.Descriptor().Fields()
+		a.TypeURL = m.Get(fields.ByName("type_url")).String()
+		a.Value = m.Get(fields.ByName("value")).Bytes()
+	}
+	return appendBytes(appendBytes(b, []byte(a.TypeURL)), a.Value), nil
+}
+
+func encodeOpaque(b []byte, v any) ([]byte, error) {
+	if raw, ok := v.([]byte); ok {
+		return appendBytes(b, raw), nil
+	}
+	m, ok := message(v)
+	if !ok {
+		return nil, fmt.Errorf("cannot store %T, expected a message or its encoding", v)
+	}
+	raw, err := proto.MarshalOptions{Deterministic: true}.Marshal(m.Interface())
+	if err != nil {
+		return nil, err
+	}
+	return appendBytes(b, raw), nil
+}
+
+func encodeScalar(b []byte, kind string, v any) ([]byte, error) {
+	if v == nil {
+		return nil, errNullColumn
+	}
+	if e, ok := v.(protoreflect.EnumNumber); ok {
+		v = int32(e)
+	}
+
+	switch kind {
+	case "bool":
+		if v, ok := v.(bool); ok {
+			if v {
+				return append(b, 0x01), nil
+			}
+			return append(b, 0x00), nil
+		}
+	case "int32", "sint32", "sfixed32", "int64", "sint64", "sfixed64", "enum":
+		switch v := v.(type) {
+		case int32:
+			return appendInt(b, int64(v)), nil
+		case int64:
+			return appendInt(b, v), nil
+		case int:
+			return appendInt(b, int64(v)), nil
+		}
+	case "uint32", "fixed32", "uint64", "fixed64":
+		switch v := v.(type) {
+		case uint32:
+			return binary.BigEndian.AppendUint64(b, uint64(v)), nil
+		case uint64:
+			return binary.BigEndian.AppendUint64(b, v), nil
+		}
+	case "float", "double":
+		switch v := v.(type) {
+		case float32:
+			return appendFloat(b, float64(v)), nil
+		case float64:
+			return appendFloat(b, v), nil
+		}
+	case "string":
+		if v, ok := v.(string); ok {
+			return appendBytes(b, []byte(v)), nil
+		}
+	case "bytes":
+		if v, ok := v.([]byte); ok {
+			return appendBytes(b, v), nil
+		}
+	}
+	return nil, fmt.Errorf("cannot store %T as %v", v, kind)
+}
+
+// decodeScalar returns values of the Go type protoreflect uses for the kind.
+func decodeScalar(b []byte, kind string) (any, []byte, error) {
+	switch kind {
+	case "bool":
+		if len(b) < 1 {
+			break
+		}
+		return b[0] == 0x01, b[1:], nil
+	case "int32", "sint32", "sfixed32", "enum":
+		v, rest, err := decodeInt(b)
+		if err != nil {
+			break
+		}
+		if kind == "enum" {
+			return protoreflect.EnumNumber(v), rest, nil
+		}
+		return int32(v), rest, nil
+	case "int64", "sint64", "sfixed64":
+		v, rest, err := decodeInt(b)
+		if err != nil {
+			break
+		}
+		return v, rest, nil
+	case "uint32", "fixed32", "uint64", "fixed64":
+		if len(b) < 8 {
+			break
+		}
+		v := binary.BigEndian.Uint64(b)
+		if kind == "uint32" || kind == "fixed32" {
+			return uint32(v), b[8:], nil
+		}
+		return v, b[8:], nil
+	case "float", "double":
+		if len(b) < 8 {
+			break
+		}
+		bits := binary.BigEndian.Uint64(b)
+		if bits&(1<<63) != 0 {
+			bits ^= 1 << 63
+		} else {
+			bits = ^bits
+		}
+		v := math.Float64frombits(bits)
+		if kind == "float" {
+			return float32(v), b[8:], nil
+		}
+		return v, b[8:], nil
+	case "string":
+		v, rest, err := decodeBytes(b)
+		if err != nil {
+			return nil, nil, err
+		}
+		return string(v.([]byte)), rest, nil
+	case "bytes":
+		return decodeBytes(b)
+	default:
+		return nil, nil, fmt.Errorf("unknown scalar kind %q", kind)
+	}
+	return nil, nil, fmt.Errorf("truncated %v value", kind)
+}
+
+func appendInt(b []byte, v int64) []byte {
+	return binary.BigEndian.AppendUint64(b, uint64(v)^(1<<63))
+}
+
+func decodeInt(b []byte) (int64, []byte, error) {
+	if len(b) < 8 {
+		return 0, nil, errors.New("truncated integer")
+	}
+	return int64(binary.BigEndian.Uint64(b) ^ (1 << 63)), b[8:], nil
+}
+
+func appendFloat(b []byte, v float64) []byte {
+	bits := math.Float64bits(v)
+	if bits&(1<<63) == 0 {
+		bits ^= 1 << 63
+	} else {
+		bits = ^bits
+	}
+	return binary.BigEndian.AppendUint64(b, bits)
+}
+
+func appendBytes(b, v []byte) []byte {
+	for _, c := range v {
+		if c == 0x00 {
+			b = append(b, 0x00, 0xFF)
+			continue
+		}
+		b = append(b, c)
+	}
+	return append(b, 0x00, 0x01)
+}
+
+func decodeBytes(b []byte) (any, []byte, error) {
+	out := []byte{}
+	for i := 0; i < len(b); i++ {
+		if b[i] != 0x00 {
+			out = append(out, b[i])
+			continue
+		}
+		if i+1 == len(b) {
+			break
+		}
+		switch b[i+1] {
+		case 0x01:
+			return out, b[i+2:], nil
+		case 0xFF:
+			out = append(out, 0x00)
+			i++
+			continue
+		}
+		return nil, nil, errors.New("invalid escape in string column")
+	}
+	return nil, nil, errors.New("unterminated string column")
+}
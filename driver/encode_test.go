@@ -0,0 +1,141 @@
+package driver
+
+import (
+	"bytes"
+	"encoding/json"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/nam2184/storpc/storpc"
+	"google.golang.org/protobuf/encoding/protowire"
+	"google.golang.org/protobuf/types/known/anypb"
+	"google.golang.org/protobuf/types/known/durationpb"
+	"google.golang.org/protobuf/types/known/structpb"
+	"google.golang.org/protobuf/types/known/timestamppb"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+var eventMessage = storpc.Message{
+	Name:  "shop.Event",
+	Table: "events",
+	Fields: []storpc.Field{
+		{Name: "id", Type: "int64", Number: 1, Key: true},
+		{Name: "at", Type: "google.protobuf.Timestamp", Kind: storpc.KindMessage, Logical: storpc.LogicalTime, Number: 2},
+		{Name: "took", Type: "google.protobuf.Duration", Kind: storpc.KindMessage, Logical: storpc.LogicalDuration, Number: 3},
+		{Name: "retries", Type: "google.protobuf.Int32Value", Kind: storpc.KindMessage, Logical: storpc.LogicalNullable, Number: 4},
+		{Name: "attributes", Type: "google.protobuf.Struct", Kind: storpc.KindMessage, Logical: storpc.LogicalJSON, Number: 5},
+		{Name: "detail", Type: "google.protobuf.Any", Kind: storpc.KindMessage, Logical: storpc.LogicalAny, Number: 6},
+		{Name: "name", Type: "string", Number: 7},
+		{Name: "score", Type: "double", Number: 8},
+	},
+}
+
+func TestEncodeRowRoundTrip(t *testing.T) {
+	at := time.Date(2024, 3, 1, 12, 30, 0, 500, time.UTC)
+	attributes, _ := structpb.NewStruct(map[string]any{"source": "web"})
+	detail, _ := anypb.New(wrapperspb.String("hello"))
+
+	row := NewTableRowEntity(9, []any{
+		nil,
+		int64(9),
+		timestamppb.New(at),
+		durationpb.New(90 * time.Second),
+		wrapperspb.Int32(3),
+		attributes,
+		detail,
+		"a\x00b",
+		-1.5,
+	})
+
+	data, err := EncodeRow(&eventMessage, row)
+	if err != nil {
+		t.Fatalf("EncodeRow failed: %v", err)
+	}
+	decoded, err := DecodeRow(&eventMessage, 9, data)
+	if err != nil {
+		t.Fatalf("DecodeRow failed: %v", err)
+	}
+
+	want := []any{
+		nil,
+		int64(9),
+		at,
+		90 * time.Second,
+		int32(3),
+		json.RawMessage(`{"source":"web"}`),
+		AnyValue{TypeURL: detail.TypeUrl, Value: detail.Value},
+		"a\x00b",
+		-1.5,
+	}
+	if !reflect.DeepEqual(decoded.Columns(), want) {
+		t.Errorf("decoded %#v\nwant %#v", decoded.Columns(), want)
+	}
+}
+
+func TestDecodeRowFieldNumbers(t *testing.T) {
+	// 1<<32 + 1 truncates to the key field
+	for _, number := range []uint64{0, 1<<32 + 1, 1 << 29} {
+		data := protowire.AppendVarint(nil, number)
+		data = protowire.AppendVarint(data, 9)
+		if _, err := DecodeRow(&eventMessage, 9, data); err == nil {
+			t.Errorf("DecodeRow accepted field number %d", number)
+		}
+	}
+}
+
+func TestEncodeColumnOrder(t *testing.T) {
+	at := &eventMessage.Fields[1]
+	times := []any{
+		time.Date(1960, 1, 1, 0, 0, 0, 0, time.UTC),
+		time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC),
+		time.Date(2024, 1, 1, 0, 0, 0, 1, time.UTC),
+		timestamppb.New(time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)),
+	}
+	checkOrdered(t, at, times)
+
+	took := &eventMessage.Fields[2]
+	checkOrdered(t, took, []any{-1500 * time.Millisecond, -time.Second, 0 * time.Second, time.Nanosecond, time.Hour})
+
+	retries := &eventMessage.Fields[3]
+	checkOrdered(t, retries, []any{nil, wrapperspb.Int32(-4), int32(0), int32(7)})
+
+	score := &eventMessage.Fields[7]
+	checkOrdered(t, score, []any{-2.5, -0.5, 0.0, 0.25, 100.0})
+
+	name := &eventMessage.Fields[6]
+	checkOrdered(t, name, []any{"", "a", "a\x00", "a\x00b", "ab"})
+}
+
+func checkOrdered(t *testing.T, f *storpc.Field, values []any) {
+	t.Helper()
+
+	var prev []byte
+	for i, v := range values {
+		b, err := EncodeColumn(nil, f, v)
+		if err != nil {
+			t.Fatalf("%s: EncodeColumn(%v) failed: %v", f.Name, v, err)
+		}
+		if i > 0 && bytes.Compare(prev, b) >= 0 {
+			t.Errorf("%s: %v does not sort after %v", f.Name, v, values[i-1])
+		}
+		prev = b
+	}
+}
+
+func TestDecodeNullableColumn(t *testing.T) {
+	retries := &eventMessage.Fields[3]
+
+	b, err := EncodeColumn(nil, retries, nil)
+	if err != nil {
+		t.Fatalf("EncodeColumn failed: %v", err)
+	}
+	v, rest, err := DecodeColumn(b, retries)
+	if err != nil || v != nil || len(rest) != 0 {
+		t.Errorf("null decoded as %v, %v, %v", v, rest, err)
+	}
+
+	if _, err := EncodeColumn(nil, &eventMessage.Fields[6], nil); err == nil {
+		t.Errorf("expected error storing null in a string column")
+	}
+}
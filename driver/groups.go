@@ -0,0 +1,40 @@
+package driver
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+)
+
+// FileGroupStore keeps the key group ids of a storpc.GroupRegistry in a
+// JSON file next to the data.
+type FileGroupStore struct {
+	Path string
+}
+
+func (s FileGroupStore) LoadGroups() (map[string]uint32, error) {
+	data, err := os.ReadFile(s.Path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var groups map[string]uint32
+	if err := json.Unmarshal(data, &groups); err != nil {
+		return nil, fmt.Errorf("%s: %w", s.Path, err)
+	}
+	return groups, nil
+}
+
+// SaveGroups replaces the file atomically, as an id lost in a crash could
+// be handed to another group.
+func (s FileGroupStore) SaveGroups(groups map[string]uint32) error {
+	data, err := json.Marshal(groups)
+	if err != nil {
+		return err
+	}
+	return replaceFile(s.Path, data)
+}
@@ -0,0 +1,34 @@
+package driver
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/nam2184/storpc/storpc"
+)
+
+func TestFileGroupStore(t *testing.T) {
+	store := FileGroupStore{Path: filepath.Join(t.TempDir(), "groups.json")}
+
+	groups, err := storpc.NewGroupRegistry(store)
+	if err != nil {
+		t.Fatalf("NewGroupRegistry failed: %v", err)
+	}
+	shop, _ := groups.ID("shop")
+	users, _ := groups.ID("users")
+
+	// a restarted server sees the same ids and keeps counting after them
+	reopened, err := storpc.NewGroupRegistry(store)
+	if err != nil {
+		t.Fatalf("NewGroupRegistry failed: %v", err)
+	}
+	if id, _ := reopened.ID("users"); id != users {
+		t.Errorf("users = %d after reopening, want %d", id, users)
+	}
+	if id, _ := reopened.ID("shop"); id != shop {
+		t.Errorf("shop = %d after reopening, want %d", id, shop)
+	}
+	if id, _ := reopened.ID("tenant"); id != 3 {
+		t.Errorf("tenant = %d, want 3", id)
+	}
+}
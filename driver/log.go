@@ -0,0 +1,26 @@
+package driver
+
+import (
+	"log/slog"
+	"os"
+	"sync/atomic"
+
+	"github.com/nam2184/storpc/storpc"
+)
+
+var logger atomic.Pointer[slog.Logger]
+
+// stderr by default, stdout may carry a program's own output
+func init() {
+	SetLogConfig(&storpc.LogConfig{Output: os.Stderr, Level: slog.LevelInfo})
+}
+
+// SetLogConfig routes the driver's logs through config, as the driver
+// subsystem.
+func SetLogConfig(config *storpc.LogConfig) {
+	logger.Store(config.For(storpc.SubsystemDriver))
+}
+
+func log() *slog.Logger {
+	return logger.Load()
+}
@@ -0,0 +1,575 @@
+package driver
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+
+	"github.com/nam2184/storpc/storpc"
+)
+
+const (
+	ColumnAdd   uint8 = 0
+	ColumnDrop  uint8 = 1
+	ColumnWiden uint8 = 2
+)
+
+// RowStore is the table storage a migration reads and rewrites.
+type RowStore interface {
+	// ScanRows returns up to limit rows with an id greater than after, in id order.
+	ScanRows(table string, after uint32, limit int) ([]TableRowEntity, error)
+	PutRow(table string, row TableRowEntity) error
+	// DropTable removes a table. Dropping a table that does not exist is not
+	// an error, since a migration may drop again after a crash.
+	DropTable(table string) error
+}
+
+// ProgressStore keeps the checkpoint a migration resumes from after a crash.
+type ProgressStore interface {
+	LoadProgress() (*MigrationProgress, error) // nil when nothing was saved
+	SaveProgress(progress *MigrationProgress) error
+}
+
+type MigrationOptions struct {
+	Renames  map[string]string // old message full name to new full name
+	Defaults map[string]any    // value of an added field, keyed by message.field
+}
+
+type ColumnStep struct {
+	Number  int32
+	Action  uint8  // ColumnAdd, ColumnDrop or ColumnWiden
+	Default any    // ColumnAdd
+	From    string // ColumnWiden
+	To      string // ColumnWiden
+}
+
+type TableMigration struct {
+	Message string // full name in the new schema
+	Source  string // table rows are read from
+	Target  string // table rows are written to
+	Steps   []ColumnStep
+}
+
+type MigrationPlan struct {
+	ID     string // identifies the pair of schemas, progress of other plans is ignored
+	Tables []TableMigration
+}
+
+type MigrationProgress struct {
+	ID     string `json:"id"`
+	Table  int    `json:"table"`  // index into MigrationPlan.Tables
+	After  uint32 `json:"after"`  // last row id rewritten in that table
+	Copied bool   `json:"copied"` // every row of that table is rewritten, the source is left to drop
+	Rows   uint64 `json:"rows"`
+	Done   bool   `json:"done"`
+}
+
+// TableName is the storage name of a stored message.
+func TableName(m *storpc.Message) string {
+	if m.Table != "" {
+		return m.Table
+	}
+	return m.Name
+}
+
+// NewMigrationPlan works out how rows stored under from are rewritten for to.
+// Messages are matched by name unless renamed explicitly; only stored
+// messages are migrated. Changes rows cannot follow, such as a new key or a
+// narrowed type, fail the plan.
+func NewMigrationPlan(from, to *storpc.GenIR, options MigrationOptions) (*MigrationPlan, error) {
+	oldNames := make(map[string]string, len(options.Renames))
+	for oldName, newName := range options.Renames {
+		if from.Body.Message(oldName) == nil {
+			return nil, fmt.Errorf("renamed message %s not in the stored schema", oldName)
+		}
+		if to.Body.Message(newName) == nil {
+			return nil, fmt.Errorf("renamed message %s not in the new schema", newName)
+		}
+		oldNames[newName] = oldName
+	}
+
+	id, err := planID(from, to, options)
+	if err != nil {
+		return nil, err
+	}
+	plan := &MigrationPlan{ID: id}
+
+	for i := range to.Body.Messages {
+		nm := &to.Body.Messages[i]
+		if nm.Table == "" {
+			continue
+		}
+
+		oldName, renamed := oldNames[nm.Name]
+		if !renamed {
+			if _, moved := options.Renames[nm.Name]; moved {
+				// the name now belongs to a message renamed away from it
+				continue
+			}
+			oldName = nm.Name
+		}
+		om := from.Body.Message(oldName)
+		if om == nil || om.Table == "" {
+			continue
+		}
+
+		table, err := planTable(om, nm, options.Defaults)
+		if err != nil {
+			return nil, err
+		}
+		if len(table.Steps) > 0 || table.Source != table.Target {
+			plan.Tables = append(plan.Tables, table)
+		}
+	}
+
+	return plan, nil
+}
+
+func planTable(om, nm *storpc.Message, defaults map[string]any) (TableMigration, error) {
+	table := TableMigration{
+		Message: nm.Name,
+		Source:  TableName(om),
+		Target:  TableName(nm),
+	}
+
+	if !sameKey(om, nm) {
+		return table, fmt.Errorf("%s: key fields changed, rows cannot be migrated in place", nm.Name)
+	}
+
+	for i := range om.Fields {
+		of := &om.Fields[i]
+		nf := fieldByNumber(nm, of.Number)
+		switch {
+		case nf == nil:
+			table.Steps = append(table.Steps, ColumnStep{Number: of.Number, Action: ColumnDrop})
+		case of.Type == nf.Type && of.Cardinality == nf.Cardinality:
+		case of.Kind == storpc.KindScalar && of.Cardinality == nf.Cardinality && storpc.Widens(of.Type, nf.Type):
+			table.Steps = append(table.Steps, ColumnStep{Number: of.Number, Action: ColumnWiden, From: of.Type, To: nf.Type})
+		default:
+			return table, fmt.Errorf("%s.%s: cannot migrate %s to %s", nm.Name, nf.Name, of.Type, nf.Type)
+		}
+	}
+
+	for i := range nm.Fields {
+		nf := &nm.Fields[i]
+		if fieldByNumber(om, nf.Number) != nil {
+			continue
+		}
+		value, ok := defaults[nm.Name+"."+nf.Name]
+		if !ok && nf.HasDefault {
+			// declared defaults apply on read, the column stays unset
+			continue
+		}
+		if !ok {
+			value = zeroValue(nf)
+		}
+		table.Steps = append(table.Steps, ColumnStep{Number: nf.Number, Action: ColumnAdd, Default: value})
+	}
+
+	return table, nil
+}
+
+// Apply rewrites a row stored under the old schema. Applying it to a row
+// that was already rewritten changes nothing, so a batch interrupted between
+// writing rows and saving progress can simply run again.
+func (t *TableMigration) Apply(row TableRowEntity) TableRowEntity {
+	columns := append([]any(nil), row.columns...)
+
+	for _, step := range t.Steps {
+		for int(step.Number) >= len(columns) {
+			columns = append(columns, nil)
+		}
+		switch step.Action {
+		case ColumnAdd:
+			if columns[step.Number] == nil {
+				columns[step.Number] = step.Default
+			}
+		case ColumnDrop:
+			columns[step.Number] = nil
+		case ColumnWiden:
+			columns[step.Number] = widen(columns[step.Number], step.To)
+		}
+	}
+
+	for len(columns) > 0 && columns[len(columns)-1] == nil {
+		columns = columns[:len(columns)-1]
+	}
+
+	return NewTableRowEntity(row.id, columns)
+}
+
+// Migrator rewrites every table of a plan in the background, batch by batch,
+// saving progress after each batch. Readers that hit a row of a table that
+// has not been migrated yet pass it through Upgrade, and writers go through
+// PutRow, so serving continues while the migration runs.
+type Migrator struct {
+	plan     *MigrationPlan
+	rows     RowStore
+	progress ProgressStore
+	batch    int
+
+	// held by a batch from its scan until its progress is saved, and by
+	// every write, so a copied row never overwrites a newer one
+	writes sync.Mutex
+
+	mu    sync.Mutex
+	state MigrationProgress
+	err   error
+	done  chan struct{}
+}
+
+func NewMigrator(plan *MigrationPlan, rows RowStore, progress ProgressStore, batch int) *Migrator {
+	if batch <= 0 {
+		batch = 256
+	}
+	return &Migrator{
+		plan:     plan,
+		rows:     rows,
+		progress: progress,
+		batch:    batch,
+		state:    MigrationProgress{ID: plan.ID},
+	}
+}
+
+// Start resumes from the saved progress and runs the migration until it
+// completes or ctx is cancelled.
+func (m *Migrator) Start(ctx context.Context) error {
+	saved, err := m.progress.LoadProgress()
+	if err != nil {
+		return err
+	}
+	if saved != nil && saved.ID == m.plan.ID {
+		m.state = *saved
+	}
+
+	m.done = make(chan struct{})
+	go func() {
+		defer close(m.done)
+		err := m.run(ctx)
+
+		m.mu.Lock()
+		m.err = err
+		m.mu.Unlock()
+	}()
+
+	return nil
+}
+
+// Wait blocks until the migration stops and returns why it stopped.
+func (m *Migrator) Wait() error {
+	if m.done == nil {
+		return errors.New("migration not started")
+	}
+	<-m.done
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.err
+}
+
+func (m *Migrator) Progress() MigrationProgress {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.state
+}
+
+// Upgrade brings a row of the given source table up to the new schema.
+// Rows of tables the plan does not touch are returned unchanged.
+func (m *Migrator) Upgrade(table string, row TableRowEntity) TableRowEntity {
+	for i := range m.plan.Tables {
+		if m.plan.Tables[i].Source == table {
+			return m.plan.Tables[i].Apply(row)
+		}
+	}
+	return row
+}
+
+// PutRow writes a row of the new schema to the target table. Until that
+// table is copied the row goes to the source too, so the copy, or a copy
+// resumed after a crash, carries the latest value over.
+func (m *Migrator) PutRow(table string, row TableRowEntity) error {
+	if m.done == nil {
+		return errors.New("migration not started")
+	}
+
+	m.writes.Lock()
+	defer m.writes.Unlock()
+
+	if err := m.rows.PutRow(table, row); err != nil {
+		return err
+	}
+
+	state := m.Progress()
+	for i := range m.plan.Tables {
+		t := &m.plan.Tables[i]
+		if t.Target != table || t.Source == t.Target {
+			continue
+		}
+		if state.Done || i < state.Table || i == state.Table && state.Copied {
+			break
+		}
+		return m.rows.PutRow(t.Source, row)
+	}
+	return nil
+}
+
+// Dispatch hands calls of a server to handle along with the migrator as the
+// row store, so the writes they make are coordinated with the copy.
+func (m *Migrator) Dispatch(handle func(ctx context.Context, method *storpc.MethodIR, rows RowStore) error) storpc.Dispatch {
+	return func(ctx context.Context, method *storpc.MethodIR) error {
+		return handle(ctx, method, m)
+	}
+}
+
+func (m *Migrator) ScanRows(table string, after uint32, limit int) ([]TableRowEntity, error) {
+	return m.rows.ScanRows(table, after, limit)
+}
+
+func (m *Migrator) DropTable(table string) error {
+	return m.rows.DropTable(table)
+}
+
+func (m *Migrator) run(ctx context.Context) error {
+	for {
+		state := m.Progress()
+		if state.Done {
+			return nil
+		}
+		if state.Table >= len(m.plan.Tables) {
+			state.Done = true
+			if err := m.save(state); err != nil {
+				return err
+			}
+			log().Info("migration done", "id", m.plan.ID, "rows", state.Rows)
+			return nil
+		}
+
+		table := &m.plan.Tables[state.Table]
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		// the source is only dropped once the copy is saved as complete, and
+		// dropped again if a crash came before the next table was saved
+		if state.Copied {
+			if err := m.finish(table, state); err != nil {
+				return err
+			}
+			continue
+		}
+
+		if err := m.copyBatch(table, state); err != nil {
+			return err
+		}
+	}
+}
+
+func (m *Migrator) copyBatch(table *TableMigration, state MigrationProgress) error {
+	m.writes.Lock()
+	defer m.writes.Unlock()
+
+	n, err := m.copyRows(table, &state, m.batch)
+	if err != nil {
+		return err
+	}
+	log().Debug("migrated batch", "table", table.Target, "rows", n, "after", state.After)
+
+	if n < m.batch {
+		state.Copied = true
+	}
+	return m.save(state)
+}
+
+// finish makes a last pass over the source for rows that reached it after
+// the cursor, written around the migrator, then drops it. Writes wait until
+// the source is gone.
+func (m *Migrator) finish(table *TableMigration, state MigrationProgress) error {
+	m.writes.Lock()
+	defer m.writes.Unlock()
+
+	if table.Source != table.Target {
+		for {
+			n, err := m.copyRows(table, &state, m.batch)
+			if err != nil {
+				return err
+			}
+			if n < m.batch {
+				break
+			}
+		}
+		if err := m.rows.DropTable(table.Source); err != nil {
+			return fmt.Errorf("drop %s: %w", table.Source, err)
+		}
+	}
+
+	state.Table++
+	state.After = 0
+	state.Copied = false
+	return m.save(state)
+}
+
+func (m *Migrator) copyRows(table *TableMigration, state *MigrationProgress, limit int) (int, error) {
+	rows, err := m.rows.ScanRows(table.Source, state.After, limit)
+	if err != nil {
+		return 0, fmt.Errorf("scan %s: %w", table.Source, err)
+	}
+
+	for _, row := range rows {
+		if err := m.rows.PutRow(table.Target, table.Apply(row)); err != nil {
+			return 0, fmt.Errorf("rewrite %s row %d: %w", table.Target, row.id, err)
+		}
+		state.After = row.id
+		state.Rows++
+	}
+	return len(rows), nil
+}
+
+func (m *Migrator) save(state MigrationProgress) error {
+	if err := m.progress.SaveProgress(&state); err != nil {
+		return fmt.Errorf("save progress: %w", err)
+	}
+
+	m.mu.Lock()
+	m.state = state
+	m.mu.Unlock()
+	return nil
+}
+
+// FileProgressStore keeps migration progress in a JSON file next to the data.
+type FileProgressStore struct {
+	Path string
+}
+
+func (s FileProgressStore) LoadProgress() (*MigrationProgress, error) {
+	data, err := os.ReadFile(s.Path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	progress := &MigrationProgress{}
+	if err := json.Unmarshal(data, progress); err != nil {
+		return nil, fmt.Errorf("%s: %w", s.Path, err)
+	}
+	return progress, nil
+}
+
+// SaveProgress replaces the file atomically so a crash never leaves a
+// partial checkpoint behind.
+func (s FileProgressStore) SaveProgress(progress *MigrationProgress) error {
+	data, err := json.Marshal(progress)
+	if err != nil {
+		return err
+	}
+	return replaceFile(s.Path, data)
+}
+
+func planID(from, to *storpc.GenIR, options MigrationOptions) (string, error) {
+	h := sha256.New()
+	for _, gen := range []*storpc.GenIR{from, to} {
+		data, err := storpc.MarshalGenIR(gen)
+		if err != nil {
+			return "", err
+		}
+		h.Write(data)
+	}
+	renames, err := json.Marshal(options.Renames)
+	if err != nil {
+		return "", err
+	}
+	h.Write(renames)
+	defaults, err := json.Marshal(options.Defaults)
+	if err != nil {
+		return "", err
+	}
+	h.Write(defaults)
+
+	return hex.EncodeToString(h.Sum(nil)[:8]), nil
+}
+
+func sameKey(om, nm *storpc.Message) bool {
+	oldKey, newKey := om.KeyFields(), nm.KeyFields()
+	if len(oldKey) != len(newKey) {
+		return false
+	}
+	for i := range oldKey {
+		if oldKey[i].Number != newKey[i].Number || oldKey[i].Type != newKey[i].Type {
+			return false
+		}
+	}
+	return true
+}
+
+func fieldByNumber(m *storpc.Message, number int32) *storpc.Field {
+	for i := range m.Fields {
+		if m.Fields[i].Number == number {
+			return &m.Fields[i]
+		}
+	}
+	return nil
+}
+
+// zeroValue is what an added field holds in existing rows when no default is
+// given. Fields with presence stay unset.
+func zeroValue(f *storpc.Field) any {
+	if f.HasPresence || f.Cardinality == storpc.CardinalityRepeated {
+		return nil
+	}
+	switch f.Kind {
+	case storpc.KindEnum:
+		return int32(0)
+	case storpc.KindMessage:
+		return nil
+	}
+	switch f.Type {
+	case "bool":
+		return false
+	case "int32", "sint32", "sfixed32":
+		return int32(0)
+	case "int64", "sint64", "sfixed64":
+		return int64(0)
+	case "uint32", "fixed32":
+		return uint32(0)
+	case "uint64", "fixed64":
+		return uint64(0)
+	case "float":
+		return float32(0)
+	case "double":
+		return float64(0)
+	case "string":
+		return ""
+	case "bytes":
+		return []byte{}
+	}
+	return nil
+}
+
+// widen converts a stored value to the Go type of its wider field type.
+// Values that were already converted are returned as they are.
+func widen(v any, to string) any {
+	switch to {
+	case "int64", "sint64":
+		switch x := v.(type) {
+		case int32:
+			return int64(x)
+		case uint32:
+			return int64(x)
+		}
+	case "uint64":
+		if x, ok := v.(uint32); ok {
+			return uint64(x)
+		}
+	case "double":
+		if x, ok := v.(float32); ok {
+			return float64(x)
+		}
+	}
+	return v
+}
@@ -0,0 +1,381 @@
+package driver
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"slices"
+	"sort"
+	"sync"
+	"testing"
+
+	"github.com/nam2184/storpc/storpc"
+)
+
+type memoryRowStore struct {
+	mu     sync.Mutex
+	tables map[string]map[uint32]TableRowEntity
+}
+
+func newMemoryRowStore() *memoryRowStore {
+	return &memoryRowStore{tables: make(map[string]map[uint32]TableRowEntity)}
+}
+
+func (s *memoryRowStore) ScanRows(table string, after uint32, limit int) ([]TableRowEntity, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var ids []uint32
+	for id := range s.tables[table] {
+		if id > after {
+			ids = append(ids, id)
+		}
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	if len(ids) > limit {
+		ids = ids[:limit]
+	}
+
+	rows := make([]TableRowEntity, 0, len(ids))
+	for _, id := range ids {
+		rows = append(rows, s.tables[table][id])
+	}
+	return rows, nil
+}
+
+func (s *memoryRowStore) PutRow(table string, row TableRowEntity) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.tables[table] == nil {
+		s.tables[table] = make(map[uint32]TableRowEntity)
+	}
+	s.tables[table][row.ID()] = row
+	return nil
+}
+
+func (s *memoryRowStore) DropTable(table string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.tables, table)
+	return nil
+}
+
+// crashingRowStore crashes the migration on the first drop of a table.
+type crashingRowStore struct {
+	*memoryRowStore
+	crashed bool
+}
+
+func (s *crashingRowStore) DropTable(table string) error {
+	if !s.crashed {
+		s.crashed = true
+		return errors.New("crash")
+	}
+	return s.memoryRowStore.DropTable(table)
+}
+
+// failingProgressStore crashes the migration after a number of saves.
+type failingProgressStore struct {
+	FileProgressStore
+	saves int
+}
+
+func (s *failingProgressStore) SaveProgress(progress *MigrationProgress) error {
+	if s.saves == 0 {
+		return errors.New("crash")
+	}
+	s.saves--
+	return s.FileProgressStore.SaveProgress(progress)
+}
+
+func schema(messages ...storpc.Message) *storpc.GenIR {
+	body := storpc.NewGenBody()
+	body.Messages = messages
+	body.Reindex()
+	return storpc.NewGenIR(storpc.NewGenHeader(uint32(len(messages)), 0), body)
+}
+
+func migrationSchemas() (*storpc.GenIR, *storpc.GenIR) {
+	from := schema(storpc.Message{
+		Name:  "shop.Customer",
+		Table: "customers",
+		Fields: []storpc.Field{
+			{Name: "id", Type: "int64", Number: 1, Key: true},
+			{Name: "visits", Type: "int32", Number: 2},
+			{Name: "legacy", Type: "string", Number: 3},
+		},
+	})
+	to := schema(storpc.Message{
+		Name:  "shop.Client",
+		Table: "clients",
+		Fields: []storpc.Field{
+			{Name: "id", Type: "int64", Number: 1, Key: true},
+			{Name: "visits", Type: "int64", Number: 2},
+			{Name: "tier", Type: "string", Number: 4},
+			{Name: "score", Type: "double", Number: 5},
+		},
+	})
+	return from, to
+}
+
+func TestMigrationPlan(t *testing.T) {
+	from, to := migrationSchemas()
+
+	plan, err := NewMigrationPlan(from, to, MigrationOptions{
+		Renames:  map[string]string{"shop.Customer": "shop.Client"},
+		Defaults: map[string]any{"shop.Client.tier": "bronze"},
+	})
+	if err != nil {
+		t.Fatalf("NewMigrationPlan failed: %v", err)
+	}
+	if len(plan.Tables) != 1 {
+		t.Fatalf("expected one table, got %+v", plan.Tables)
+	}
+
+	table := plan.Tables[0]
+	if table.Source != "customers" || table.Target != "clients" {
+		t.Errorf("tables %s -> %s", table.Source, table.Target)
+	}
+
+	row := table.Apply(NewTableRowEntity(7, []any{nil, int64(7), int32(3), "old"}))
+	want := []any{nil, int64(7), int64(3), nil, "bronze", float64(0)}
+	if !slices.Equal(row.Columns(), want) {
+		t.Errorf("columns = %#v, want %#v", row.Columns(), want)
+	}
+	if again := table.Apply(row); !slices.Equal(again.Columns(), want) {
+		t.Errorf("Apply is not idempotent: %#v", again.Columns())
+	}
+
+	if _, err := NewMigrationPlan(from, to, MigrationOptions{}); err != nil {
+		t.Errorf("unrelated tables should plan nothing, got %v", err)
+	}
+
+	narrowed := schema(storpc.Message{
+		Name:  "shop.Customer",
+		Table: "customers",
+		Fields: []storpc.Field{
+			{Name: "id", Type: "int64", Number: 1, Key: true},
+			{Name: "visits", Type: "string", Number: 2},
+		},
+	})
+	if _, err := NewMigrationPlan(from, narrowed, MigrationOptions{}); err == nil {
+		t.Errorf("expected error for incompatible type change")
+	}
+}
+
+// A change the compat checker calls breaking is exactly one a plan rejects.
+func TestMigrationPlanMatchesCompat(t *testing.T) {
+	table := func(fields ...storpc.Field) *storpc.GenIR {
+		fields = append([]storpc.Field{{Name: "id", Type: "int64", Number: 1, Key: true}}, fields...)
+		return schema(storpc.Message{Name: "m.Reading", Table: "readings", Fields: fields})
+	}
+	tests := []struct {
+		name     string
+		from, to storpc.Field
+	}{
+		{"widened", storpc.Field{Name: "count", Type: "int32", Number: 2}, storpc.Field{Name: "count", Type: "int64", Number: 2}},
+		{"float to double", storpc.Field{Name: "celsius", Type: "float", Number: 2}, storpc.Field{Name: "celsius", Type: "double", Number: 2}},
+		{"narrowed", storpc.Field{Name: "count", Type: "int64", Number: 2}, storpc.Field{Name: "count", Type: "int32", Number: 2}},
+		{"string to bytes", storpc.Field{Name: "label", Type: "string", Number: 2}, storpc.Field{Name: "label", Type: "bytes", Number: 2}},
+		{"key retyped", storpc.Field{Name: "seq", Type: "int32", Number: 2, Key: true}, storpc.Field{Name: "seq", Type: "int64", Number: 2, Key: true}},
+		{"renamed", storpc.Field{Name: "label", Type: "string", Number: 2}, storpc.Field{Name: "title", Type: "string", Number: 2}},
+	}
+
+	for _, tt := range tests {
+		from, to := table(tt.from), table(tt.to)
+		_, err := NewMigrationPlan(from, to, MigrationOptions{})
+		report := storpc.CompareGenIR(from, to)
+		if report.Breaking() != (err != nil) {
+			t.Errorf("%s: compat breaking = %v, plan error = %v: %v", tt.name, report.Breaking(), err, report.Changes)
+		}
+	}
+}
+
+func TestMigratorResumesAfterCrash(t *testing.T) {
+	from, to := migrationSchemas()
+	plan, err := NewMigrationPlan(from, to, MigrationOptions{
+		Renames: map[string]string{"shop.Customer": "shop.Client"},
+	})
+	if err != nil {
+		t.Fatalf("NewMigrationPlan failed: %v", err)
+	}
+
+	rows := newMemoryRowStore()
+	for id := uint32(1); id <= 10; id++ {
+		rows.PutRow("customers", NewTableRowEntity(id, []any{nil, int64(id), int32(id)}))
+	}
+
+	path := filepath.Join(t.TempDir(), "migration.json")
+	crashing := &failingProgressStore{FileProgressStore: FileProgressStore{Path: path}, saves: 1}
+
+	migrator := NewMigrator(plan, rows, crashing, 4)
+	if err := migrator.Start(context.Background()); err != nil {
+		t.Fatalf("Start failed: %v", err)
+	}
+	if err := migrator.Wait(); err == nil {
+		t.Fatalf("expected crash")
+	}
+
+	saved, err := FileProgressStore{Path: path}.LoadProgress()
+	if err != nil || saved == nil {
+		t.Fatalf("no progress saved: %v", err)
+	}
+	if saved.After != 4 || saved.Done {
+		t.Errorf("saved progress = %+v", saved)
+	}
+
+	// a row read while the migration is pending is upgraded on the fly
+	pending := migrator.Upgrade("customers", rows.tables["customers"][9])
+	if pending.Column(2) != int64(9) {
+		t.Errorf("Upgrade column 2 = %#v", pending.Column(2))
+	}
+
+	migrator = NewMigrator(plan, rows, FileProgressStore{Path: path}, 4)
+	if err := migrator.Start(context.Background()); err != nil {
+		t.Fatalf("Start failed: %v", err)
+	}
+	if err := migrator.Wait(); err != nil {
+		t.Fatalf("migration failed: %v", err)
+	}
+
+	progress := migrator.Progress()
+	if !progress.Done || progress.Rows != 10 {
+		t.Errorf("progress = %+v", progress)
+	}
+	if len(rows.tables["clients"]) != 10 {
+		t.Errorf("migrated %d rows, want 10", len(rows.tables["clients"]))
+	}
+	if _, ok := rows.tables["customers"]; ok {
+		t.Errorf("renamed source table not dropped")
+	}
+	if got := rows.tables["clients"][10].Column(2); got != int64(10) {
+		t.Errorf("column 2 = %#v, want widened int64", got)
+	}
+}
+
+func TestMigratorResumesAroundDrop(t *testing.T) {
+	from, to := migrationSchemas()
+	plan, err := NewMigrationPlan(from, to, MigrationOptions{
+		Renames: map[string]string{"shop.Customer": "shop.Client"},
+	})
+	if err != nil {
+		t.Fatalf("NewMigrationPlan failed: %v", err)
+	}
+
+	rows := &crashingRowStore{memoryRowStore: newMemoryRowStore()}
+	for id := uint32(1); id <= 3; id++ {
+		rows.PutRow("customers", NewTableRowEntity(id, []any{nil, int64(id), int32(id)}))
+	}
+	path := filepath.Join(t.TempDir(), "migration.json")
+
+	// the crash comes after the copy is saved and before the drop
+	migrator := NewMigrator(plan, rows, FileProgressStore{Path: path}, 4)
+	if err := migrator.Start(context.Background()); err != nil {
+		t.Fatalf("Start failed: %v", err)
+	}
+	if err := migrator.Wait(); err == nil {
+		t.Fatalf("expected crash")
+	}
+	saved, err := FileProgressStore{Path: path}.LoadProgress()
+	if err != nil || saved == nil || !saved.Copied || saved.Table != 0 {
+		t.Fatalf("saved progress = %+v, %v", saved, err)
+	}
+	if len(rows.tables["customers"]) != 3 {
+		t.Fatalf("source dropped before the crash")
+	}
+
+	// the next crash comes after the drop and before the progress is saved
+	crashing := &failingProgressStore{FileProgressStore: FileProgressStore{Path: path}}
+	migrator = NewMigrator(plan, rows, crashing, 4)
+	if err := migrator.Start(context.Background()); err != nil {
+		t.Fatalf("Start failed: %v", err)
+	}
+	if err := migrator.Wait(); err == nil {
+		t.Fatalf("expected crash")
+	}
+	if _, ok := rows.tables["customers"]; ok {
+		t.Fatalf("source not dropped")
+	}
+
+	migrator = NewMigrator(plan, rows, FileProgressStore{Path: path}, 4)
+	if err := migrator.Start(context.Background()); err != nil {
+		t.Fatalf("Start failed: %v", err)
+	}
+	if err := migrator.Wait(); err != nil {
+		t.Fatalf("migration failed after resuming into a dropped table: %v", err)
+	}
+	if progress := migrator.Progress(); !progress.Done || progress.Rows != 3 {
+		t.Errorf("progress = %+v", progress)
+	}
+	if len(rows.tables["clients"]) != 3 {
+		t.Errorf("migrated %d rows, want 3", len(rows.tables["clients"]))
+	}
+}
+
+func TestMigratorKeepsConcurrentWrites(t *testing.T) {
+	from, to := migrationSchemas()
+	plan, err := NewMigrationPlan(from, to, MigrationOptions{
+		Renames: map[string]string{"shop.Customer": "shop.Client"},
+	})
+	if err != nil {
+		t.Fatalf("NewMigrationPlan failed: %v", err)
+	}
+
+	rows := newMemoryRowStore()
+	for id := uint32(1); id <= 200; id++ {
+		rows.PutRow("customers", NewTableRowEntity(id, []any{nil, int64(id), int32(id)}))
+	}
+
+	migrator := NewMigrator(plan, rows, FileProgressStore{Path: filepath.Join(t.TempDir(), "migration.json")}, 3)
+	if err := migrator.Start(context.Background()); err != nil {
+		t.Fatalf("Start failed: %v", err)
+	}
+
+	// every row is rewritten while the copy runs, and new rows are added
+	// on both sides of the cursor
+	for id := uint32(1); id <= 300; id += 2 {
+		if err := migrator.PutRow("clients", NewTableRowEntity(id, []any{nil, int64(id), int64(-1)})); err != nil {
+			t.Fatalf("PutRow failed: %v", err)
+		}
+	}
+	if err := migrator.Wait(); err != nil {
+		t.Fatalf("migration failed: %v", err)
+	}
+
+	if _, ok := rows.tables["customers"]; ok {
+		t.Errorf("source table not dropped")
+	}
+	for id := uint32(1); id <= 300; id++ {
+		row, ok := rows.tables["clients"][id]
+		switch {
+		case id%2 == 1 && (!ok || row.Column(2) != int64(-1)):
+			t.Errorf("row %d = %v, write lost", id, row.columns)
+		case id%2 == 0 && id <= 200 && (!ok || row.Column(2) != int64(id)):
+			t.Errorf("row %d = %v, not migrated", id, row.columns)
+		case id%2 == 0 && id > 200 && ok:
+			t.Errorf("row %d was never written", id)
+		}
+	}
+}
+
+func TestPlanIDCoversDefaults(t *testing.T) {
+	from, to := migrationSchemas()
+	renames := map[string]string{"shop.Customer": "shop.Client"}
+
+	plain, err := NewMigrationPlan(from, to, MigrationOptions{Renames: renames})
+	if err != nil {
+		t.Fatalf("NewMigrationPlan failed: %v", err)
+	}
+	defaulted, err := NewMigrationPlan(from, to, MigrationOptions{
+		Renames:  renames,
+		Defaults: map[string]any{"shop.Client.tier": "gold"},
+	})
+	if err != nil {
+		t.Fatalf("NewMigrationPlan failed: %v", err)
+	}
+	if plain.ID == defaulted.ID {
+		t.Errorf("plans with different defaults share the id %s", plain.ID)
+	}
+}
@@ -1,8 +1,6 @@
 package driver
 
 import (
-	"fmt"
-
 	"github.com/nam2184/storpc/driver/types"
 )
 
@@ -21,12 +19,12 @@ func (h *PageHeader) Type() types.PageContentType {
 
 func (h *PageHeader) Read() error {
 	// placeholder read
-	fmt.Println("Reading PageHeader")
+	log().Debug("reading page header")
 	return nil
 }
 
 func (h *PageHeader) Write() error {
 	// placeholder write
-	fmt.Println("Writing PageHeader")
+	log().Debug("writing page header")
 	return nil
 }
@@ -4,3 +4,26 @@ type TableRowEntity struct {
 	id      uint32
 	columns []any //index is .proto defined column number
 }
+
+func NewTableRowEntity(id uint32, columns []any) TableRowEntity {
+	return TableRowEntity{
+		id:      id,
+		columns: columns,
+	}
+}
+
+func (r TableRowEntity) ID() uint32 {
+	return r.id
+}
+
+func (r TableRowEntity) Columns() []any {
+	return r.columns
+}
+
+// Column returns the value stored for a field number, nil if unset.
+func (r TableRowEntity) Column(number int32) any {
+	if number < 0 || int(number) >= len(r.columns) {
+		return nil
+	}
+	return r.columns[number]
+}
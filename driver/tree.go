@@ -39,29 +39,29 @@ func (bt *MemoryBTree) Root() types.PageNode {
 }
 
 func (bt *MemoryBTree) Insert(key uint32) {
-	fmt.Println("Insert called on MemoryBTree with key:", key)
+	log().Debug("insert", "tree", "MemoryBTree", "key", key)
 
 	bt.size++
 }
 
 func (bt *MemoryBTree) Delete(key uint32) {
-	fmt.Println("Delete called on MemoryBTree with key:", key)
+	log().Debug("delete", "tree", "MemoryBTree", "key", key)
 	// TODO: implement split/insert logic
 
 	bt.size--
 }
 
 func (bt *MemoryBTree) Search(key uint32) bool {
-	fmt.Println("Search called on MemoryBTree for key:", key)
+	log().Debug("search", "tree", "MemoryBTree", "key", key)
 	return false
 }
 
 func (bt *MemoryBTree) Traverse(fn func(types.PageNode)) {
-	fmt.Println("Traverse called")
+	log().Debug("traverse")
 }
 
 func (bt *MemoryBTree) Balance() error {
-	fmt.Println("Balance called")
+	log().Debug("balance")
 	return nil
 }
 
@@ -98,13 +98,13 @@ func NewDiskBTree(m int) *DiskBTree {
 }
 
 func (bt *DiskBTree) Root() types.PageNode {
-	fmt.Println("Root called on DiskyBTree")
+	log().Debug("root", "tree", "DiskBTree")
 	// TODO: implement split/insert logic
 	return bt.root
 }
 
 func (bt *DiskBTree) Insert(key types.PageContent) (types.PageContent, error) {
-	fmt.Println("Insert called on DiskBTree with key:", key)
+	log().Debug("insert", "tree", "DiskBTree", "key", key)
 	if key == nil {
 		return nil, fmt.Errorf("no key found")
 	}
@@ -130,23 +130,23 @@ func (bt *DiskBTree) Insert(key types.PageContent) (types.PageContent, error) {
 }
 
 func (bt *DiskBTree) Delete(key types.PageContent) {
-	fmt.Println("Delete called on DiskBTree with key:", key)
+	log().Debug("delete", "tree", "DiskBTree", "key", key)
 	// TODO: implement split/insert logic
 
 	bt.size--
 }
 
 func (bt *DiskBTree) Search(key uint32) bool {
-	fmt.Println("Search called on DiskBTree for key:", key)
+	log().Debug("search", "tree", "DiskBTree", "key", key)
 	return false
 }
 
 func (bt *DiskBTree) Traverse(fn func(types.PageNode)) {
-	fmt.Println("Traverse called")
+	log().Debug("traverse")
 }
 
 func (bt *DiskBTree) Balance() error {
-	fmt.Println("Balance called")
+	log().Debug("balance")
 	return nil
 }
 
@@ -188,7 +188,7 @@ func (p *MemoryPage) Size() uint8 {
 
 func (p *MemoryPage) Write() error {
 	// placeholder: in-memory write is trivial
-	fmt.Println("MemoryPage written:", p.id)
+	log().Debug("memory page written", "page", p.id)
 
 	return nil
 }
@@ -235,7 +235,7 @@ func (p *DiskPage) Size() uint8 {
 
 func (p *DiskPage) Write() error {
 	// placeholder: disk write simulation
-	fmt.Println("DiskPage written:", p.id)
+	log().Debug("disk page written", "page", p.id)
 	return nil
 }
 
@@ -1,5 +1,10 @@
 package driver
 
+import (
+	"os"
+	"path/filepath"
+)
+
 func Truncate[T any](s *[]T, index int) {
 	if index < 0 || index > len(*s) {
 		panic("truncate: index out of range")
@@ -11,3 +16,35 @@ func Truncate[T any](s *[]T, index int) {
 		toClear[i] = zero
 	}
 }
+
+// replaceFile writes data to a temporary file, syncs it and renames it over
+// path, so a crash leaves either the old or the new content on disk.
+func replaceFile(path string, data []byte) error {
+	tmp := path + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return err
+	}
+
+	// the rename is only durable once the directory is synced, which not
+	// every platform supports
+	if dir, err := os.Open(filepath.Dir(path)); err == nil {
+		dir.Sync()
+		dir.Close()
+	}
+	return nil
+}
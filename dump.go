@@ -0,0 +1,102 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/nam2184/storpc/driver"
+	"github.com/nam2184/storpc/storpc"
+)
+
+// flags of dump
+const (
+	Schema storpc.ParseArgs = "--schema" // schema frames are decoded with, for field and enum names
+	Groups storpc.ParseArgs = "--groups" // key group file of the storage, for group names
+)
+
+const dumpUsage = "dump [--format text|json] [--schema SCHEMA] [--groups file] [--proto_path dirs] FILE"
+
+// runDump prints a schema, a MethodIR frame or a batch of them. FILE is
+// read as a frame when it starts with a frame magic, as a schema otherwise.
+func runDump(args map[storpc.ParseArgs]string, positional []string, stdout, stderr io.Writer) int {
+	if len(positional) != 1 {
+		fmt.Fprintln(stderr, "usage: storpc "+dumpUsage)
+		return exitUsage
+	}
+	format := args[Format]
+	if format != "" && format != "text" && format != "json" {
+		fmt.Fprintf(stderr, "unknown format %q\n", format)
+		return exitUsage
+	}
+
+	data, err := os.ReadFile(positional[0])
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return exitUsage
+	}
+
+	var dump storpc.MethodDump
+	if args[Schema] != "" {
+		gen, err := loadSchema(args, args[Schema], stderr)
+		if err != nil {
+			fmt.Fprintf(stderr, "%v: %v\n", args[Schema], err)
+			return exitUsage
+		}
+		dump.Schema = gen.Body
+	}
+	if args[Groups] != "" {
+		dump.Groups, err = storpc.NewGroupRegistry(driver.FileGroupStore{Path: args[Groups]})
+		if err != nil {
+			fmt.Fprintf(stderr, "%v: %v\n", args[Groups], err)
+			return exitUsage
+		}
+	}
+
+	if ir, err := storpc.UnmarshalMethodIR(dump.Schema, data); !errors.Is(err, storpc.ErrNotMethodIR) {
+		if err != nil {
+			fmt.Fprintf(stderr, "%v: %v\n", positional[0], err)
+			return exitFail
+		}
+		if format == "json" {
+			err = dump.WriteJSON(stdout, ir)
+		} else {
+			err = dump.WriteText(stdout, ir)
+		}
+		return writeResult(err, stderr)
+	}
+
+	if batch, err := storpc.UnmarshalMethodBatch(dump.Schema, data); !errors.Is(err, storpc.ErrNotMethodBatch) {
+		if err != nil {
+			fmt.Fprintf(stderr, "%v: %v\n", positional[0], err)
+			return exitFail
+		}
+		if format == "json" {
+			err = dump.WriteBatchJSON(stdout, batch)
+		} else {
+			err = dump.WriteBatchText(stdout, batch)
+		}
+		return writeResult(err, stderr)
+	}
+
+	gen, err := loadSchema(args, positional[0], stderr)
+	if err != nil {
+		fmt.Fprintf(stderr, "%v: %v\n", positional[0], err)
+		return exitUsage
+	}
+	if format == "json" {
+		err = gen.WriteJSON(stdout)
+	} else {
+		err = gen.WriteText(stdout)
+	}
+	return writeResult(err, stderr)
+}
+
+func writeResult(err error, stderr io.Writer) int {
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return exitFail
+	}
+	return exitOK
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/nam2184/storpc/storpc"
+)
+
+// Out is where generated code is written, stdout if empty.
+const Out storpc.ParseArgs = "--out"
+
+const genUsage = "gen --go_package name [--out file] [--proto_path dirs] SCHEMA"
+
+func runGen(args map[storpc.ParseArgs]string, positional []string, stdout, stderr io.Writer) int {
+	if len(positional) != 1 || args[storpc.GoPackage] == "" {
+		fmt.Fprintln(stderr, "usage: storpc "+genUsage)
+		return exitUsage
+	}
+
+	gen, err := loadSchema(args, positional[0], stderr)
+	if err != nil {
+		fmt.Fprintf(stderr, "%v: %v\n", positional[0], err)
+		return exitUsage
+	}
+
+	src, err := storpc.GenerateGoClient(gen, args[storpc.GoPackage])
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return exitFail
+	}
+
+	if args[Out] == "" {
+		_, err = stdout.Write(src)
+	} else {
+		err = os.WriteFile(args[Out], src, 0644)
+	}
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return exitFail
+	}
+	return exitOK
+}
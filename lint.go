@@ -0,0 +1,85 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/nam2184/storpc/storpc"
+)
+
+// flags of lint
+const (
+	Rules     storpc.ParseArgs = "--rules"      // rule severities, RULE=error|warning|info|off
+	HotTables storpc.ParseArgs = "--hot_tables" // tables checked for unbounded repeated fields
+	FailOn    storpc.ParseArgs = "--fail_on"    // lowest severity that fails the run, error if empty
+)
+
+const lintUsage = "lint [--proto_path dirs] [--format text|json] [--rules RULE=severity|off,...] [--hot_tables tables] [--fail_on error|warning|info] SCHEMA"
+
+func runLint(args map[storpc.ParseArgs]string, positional []string, stdout, stderr io.Writer) int {
+	if len(positional) != 1 {
+		fmt.Fprintln(stderr, "usage: storpc "+lintUsage)
+		return exitUsage
+	}
+
+	config, err := storpc.NewLintConfig(args[Rules], args[HotTables])
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return exitUsage
+	}
+
+	failOn := storpc.SeverityError
+	switch args[FailOn] {
+	case "", "error":
+	case "warning":
+		failOn = storpc.SeverityWarning
+	case "info":
+		failOn = storpc.SeverityInfo
+	default:
+		fmt.Fprintf(stderr, "unknown severity %q\n", args[FailOn])
+		return exitUsage
+	}
+
+	options, err := parserOptions(args, positional[0], stderr)
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return exitUsage
+	}
+	// streaming methods are a lint finding rather than a parse failure
+	options.KeepStreaming = true
+
+	var diagnostics storpc.Diagnostics
+	gen, err := storpc.LoadGenIR(options)
+	switch {
+	case errors.As(err, &diagnostics):
+		// the schema does not parse, so report why instead
+	case err != nil:
+		fmt.Fprintf(stderr, "%v: %v\n", positional[0], err)
+		return exitUsage
+	default:
+		diagnostics = storpc.Lint(gen, config)
+	}
+
+	switch args[Format] {
+	case "", "text":
+		err = diagnostics.WriteText(stdout)
+	case "json":
+		err = diagnostics.WriteJSON(stdout)
+	default:
+		fmt.Fprintf(stderr, "unknown format %q\n", args[Format])
+		return exitUsage
+	}
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return exitUsage
+	}
+
+	for _, d := range diagnostics {
+		// lower severities are larger values
+		if d.Severity <= failOn {
+			return exitFail
+		}
+	}
+	return exitOK
+}
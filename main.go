@@ -1 +1,169 @@
 package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/nam2184/storpc/driver"
+	"github.com/nam2184/storpc/storpc"
+)
+
+const (
+	exitOK    = 0
+	exitFail  = 1 // the command ran and found problems
+	exitUsage = 2 // bad arguments or unreadable input
+)
+
+// flags of the command line that are not parser options
+const (
+	Format storpc.ParseArgs = "--format"
+)
+
+// flags that take no value
+var boolArgs = map[storpc.ParseArgs]bool{
+	storpc.Verbose: true,
+	storpc.Quiet:   true,
+}
+
+type command struct {
+	usage string
+	run   func(args map[storpc.ParseArgs]string, positional []string, stdout, stderr io.Writer) int
+}
+
+var commands = map[string]command{
+	"check": {
+		usage: checkUsage,
+		run:   runCheck,
+	},
+	"compat": {
+		usage: compatUsage,
+		run:   runCompat,
+	},
+	"docs": {
+		usage: docsUsage,
+		run:   runDocs,
+	},
+	"dump": {
+		usage: dumpUsage,
+		run:   runDump,
+	},
+	"gen": {
+		usage: genUsage,
+		run:   runGen,
+	},
+	"lint": {
+		usage: lintUsage,
+		run:   runLint,
+	},
+}
+
+func main() {
+	os.Exit(run(os.Args[1:], os.Stdout, os.Stderr))
+}
+
+func run(argv []string, stdout, stderr io.Writer) int {
+	if len(argv) == 0 {
+		usage(stderr)
+		return exitUsage
+	}
+
+	cmd, ok := commands[argv[0]]
+	if !ok {
+		fmt.Fprintf(stderr, "unknown command %q\n", argv[0])
+		usage(stderr)
+		return exitUsage
+	}
+
+	args, positional, err := parseArgs(argv[1:])
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return exitUsage
+	}
+
+	return cmd.run(args, positional, stdout, stderr)
+}
+
+func usage(w io.Writer) {
+	fmt.Fprintln(w, "usage: storpc <command> [flags]")
+	names := make([]string, 0, len(commands))
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Fprintln(w, "  storpc "+commands[name].usage)
+	}
+}
+
+// parseArgs splits flags from positional arguments. Flags take their value
+// from the next argument or after '='.
+func parseArgs(argv []string) (map[storpc.ParseArgs]string, []string, error) {
+	args := make(map[storpc.ParseArgs]string)
+	var positional []string
+
+	for i := 0; i < len(argv); i++ {
+		arg := argv[i]
+		if !strings.HasPrefix(arg, "-") || arg == "-" {
+			positional = append(positional, arg)
+			continue
+		}
+
+		name, value, hasValue := strings.Cut(arg, "=")
+		flag := storpc.ParseArgs(name)
+		if boolArgs[flag] {
+			args[flag] = name
+			continue
+		}
+		if !hasValue {
+			if i+1 >= len(argv) {
+				return nil, nil, fmt.Errorf("flag %s needs a value", name)
+			}
+			i++
+			value = argv[i]
+		}
+		args[flag] = value
+	}
+
+	return args, positional, nil
+}
+
+// parserOptions are the parser options of the command line for one input.
+// Logs of the parser and the driver go to stderr, at warn unless --verbose
+// or --log_level ask otherwise.
+func parserOptions(args map[storpc.ParseArgs]string, input string, stderr io.Writer) (*storpc.ProtoParserOptions, error) {
+	parseArgs := make(map[storpc.ParseArgs]string, len(args)+1)
+	for k, v := range args {
+		parseArgs[k] = v
+	}
+	parseArgs[storpc.Input] = input
+
+	levels := args[storpc.LogLevel]
+	if levels == "" {
+		levels = "warn"
+		if args[storpc.Verbose] != "" {
+			levels = "debug"
+		}
+	}
+	config, err := storpc.NewLogConfig(args[storpc.LogFormat], levels)
+	if err != nil {
+		return nil, err
+	}
+	config.Output = stderr
+	driver.SetLogConfig(config)
+
+	options := storpc.NewProtoParserOptions(parseArgs)
+	options.Log = config
+	return options, nil
+}
+
+// loadSchema reads a binary GenIR, a descriptor set or .proto files.
+func loadSchema(args map[storpc.ParseArgs]string, input string, stderr io.Writer) (*storpc.GenIR, error) {
+	options, err := parserOptions(args, input, stderr)
+	if err != nil {
+		return nil, err
+	}
+	return storpc.LoadGenIR(options)
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const shopProto = `syntax = "proto3";
+package shop;
+
+import "storpc/options.proto";
+
+message Order {
+  option (storpc.table) = "orders";
+  int64 id = 1 [(storpc.key) = true];
+  string note = 2;
+}
+
+message GetOrderRequest {
+  int64 id = 1;
+}
+
+service Shop {
+  rpc GetOrder(GetOrderRequest) returns (Order);
+}
+`
+
+func writeSchemas(t *testing.T) string {
+	t.Helper()
+
+	dir := t.TempDir()
+	files := map[string]string{
+		"shop.proto": shopProto,
+		// the key moved, stored rows cannot be found again
+		"rekeyed.proto": strings.Replace(strings.Replace(shopProto,
+			"int64 id = 1 [(storpc.key) = true];", "int64 id = 1;", 1),
+			"string note = 2;", "string note = 2 [(storpc.key) = true];", 1),
+		"unkeyed.proto": strings.Replace(shopProto, " [(storpc.key) = true]", "", 1),
+		"broken.proto":  strings.Replace(shopProto, "string note", "shop.Missing note", 1),
+		"frame.bin":     "SMIR\x00\x01\x00garbage",
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+func TestExitStatus(t *testing.T) {
+	dir := writeSchemas(t)
+	path := func(name string) string { return filepath.Join(dir, name) }
+
+	tests := []struct {
+		args []string
+		want int
+	}{
+		{nil, exitUsage},
+		{[]string{"nope"}, exitUsage},
+
+		{[]string{"lint", path("shop.proto")}, exitOK},
+		{[]string{"lint", path("unkeyed.proto")}, exitFail},
+		{[]string{"lint", "--rules", "TABLE_NO_KEY=warning", path("unkeyed.proto")}, exitOK},
+		{[]string{"lint", "--rules", "TABLE_NO_KEY=warning", "--fail_on", "warning", path("unkeyed.proto")}, exitFail},
+		{[]string{"lint", path("broken.proto")}, exitFail},
+		{[]string{"lint", "--rules", "NO_SUCH_RULE=error", path("shop.proto")}, exitUsage},
+		{[]string{"lint", path("missing.proto")}, exitUsage},
+
+		{[]string{"compat", path("shop.proto"), path("shop.proto")}, exitOK},
+		{[]string{"compat", path("shop.proto"), path("rekeyed.proto")}, exitFail},
+		{[]string{"compat", path("shop.proto"), path("missing.proto")}, exitUsage},
+		{[]string{"compat", path("shop.proto")}, exitUsage},
+
+		{[]string{"check", path("shop.proto")}, exitOK},
+		{[]string{"check", "--format", "json", path("broken.proto")}, exitFail},
+		{[]string{"check", "--format", "xml", path("shop.proto")}, exitUsage},
+		{[]string{"check"}, exitUsage},
+
+		{[]string{"dump", path("shop.proto")}, exitOK},
+		{[]string{"dump", "--format", "json", path("shop.proto")}, exitOK},
+		{[]string{"dump", path("frame.bin")}, exitFail},
+		{[]string{"dump", "--format", "xml", path("shop.proto")}, exitUsage},
+		{[]string{"dump", path("missing.proto")}, exitUsage},
+	}
+
+	for _, tt := range tests {
+		var stdout, stderr bytes.Buffer
+		if got := run(tt.args, &stdout, &stderr); got != tt.want {
+			t.Errorf("storpc %s = %d, want %d\nstdout: %s\nstderr: %s", strings.Join(tt.args, " "), got, tt.want, stdout.String(), stderr.String())
+		}
+	}
+}
+
+func TestLintOutput(t *testing.T) {
+	dir := writeSchemas(t)
+
+	var stdout, stderr bytes.Buffer
+	run([]string{"lint", filepath.Join(dir, "unkeyed.proto")}, &stdout, &stderr)
+	if !strings.Contains(stdout.String(), "unkeyed.proto:6:1: error TABLE_NO_KEY") {
+		t.Errorf("lint output = %q", stdout.String())
+	}
+}
@@ -0,0 +1,188 @@
+package storpc
+
+import (
+	"errors"
+	"fmt"
+
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+/*
+Binary MethodBatch format
+
+	magic     4 bytes   "SMIB"
+	header    4 bytes   version major, minor, patch, flags
+	checksum  4 bytes   CRC32C, big endian
+	body      n bytes   record  1 item*
+
+Every item is a complete binary MethodIR frame, magic included, so items keep
+their own operation, group and checksum. The batch checksum is computed as
+in the MethodIR format and always set. Flags are bits; readers reject bits they do
+not know with a FeatureError, since ignoring one could change how a batch is
+applied.
+
+	BatchAtomic  0x01  apply every item or none
+
+Results are sent back in a frame of their own, one per item in item order.
+
+	magic     4 bytes   "SMRS"
+	header    3 bytes   version major, minor, patch
+	checksum  4 bytes   CRC32C, big endian
+	body      n bytes   record  1 Result*
+	Result    1 status  2 error
+*/
+
+const (
+	BatchHeaderSize  = 8 // header and checksum
+	ResultHeaderSize = 7
+)
+
+// MethodBatch flags
+const BatchAtomic uint8 = 0x01
+
+var (
+	batchMagic  = [4]byte{'S', 'M', 'I', 'B'}
+	resultMagic = [4]byte{'S', 'M', 'R', 'S'}
+)
+
+var (
+	ErrNotMethodBatch  = errors.New("not a binary MethodBatch")
+	ErrNotBatchResults = errors.New("not binary batch results")
+)
+
+// MarshalMethodBatch encodes a batch in the binary MethodBatch format.
+func MarshalMethodBatch(batch *MethodBatch) ([]byte, error) {
+	if batch == nil {
+		return nil, errors.New("no MethodBatch")
+	}
+
+	var flags uint8
+	if batch.Atomic {
+		flags |= BatchAtomic
+	}
+	b := make([]byte, 0, 64)
+	b = append(b, batchMagic[:]...)
+	b = append(b, STORPC_VERSION_MAJOR, STORPC_VERSION_MINOR, STORPC_VERSION_PATCH, flags)
+	b = append(b, 0, 0, 0, 0)
+
+	w := &recordWriter{b: b}
+	for i, item := range batch.Items {
+		data, err := MarshalMethodIR(item)
+		if err != nil {
+			return nil, fmt.Errorf("batch item %d: %w", i, err)
+		}
+		w.b = protowire.AppendTag(w.b, 1, protowire.BytesType)
+		w.b = protowire.AppendBytes(w.b, data)
+	}
+	sealFrame(w.b, len(batchMagic)+BatchHeaderSize-4)
+	return w.b, nil
+}
+
+// UnmarshalMethodBatch decodes a binary MethodBatch, reading its items as
+// UnmarshalMethodIR does.
+func UnmarshalMethodBatch(schema *GenBody, data []byte) (*MethodBatch, error) {
+	if len(data) < len(batchMagic) || [4]byte(data[:4]) != batchMagic {
+		return nil, ErrNotMethodBatch
+	}
+	if len(data) < len(batchMagic)+BatchHeaderSize {
+		return nil, ErrTruncated
+	}
+	if err := checkVersion("MethodBatch", data[4], data[5], data[6]); err != nil {
+		return nil, err
+	}
+	if err := checkFrame("MethodBatch", data, len(batchMagic)+BatchHeaderSize-4); err != nil {
+		return nil, err
+	}
+	data = data[len(batchMagic):]
+	flags := data[3]
+	if unknown := flags &^ BatchAtomic; unknown != 0 {
+		return nil, &FeatureError{Format: "MethodBatch", Features: uint16(unknown)}
+	}
+
+	batch := &MethodBatch{Atomic: flags&BatchAtomic != 0}
+	err := readRecord(data[BatchHeaderSize:], func(tag protowire.Number, v value) error {
+		if tag != 1 {
+			return nil
+		}
+		item, err := UnmarshalMethodIR(schema, v.bytes)
+		if err != nil {
+			return fmt.Errorf("batch item %d: %w", len(batch.Items), err)
+		}
+		batch.Items = append(batch.Items, item)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return batch, nil
+}
+
+// MarshalBatchResults encodes the results of a batch, one per item.
+func MarshalBatchResults(results []MethodResult) []byte {
+	b := make([]byte, 0, 16)
+	b = append(b, resultMagic[:]...)
+	b = append(b, STORPC_VERSION_MAJOR, STORPC_VERSION_MINOR, STORPC_VERSION_PATCH)
+	b = append(b, 0, 0, 0, 0)
+
+	w := &recordWriter{b: b}
+	for _, r := range results {
+		w.record(1, func(w *recordWriter) {
+			w.varint(1, uint64(r.Status), false)
+			w.string(2, r.Error)
+		})
+	}
+	sealFrame(w.b, len(resultMagic)+ResultHeaderSize-4)
+	return w.b
+}
+
+func UnmarshalBatchResults(data []byte) ([]MethodResult, error) {
+	if len(data) < len(resultMagic) || [4]byte(data[:4]) != resultMagic {
+		return nil, ErrNotBatchResults
+	}
+	if len(data) < len(resultMagic)+ResultHeaderSize {
+		return nil, ErrTruncated
+	}
+	if err := checkVersion("batch results", data[4], data[5], data[6]); err != nil {
+		return nil, err
+	}
+	if err := checkFrame("batch results", data, len(resultMagic)+ResultHeaderSize-4); err != nil {
+		return nil, err
+	}
+	data = data[len(resultMagic):]
+
+	var results []MethodResult
+	err := readRecord(data[ResultHeaderSize:], func(tag protowire.Number, v value) error {
+		if tag != 1 {
+			return nil
+		}
+		var r MethodResult
+		err := readRecord(v.bytes, func(tag protowire.Number, v value) error {
+			switch tag {
+			case 1:
+				r.Status = uint8(v.uint)
+			case 2:
+				r.Error = v.string()
+			}
+			return nil
+		})
+		results = append(results, r)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return results, nil
+}
+
+// AbortResults are the results of an atomic batch of n items that was not
+// applied because item failed with err.
+func AbortResults(n, item int, err error) []MethodResult {
+	results := make([]MethodResult, n)
+	for i := range results {
+		results[i].Status = ResultAborted
+	}
+	if item >= 0 && item < n {
+		results[item] = MethodResult{Status: ResultFailed, Error: err.Error()}
+	}
+	return results
+}
@@ -0,0 +1,130 @@
+package storpc
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// goldenBatch is the batch pinned by testdata/batch_atomic.golden: the
+// golden insert, then a delete on a table of another group.
+func goldenBatch() *MethodBatch {
+	header := NewMethodHeader(OpDelete)
+	header.Group = 8
+	del := NewMethodIR(header, NewMethodBody("shop.Order", []FieldValue{
+		{Number: 1, Value: Value{Kind: ValueInt, Int: 9}},
+	}))
+	return NewMethodBatch(true, goldenOrder(), del)
+}
+
+func TestMethodBatchGolden(t *testing.T) {
+	schema := parseSchema(t, map[string]string{"schema.proto": methodSchema}).Body
+
+	data, err := MarshalMethodBatch(goldenBatch())
+	if err != nil {
+		t.Fatalf("MarshalMethodBatch failed: %v", err)
+	}
+	checkGolden(t, "batch_atomic.golden", data)
+
+	decoded, err := UnmarshalMethodBatch(schema, data)
+	if err != nil {
+		t.Fatalf("UnmarshalMethodBatch failed: %v", err)
+	}
+	if !decoded.Atomic || len(decoded.Items) != 2 {
+		t.Fatalf("decoded = %+v, want 2 items applied atomically", decoded)
+	}
+	if decoded.Items[1].Body.Lookup("id") == nil {
+		t.Error("items not linked to the schema")
+	}
+	for _, item := range decoded.Items {
+		unlink(item.Body)
+	}
+	if !reflect.DeepEqual(decoded, goldenBatch()) {
+		t.Errorf("decoded = %+v\nwant %+v", decoded, goldenBatch())
+	}
+
+	loose := NewMethodBatch(false)
+	data, err = MarshalMethodBatch(loose)
+	if err != nil {
+		t.Fatalf("MarshalMethodBatch failed: %v", err)
+	}
+	if decoded, err := UnmarshalMethodBatch(schema, data); err != nil || decoded.Atomic || len(decoded.Items) != 0 {
+		t.Errorf("empty batch = %+v, %v", decoded, err)
+	}
+}
+
+func TestMethodBatchRejectsBadInput(t *testing.T) {
+	schema := parseSchema(t, map[string]string{"schema.proto": methodSchema}).Body
+
+	data, err := MarshalMethodBatch(goldenBatch())
+	if err != nil {
+		t.Fatalf("MarshalMethodBatch failed: %v", err)
+	}
+
+	if _, err := UnmarshalMethodBatch(schema, data[BatchHeaderSize:]); err != ErrNotMethodBatch {
+		t.Errorf("wrong magic: err = %v", err)
+	}
+
+	flags := append([]byte{}, data...)
+	flags[7] |= 0x80
+	sealFrame(flags, 8)
+	var feature *FeatureError
+	if _, err := UnmarshalMethodBatch(schema, flags); !errors.As(err, &feature) {
+		t.Errorf("unknown flag: err = %v", err)
+	}
+	flags[7] = 0
+	var checksum *ChecksumError
+	if _, err := UnmarshalMethodBatch(schema, flags); !errors.As(err, &checksum) {
+		t.Errorf("flipped flag: err = %v", err)
+	}
+
+	wrong := goldenBatch()
+	wrong.Items[1].Body.Fields[0].Value = Value{Kind: ValueString, String: "nine"}
+	data, err = MarshalMethodBatch(wrong)
+	if err != nil {
+		t.Fatalf("MarshalMethodBatch failed: %v", err)
+	}
+	if _, err := UnmarshalMethodBatch(schema, data); err == nil || !strings.HasPrefix(err.Error(), "batch item 1:") {
+		t.Errorf("bad item: err = %v", err)
+	}
+
+	wrong.Items[0].Header = nil
+	if _, err := MarshalMethodBatch(wrong); err == nil {
+		t.Error("item without a header written")
+	}
+}
+
+func TestBatchResults(t *testing.T) {
+	results := []MethodResult{
+		{Status: ResultOK},
+		{Status: ResultFailed, Error: "duplicate key 9"},
+	}
+	decoded, err := UnmarshalBatchResults(MarshalBatchResults(results))
+	if err != nil {
+		t.Fatalf("UnmarshalBatchResults failed: %v", err)
+	}
+	if !reflect.DeepEqual(decoded, results) {
+		t.Errorf("decoded = %+v, want %+v", decoded, results)
+	}
+
+	if _, err := UnmarshalBatchResults([]byte("SMIB\x01\x00\x00")); err != ErrNotBatchResults {
+		t.Errorf("wrong magic: err = %v", err)
+	}
+	corrupt := MarshalBatchResults(results)
+	corrupt[len(corrupt)-1] ^= 1
+	var checksum *ChecksumError
+	if _, err := UnmarshalBatchResults(corrupt); !errors.As(err, &checksum) {
+		t.Errorf("corrupt results: err = %v", err)
+	}
+
+	aborted := AbortResults(3, 1, errors.New("duplicate key 9"))
+	want := []MethodResult{
+		{Status: ResultAborted},
+		{Status: ResultFailed, Error: "duplicate key 9"},
+		{Status: ResultAborted},
+	}
+	if !reflect.DeepEqual(aborted, want) {
+		t.Errorf("AbortResults = %+v, want %+v", aborted, want)
+	}
+}
@@ -0,0 +1,432 @@
+package storpc
+
+import (
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+)
+
+// Compatibility of a schema change, from least to most disruptive. A change
+// is wire-compatible when existing clients keep exchanging messages with the
+// new schema, and storage-compatible when the rows stored under the old
+// schema survive, as they are or rewritten by a driver.MigrationPlan.
+const (
+	CompatSafe     uint8 = 0 // clients and stored rows are unaffected
+	CompatWire     uint8 = 1 // wire-compatible, stored rows are rewritten by a migration
+	CompatStorage  uint8 = 2 // storage-compatible, but clients must be updated
+	CompatBreaking uint8 = 3 // stored rows cannot be migrated or are lost, or clients break too
+)
+
+func CompatString(level uint8) string {
+	switch level {
+	case CompatSafe:
+		return "safe"
+	case CompatWire:
+		return "wire-compatible"
+	case CompatStorage:
+		return "storage-compatible"
+	case CompatBreaking:
+		return "breaking"
+	}
+	return fmt.Sprintf("compat(%d)", level)
+}
+
+type Change struct {
+	Level  uint8  `json:"-"`
+	Code   string `json:"code"`
+	Path   string `json:"path"` // message, message.field, enum.VALUE or service/method
+	Detail string `json:"detail"`
+}
+
+func (c Change) String() string {
+	return fmt.Sprintf("%-18s %-24s %s: %s", CompatString(c.Level), c.Code, c.Path, c.Detail)
+}
+
+type CompatReport struct {
+	Changes []Change
+}
+
+// Breaking reports whether any change loses stored rows or cannot be
+// migrated.
+func (r *CompatReport) Breaking() bool {
+	return r.Has(CompatBreaking)
+}
+
+// Has reports whether any change is classified at the given level.
+func (r *CompatReport) Has(level uint8) bool {
+	for _, c := range r.Changes {
+		if c.Level == level {
+			return true
+		}
+	}
+	return false
+}
+
+func (r *CompatReport) add(level uint8, code, path, format string, args ...any) {
+	r.Changes = append(r.Changes, Change{
+		Level:  level,
+		Code:   code,
+		Path:   path,
+		Detail: fmt.Sprintf(format, args...),
+	})
+}
+
+// CompareGenIR diffs two versions of a schema. Fields are matched by number
+// and everything else by full name. A removed message whose fields match an
+// added one is reported as a rename rather than a removal and an addition.
+func CompareGenIR(prev, next *GenIR) *CompatReport {
+	report := &CompatReport{}
+
+	renames := detectRenames(prev.Body, next.Body)
+
+	for i := range prev.Body.Messages {
+		om := &prev.Body.Messages[i]
+		if om.MapEntry {
+			continue
+		}
+
+		nm := next.Body.Message(om.Name)
+		if renamed, ok := renames[om.Name]; ok {
+			report.add(CompatWire, "MESSAGE_RENAMED", om.Name, "renamed to %s", renamed)
+			nm = next.Body.Message(renamed)
+		}
+		if nm == nil {
+			if om.Table != "" {
+				report.add(CompatBreaking, "TABLE_REMOVED", om.Name, "stored message removed with table %q", om.Table)
+			} else {
+				report.add(CompatStorage, "MESSAGE_REMOVED", om.Name, "message removed")
+			}
+			continue
+		}
+
+		compareMessage(report, om, nm, renames)
+	}
+
+	added := make(map[string]bool, len(renames))
+	for _, to := range renames {
+		added[to] = true
+	}
+	for i := range next.Body.Messages {
+		nm := &next.Body.Messages[i]
+		if nm.MapEntry || added[nm.Name] || prev.Body.Message(nm.Name) != nil {
+			continue
+		}
+		report.add(CompatSafe, "MESSAGE_ADDED", nm.Name, "message added")
+	}
+
+	for i := range prev.Body.Enums {
+		oe := &prev.Body.Enums[i]
+		ne := next.Body.Enum(oe.Name)
+		if ne == nil {
+			report.add(CompatStorage, "ENUM_REMOVED", oe.Name, "enum removed")
+			continue
+		}
+		compareEnum(report, oe, ne)
+	}
+	for i := range next.Body.Enums {
+		if prev.Body.Enum(next.Body.Enums[i].Name) == nil {
+			report.add(CompatSafe, "ENUM_ADDED", next.Body.Enums[i].Name, "enum added")
+		}
+	}
+
+	compareServices(report, prev.Body, next.Body, renames)
+
+	return report
+}
+
+// detectRenames pairs messages that disappeared with added messages of the
+// same shape: identical field numbers and types. A shape must be non-empty
+// and unique on both sides, otherwise the messages are reported as removed
+// and added.
+func detectRenames(prev, next *GenBody) map[string]string {
+	removed := make(map[string][]*Message)
+	for i := range prev.Messages {
+		if m := &prev.Messages[i]; !m.MapEntry && next.Message(m.Name) == nil {
+			shape := messageShape(m)
+			removed[shape] = append(removed[shape], m)
+		}
+	}
+	added := make(map[string][]*Message)
+	for i := range next.Messages {
+		if m := &next.Messages[i]; !m.MapEntry && prev.Message(m.Name) == nil {
+			shape := messageShape(m)
+			added[shape] = append(added[shape], m)
+		}
+	}
+
+	renames := make(map[string]string)
+	for shape, oms := range removed {
+		nms := added[shape]
+		if shape == "" || len(oms) != 1 || len(nms) != 1 {
+			continue
+		}
+		renames[oms[0].Name] = nms[0].Name
+	}
+	return renames
+}
+
+func messageShape(m *Message) string {
+	if len(m.Fields) == 0 {
+		return ""
+	}
+	parts := make([]string, 0, len(m.Fields))
+	for _, f := range m.Fields {
+		parts = append(parts, fmt.Sprintf("%d:%d:%s", f.Number, f.Cardinality, f.Type))
+	}
+	sort.Strings(parts)
+	return strings.Join(parts, ",")
+}
+
+func compareMessage(report *CompatReport, om, nm *Message, renames map[string]string) {
+	if om.Table != nm.Table {
+		switch {
+		case om.Table == "":
+			report.add(CompatSafe, "TABLE_ADDED", nm.Name, "now stored as table %q", nm.Table)
+		case nm.Table == "":
+			report.add(CompatWire, "TABLE_DROPPED", nm.Name, "no longer stored, table %q is orphaned", om.Table)
+		default:
+			report.add(CompatWire, "TABLE_RENAMED", nm.Name, "table %q renamed to %q", om.Table, nm.Table)
+		}
+	}
+
+	stored := om.Table != "" && nm.Table != ""
+	oldKey := keyColumns(om)
+	newKey := keyColumns(nm)
+	if stored && !slices.Equal(oldKey, newKey) {
+		report.add(CompatBreaking, "KEY_CHANGED", nm.Name, "key fields %v changed to %v, stored rows are addressed by the old key", oldKey, newKey)
+	}
+
+	for i := range om.Fields {
+		of := &om.Fields[i]
+		path := om.Name + "." + of.Name
+
+		nf := fieldByNumber(nm, of.Number)
+		if nf == nil {
+			if of.Key {
+				report.add(CompatBreaking, "KEY_FIELD_REMOVED", path, "key field %d removed", of.Number)
+			} else {
+				report.add(CompatWire, "FIELD_REMOVED", path, "field %d removed, stored values are dropped", of.Number)
+			}
+			continue
+		}
+
+		compareField(report, path, of, nf, renames, stored)
+	}
+
+	for i := range nm.Fields {
+		nf := &nm.Fields[i]
+		if fieldByNumber(om, nf.Number) != nil {
+			continue
+		}
+		path := nm.Name + "." + nf.Name
+		if nf.Cardinality == CardinalityRequired {
+			report.add(CompatStorage, "REQUIRED_FIELD_ADDED", path, "required field %d added, existing clients do not set it", nf.Number)
+		} else {
+			report.add(CompatSafe, "FIELD_ADDED", path, "field %d added", nf.Number)
+		}
+	}
+}
+
+// compareField classifies the change of one field. Type changes of stored
+// fields are judged by what a migration can rewrite: Widens.
+func compareField(report *CompatReport, path string, of, nf *Field, renames map[string]string, stored bool) {
+	if of.Name != nf.Name {
+		report.add(CompatStorage, "FIELD_RENAMED", path, "field %d renamed to %s", of.Number, nf.Name)
+	}
+
+	if of.Cardinality != nf.Cardinality || of.IsMap() != nf.IsMap() {
+		report.add(CompatBreaking, "FIELD_CARDINALITY_CHANGED", path, "field %d cardinality changed", of.Number)
+		return
+	}
+
+	if of.IsMap() {
+		// entry type names follow the parent, compare what they hold
+		compareField(report, path+".key", of.MapKey, nf.MapKey, renames, stored)
+		compareField(report, path+".value", of.MapValue, nf.MapValue, renames, stored)
+		return
+	}
+
+	oldType := of.Type
+	if to, ok := renames[oldType]; ok && of.Kind == KindMessage {
+		oldType = to
+	}
+	if oldType != nf.Type || of.Kind != nf.Kind {
+		sameWire := wireGroup(of) != "" && wireGroup(of) == wireGroup(nf)
+		switch {
+		case of.Kind == KindScalar && nf.Kind == KindScalar && Widens(of.Type, nf.Type):
+			// a migration widens the column, clients only read it if the encoding is kept
+			if sameWire {
+				report.add(CompatWire, "FIELD_WIDENED", path, "field %d widened from %s to %s", of.Number, of.Type, nf.Type)
+			} else {
+				report.add(CompatStorage, "FIELD_WIDENED", path, "field %d widened from %s to %s, which has another wire encoding", of.Number, of.Type, nf.Type)
+			}
+		case sameWire && stored:
+			report.add(CompatBreaking, "FIELD_TYPE_CHANGED", path, "field %d changed from %s to wire compatible %s, stored values cannot be migrated", of.Number, of.Type, nf.Type)
+		case sameWire:
+			report.add(CompatWire, "FIELD_TYPE_CHANGED", path, "field %d changed from %s to wire compatible %s", of.Number, of.Type, nf.Type)
+		default:
+			report.add(CompatBreaking, "FIELD_TYPE_CHANGED", path, "field %d reused with type %s, was %s", of.Number, nf.Type, of.Type)
+		}
+	}
+
+	if of.Delimited != nf.Delimited {
+		report.add(CompatStorage, "FIELD_ENCODING_CHANGED", path, "field %d message encoding changed", of.Number)
+	}
+	if of.Packed != nf.Packed {
+		report.add(CompatSafe, "FIELD_ENCODING_CHANGED", path, "field %d packed encoding changed, parsers accept both", of.Number)
+	}
+	if of.Presence != nf.Presence && !of.IsRequired() && !nf.IsRequired() {
+		report.add(CompatStorage, "FIELD_PRESENCE_CHANGED", path, "field %d presence changed, stored zero values read differently", of.Number)
+	}
+	if of.HasDefault != nf.HasDefault || of.Default != nf.Default {
+		report.add(CompatStorage, "FIELD_DEFAULT_CHANGED", path, "field %d default changed from %q to %q", of.Number, of.Default, nf.Default)
+	}
+
+	if of.Oneof != nf.Oneof {
+		report.add(CompatWire, "FIELD_ONEOF_CHANGED", path, "field %d moved from oneof %q to %q", of.Number, of.Oneof, nf.Oneof)
+	}
+
+	if of.Index != nf.Index || of.Unique != nf.Unique {
+		if nf.Unique && !of.Unique {
+			report.add(CompatWire, "INDEX_CHANGED", path, "unique index added, existing rows may conflict")
+		} else {
+			report.add(CompatSafe, "INDEX_CHANGED", path, "index changed")
+		}
+	}
+}
+
+func compareEnum(report *CompatReport, oe, ne *Enum) {
+	for _, ov := range oe.Values {
+		path := oe.Name + "." + ov.Name
+
+		var byName, byNumber *EnumValue
+		for i := range ne.Values {
+			if ne.Values[i].Name == ov.Name {
+				byName = &ne.Values[i]
+			}
+			if ne.Values[i].Value == ov.Value && byNumber == nil {
+				byNumber = &ne.Values[i]
+			}
+		}
+
+		switch {
+		case byName != nil && byName.Value != ov.Value:
+			report.add(CompatBreaking, "ENUM_VALUE_RENUMBERED", path, "value %d renumbered to %d", ov.Value, byName.Value)
+		case byName == nil && byNumber != nil:
+			report.add(CompatStorage, "ENUM_VALUE_RENAMED", path, "value %d renamed to %s", ov.Value, byNumber.Name)
+		case byName == nil:
+			report.add(CompatBreaking, "ENUM_VALUE_REMOVED", path, "value %d removed, stored rows may still hold it", ov.Value)
+		}
+	}
+
+	for _, nv := range ne.Values {
+		found := false
+		for _, ov := range oe.Values {
+			if ov.Name == nv.Name || ov.Value == nv.Value {
+				found = true
+				break
+			}
+		}
+		if !found {
+			report.add(CompatSafe, "ENUM_VALUE_ADDED", ne.Name+"."+nv.Name, "value %d added", nv.Value)
+		}
+	}
+}
+
+func compareServices(report *CompatReport, oldBody, newBody *GenBody, renames map[string]string) {
+	renamed := func(name string) string {
+		if to, ok := renames[name]; ok {
+			return to
+		}
+		return name
+	}
+
+	for _, svc := range oldBody.Services {
+		for _, om := range svc.Methods {
+			path := svc.Name + "/" + om.Name
+			nm := newBody.Method(svc.Name, om.Name)
+			if nm == nil {
+				report.add(CompatStorage, "METHOD_REMOVED", path, "method removed")
+				continue
+			}
+			if renamed(om.Input) != nm.Input || renamed(om.Output) != nm.Output {
+				report.add(CompatStorage, "METHOD_SIGNATURE_CHANGED", path, "(%s) returns (%s) changed to (%s) returns (%s)",
+					om.Input, om.Output, nm.Input, nm.Output)
+			}
+			if om.Operation != nm.Operation {
+				report.add(CompatStorage, "METHOD_OPERATION_CHANGED", path, "operation %s changed to %s",
+					OpString(om.Operation), OpString(nm.Operation))
+			}
+			if renamed(om.Table) != nm.Table {
+				report.add(CompatWire, "METHOD_TABLE_CHANGED", path, "target table %q changed to %q", om.Table, nm.Table)
+			}
+		}
+	}
+
+	for _, ns := range newBody.Services {
+		for _, nm := range ns.Methods {
+			if oldBody.Method(ns.Name, nm.Name) == nil {
+				report.add(CompatSafe, "METHOD_ADDED", ns.Name+"/"+nm.Name, "method added")
+			}
+		}
+	}
+}
+
+// keyColumns lists the key fields as number:type, the way stored rows are
+// addressed.
+func keyColumns(m *Message) []string {
+	var columns []string
+	for _, f := range m.KeyFields() {
+		columns = append(columns, fmt.Sprintf("%d:%s", f.Number, f.Type))
+	}
+	return columns
+}
+
+func fieldByNumber(m *Message, number int32) *Field {
+	for i := range m.Fields {
+		if m.Fields[i].Number == number {
+			return &m.Fields[i]
+		}
+	}
+	return nil
+}
+
+// scalar types that can be read as a wider type without losing values
+var widenings = map[string][]string{
+	"int32":  {"int64"},
+	"uint32": {"uint64", "int64"},
+	"sint32": {"sint64"},
+	"float":  {"double"},
+}
+
+// Widens reports whether values of scalar type from can be stored as type to
+// without losing information. It says nothing about the wire: float and double
+// are encoded differently, so clients of one cannot read the other.
+func Widens(from, to string) bool {
+	return slices.Contains(widenings[from], to)
+}
+
+// wireGroup names the group of types sharing an encoding that protobuf
+// parsers accept interchangeably.
+func wireGroup(f *Field) string {
+	if f.Kind == KindEnum {
+		return "varint"
+	}
+	if f.Kind == KindMessage {
+		return ""
+	}
+	switch f.Type {
+	case "int32", "int64", "uint32", "uint64", "bool":
+		return "varint"
+	case "sint32", "sint64":
+		return "zigzag"
+	case "fixed32", "sfixed32":
+		return "fixed32"
+	case "fixed64", "sfixed64":
+		return "fixed64"
+	case "string", "bytes":
+		return "bytes"
+	}
+	return ""
+}
@@ -0,0 +1,252 @@
+package storpc
+
+import (
+	"strings"
+	"testing"
+)
+
+const compatBefore = `syntax = "proto3";
+package shop;
+
+import "storpc/options.proto";
+
+enum Status {
+  STATUS_UNKNOWN = 0;
+  STATUS_OPEN = 1;
+  STATUS_CLOSED = 2;
+}
+
+message Order {
+  option (storpc.table) = "orders";
+  int64 id = 1 [(storpc.key) = true];
+  int32 quantity = 2;
+  string note = 3;
+  Status status = 4;
+  string region = 5;
+}
+
+message Customer {
+  string id = 1;
+  string name = 2;
+}
+
+service Orders {
+  rpc InsertOrder(Order) returns (Order);
+  rpc GetCustomer(Customer) returns (Customer);
+}
+`
+
+const compatAfter = `syntax = "proto3";
+package shop;
+
+import "storpc/options.proto";
+
+enum Status {
+  STATUS_UNKNOWN = 0;
+  STATUS_ACTIVE = 1;
+  STATUS_ARCHIVED = 3;
+}
+
+message Order {
+  option (storpc.table) = "orders";
+  int64 id = 1 [(storpc.key) = true];
+  int64 quantity = 2;
+  bytes note = 3;
+  Status status = 4;
+  double region = 6;
+  bool urgent = 5;
+}
+
+message Client {
+  string id = 1;
+  string name = 2;
+}
+
+service Orders {
+  rpc InsertOrder(Order) returns (Order);
+  rpc GetCustomer(Client) returns (Client);
+}
+`
+
+func TestCompareGenIR(t *testing.T) {
+	report := CompareGenIR(parseSchema(t, map[string]string{"schema.proto": compatBefore}), parseSchema(t, map[string]string{"schema.proto": compatAfter}))
+
+	want := map[string]uint8{
+		"MESSAGE_RENAMED shop.Customer":                CompatWire,
+		"FIELD_WIDENED shop.Order.quantity":            CompatWire,
+		"FIELD_TYPE_CHANGED shop.Order.note":           CompatBreaking,
+		"FIELD_RENAMED shop.Order.region":              CompatStorage,
+		"FIELD_TYPE_CHANGED shop.Order.region":         CompatBreaking,
+		"FIELD_ADDED shop.Order.region":                CompatSafe,
+		"ENUM_VALUE_RENAMED shop.Status.STATUS_OPEN":   CompatStorage,
+		"ENUM_VALUE_REMOVED shop.Status.STATUS_CLOSED": CompatBreaking,
+		"ENUM_VALUE_ADDED shop.Status.STATUS_ARCHIVED": CompatSafe,
+	}
+
+	got := make(map[string]uint8)
+	for _, c := range report.Changes {
+		got[c.Code+" "+c.Path] = c.Level
+	}
+
+	for key, level := range want {
+		l, ok := got[key]
+		if !ok {
+			t.Errorf("missing change %s", key)
+			continue
+		}
+		if l != level {
+			t.Errorf("%s classified %s, want %s", key, CompatString(l), CompatString(level))
+		}
+	}
+	if _, ok := got["METHOD_SIGNATURE_CHANGED shop.Orders/GetCustomer"]; ok {
+		t.Errorf("renamed message should not change the method signature")
+	}
+	if !report.Breaking() {
+		t.Errorf("expected report to be breaking")
+	}
+}
+
+func TestCompareGenIRKeyChange(t *testing.T) {
+	before := `syntax = "proto3";
+package kv;
+import "storpc/options.proto";
+message Entry {
+  option (storpc.table) = "entries";
+  string key = 1 [(storpc.key) = true];
+  string value = 2;
+}
+`
+	after := `syntax = "proto3";
+package kv;
+import "storpc/options.proto";
+message Entry {
+  option (storpc.table) = "kv_entries";
+  string key = 1;
+  string value = 2 [(storpc.key) = true];
+}
+`
+
+	report := CompareGenIR(parseSchema(t, map[string]string{"schema.proto": before}), parseSchema(t, map[string]string{"schema.proto": after}))
+
+	codes := make(map[string]uint8)
+	for _, c := range report.Changes {
+		codes[c.Code] = c.Level
+	}
+	if codes["KEY_CHANGED"] != CompatBreaking {
+		t.Errorf("expected breaking KEY_CHANGED, got %v", report.Changes)
+	}
+	if codes["TABLE_RENAMED"] != CompatWire {
+		t.Errorf("expected wire compatible TABLE_RENAMED, got %v", report.Changes)
+	}
+	if !report.Breaking() {
+		t.Errorf("key change should be breaking: %v", report.Changes)
+	}
+
+	// same number, new type: rows are still addressed by the old key values
+	retyped := strings.Replace(before, "string key = 1", "bytes key = 1", 1)
+	report = CompareGenIR(parseSchema(t, map[string]string{"schema.proto": before}), parseSchema(t, map[string]string{"schema.proto": retyped}))
+	if !report.Breaking() {
+		t.Errorf("key type change should be breaking: %v", report.Changes)
+	}
+	found := false
+	for _, c := range report.Changes {
+		found = found || c.Code == "KEY_CHANGED" && c.Level == CompatBreaking
+	}
+	if !found {
+		t.Errorf("expected breaking KEY_CHANGED for a key type change, got %v", report.Changes)
+	}
+
+	if same := CompareGenIR(parseSchema(t, map[string]string{"schema.proto": before}), parseSchema(t, map[string]string{"schema.proto": before})); len(same.Changes) != 0 {
+		t.Errorf("identical schemas differ: %v", same.Changes)
+	}
+}
+
+func TestCompareGenIRTypeChanges(t *testing.T) {
+	before := `syntax = "proto3";
+package m;
+import "storpc/options.proto";
+message Reading {
+  option (storpc.table) = "readings";
+  int64 id = 1 [(storpc.key) = true];
+  float celsius = 2;
+  int32 count = 3;
+  string label = 4;
+}
+message Probe {
+  string label = 1;
+}
+`
+	after := `syntax = "proto3";
+package m;
+import "storpc/options.proto";
+message Reading {
+  option (storpc.table) = "readings";
+  int64 id = 1 [(storpc.key) = true];
+  double celsius = 2;
+  int64 count = 3;
+  bytes label = 4;
+}
+message Probe {
+  bytes label = 1;
+}
+`
+
+	report := CompareGenIR(parseSchema(t, map[string]string{"schema.proto": before}), parseSchema(t, map[string]string{"schema.proto": after}))
+
+	got := make(map[string]uint8)
+	for _, c := range report.Changes {
+		got[c.Code+" "+c.Path] = c.Level
+	}
+	for key, level := range map[string]uint8{
+		// a migration widens the column, but float and double differ on the wire
+		"FIELD_WIDENED m.Reading.celsius": CompatStorage,
+		"FIELD_WIDENED m.Reading.count":   CompatWire,
+		// clients read it, stored strings cannot be migrated to bytes
+		"FIELD_TYPE_CHANGED m.Reading.label": CompatBreaking,
+		"FIELD_TYPE_CHANGED m.Probe.label":   CompatWire,
+	} {
+		if l, ok := got[key]; !ok || l != level {
+			t.Errorf("%s classified %s, want %s", key, CompatString(l), CompatString(level))
+		}
+	}
+}
+
+func TestCompareGenIRAmbiguousRenames(t *testing.T) {
+	before := `syntax = "proto3";
+package m;
+message Ping {}
+message Left {
+  string name = 1;
+}
+message Right {
+  string name = 1;
+}
+`
+	after := `syntax = "proto3";
+package m;
+message Pong {}
+message West {
+  string name = 1;
+}
+message East {
+  string name = 1;
+}
+`
+
+	report := CompareGenIR(parseSchema(t, map[string]string{"schema.proto": before}), parseSchema(t, map[string]string{"schema.proto": after}))
+
+	for _, c := range report.Changes {
+		if c.Code == "MESSAGE_RENAMED" {
+			t.Errorf("unexpected rename %s: %s", c.Path, c.Detail)
+		}
+	}
+	got := make(map[string]bool)
+	for _, c := range report.Changes {
+		got[c.Code+" "+c.Path] = true
+	}
+	for _, key := range []string{"MESSAGE_REMOVED m.Ping", "MESSAGE_ADDED m.Pong", "MESSAGE_REMOVED m.Left", "MESSAGE_ADDED m.East"} {
+		if !got[key] {
+			t.Errorf("missing change %s", key)
+		}
+	}
+}
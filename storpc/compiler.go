@@ -0,0 +1,148 @@
+package storpc
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/bufbuild/protocompile"
+	"github.com/bufbuild/protocompile/reporter"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/descriptorpb"
+)
+
+// isSourceInput reports whether every input is a .proto source file rather
+// than a compiled FileDescriptorSet.
+func isSourceInput(inputs []string) bool {
+	if len(inputs) == 0 {
+		return false
+	}
+	for _, in := range inputs {
+		if filepath.Ext(in) != ".proto" {
+			return false
+		}
+	}
+	return true
+}
+
+// compileSources compiles .proto files in-process and returns a descriptor
+// set holding them and everything they import, together with the names the
+// inputs were compiled under. Every compile error is collected as a
+// diagnostic before the compile fails.
+func (p *ProtoParser) compileSources(inputs []string) (*descriptorpb.FileDescriptorSet, []string, error) {
+	importPaths := p.options.ImportPaths
+	if len(importPaths) == 0 {
+		importPaths = inputDirs(inputs)
+	}
+
+	names := make([]string, 0, len(inputs))
+	for _, in := range inputs {
+		name, err := importName(in, importPaths)
+		if err != nil {
+			return nil, nil, err
+		}
+		names = append(names, name)
+	}
+
+	collect := func(severity uint8) func(reporter.ErrorWithPos) {
+		return func(err reporter.ErrorWithPos) {
+			pos := err.GetPosition()
+			p.diagnostics = append(p.diagnostics, Diagnostic{
+				Severity: severity,
+				Code:     CodeCompile,
+				Message:  err.Unwrap().Error(),
+				File:     pos.Filename,
+				Line:     pos.Line,
+				Column:   pos.Col,
+			})
+		}
+	}
+
+	compiler := protocompile.Compiler{
+		Resolver: protocompile.WithStandardImports(protocompile.CompositeResolver{
+			&protocompile.SourceResolver{ImportPaths: importPaths},
+			optionsResolver(),
+		}),
+		SourceInfoMode: protocompile.SourceInfoStandard,
+		// keep compiling past errors so all of them are reported
+		Reporter: reporter.NewReporter(func(err reporter.ErrorWithPos) error {
+			collect(SeverityError)(err)
+			return nil
+		}, collect(SeverityWarning)),
+	}
+
+	p.logger.Debug(fmt.Sprintf("compiling %v with import paths %v", names, importPaths))
+	files, err := compiler.Compile(context.Background(), names...)
+	if err := p.failed(); err != nil {
+		return nil, nil, err
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+
+	set := &descriptorpb.FileDescriptorSet{}
+	seen := make(map[string]bool)
+
+	var add func(fd protoreflect.FileDescriptor)
+	add = func(fd protoreflect.FileDescriptor) {
+		if seen[fd.Path()] {
+			return
+		}
+		seen[fd.Path()] = true
+
+		imports := fd.Imports()
+		for i := 0; i < imports.Len(); i++ {
+			add(imports.Get(i).FileDescriptor)
+		}
+		set.File = append(set.File, protodesc.ToFileDescriptorProto(fd))
+	}
+
+	for _, fd := range files {
+		add(fd)
+	}
+
+	return set, names, nil
+}
+
+// inputDirs are the import paths used when none are given: the directories
+// of the inputs themselves.
+func inputDirs(inputs []string) []string {
+	var dirs []string
+	for _, in := range inputs {
+		dir := filepath.Dir(in)
+		if !slices.Contains(dirs, dir) {
+			dirs = append(dirs, dir)
+		}
+	}
+	return dirs
+}
+
+// importName maps an input path to its name relative to the import path
+// that contains it, the same way protoc does.
+func importName(input string, importPaths []string) (string, error) {
+	abs, err := filepath.Abs(input)
+	if err != nil {
+		return "", err
+	}
+
+	for _, dir := range importPaths {
+		absDir, err := filepath.Abs(dir)
+		if err != nil {
+			return "", err
+		}
+		rel, err := filepath.Rel(absDir, abs)
+		if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			continue
+		}
+		if _, err := os.Stat(abs); err != nil {
+			return "", err
+		}
+		return filepath.ToSlash(rel), nil
+	}
+
+	return "", fmt.Errorf("input %q is not within any import path %v", input, importPaths)
+}
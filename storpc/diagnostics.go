@@ -0,0 +1,133 @@
+package storpc
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+
+	"google.golang.org/protobuf/reflect/protoreflect"
+)
+
+// diagnostic codes reported by the parser
+const (
+	CodeCompile          = "COMPILE"
+	CodeDescriptorSet    = "DESCRIPTOR_SET"
+	CodeImport           = "IMPORT"
+	CodeRoot             = "ROOT"
+	CodeStreamingRPC     = "STREAMING_RPC"
+	CodeUnknownOperation = "UNKNOWN_OPERATION"
+)
+
+// Diagnostic is a problem found in a schema. Line and Column are 1-based
+// and zero when the source position is unknown, e.g. for descriptor sets
+// built without source info.
+type Diagnostic struct {
+	Severity uint8
+	Code     string
+	Message  string
+	File     string
+	Line     int
+	Column   int
+}
+
+func (d Diagnostic) String() string {
+	var b strings.Builder
+	if d.File != "" {
+		b.WriteString(d.File)
+		if d.Line > 0 {
+			fmt.Fprintf(&b, ":%d:%d", d.Line, d.Column)
+		}
+		b.WriteString(": ")
+	}
+	fmt.Fprintf(&b, "%s %s: %s", SeverityString(d.Severity), d.Code, d.Message)
+	return b.String()
+}
+
+func (d Diagnostic) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		Severity string `json:"severity"`
+		Code     string `json:"code"`
+		Message  string `json:"message"`
+		File     string `json:"file,omitempty"`
+		Line     int    `json:"line,omitempty"`
+		Column   int    `json:"column,omitempty"`
+	}{SeverityString(d.Severity), d.Code, d.Message, d.File, d.Line, d.Column})
+}
+
+// Diagnostics is every problem found in one parse. Parse returns it as the
+// error when any entry is an error.
+type Diagnostics []Diagnostic
+
+func (ds Diagnostics) Error() string {
+	lines := make([]string, 0, len(ds))
+	for _, d := range ds {
+		if d.Severity == SeverityError {
+			lines = append(lines, d.String())
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+func (ds Diagnostics) HasErrors() bool {
+	for _, d := range ds {
+		if d.Severity == SeverityError {
+			return true
+		}
+	}
+	return false
+}
+
+// WriteText writes one diagnostic per line.
+func (ds Diagnostics) WriteText(w io.Writer) error {
+	for _, d := range ds {
+		if _, err := fmt.Fprintln(w, d); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// WriteJSON writes the diagnostics as an indented JSON array.
+func (ds Diagnostics) WriteJSON(w io.Writer) error {
+	if ds == nil {
+		ds = Diagnostics{}
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(ds)
+}
+
+func (p *ProtoParser) report(severity uint8, code, file, format string, args ...any) {
+	p.diagnostics = append(p.diagnostics, Diagnostic{
+		Severity: severity,
+		Code:     code,
+		Message:  fmt.Sprintf(format, args...),
+		File:     file,
+	})
+}
+
+// reportAt reports a problem at the declaration of desc, located through
+// the SourceCodeInfo of its file.
+func (p *ProtoParser) reportAt(desc protoreflect.Descriptor, severity uint8, code, format string, args ...any) {
+	loc := locationOf(desc)
+	p.report(severity, code, loc.File, format, args...)
+
+	d := &p.diagnostics[len(p.diagnostics)-1]
+	d.Line = loc.Line
+	d.Column = loc.Column
+}
+
+// Diagnostics returns the problems found by the last parse, warnings
+// included.
+func (p *ProtoParser) Diagnostics() Diagnostics {
+	return p.diagnostics
+}
+
+// failed turns the collected diagnostics into the error of a parse.
+func (p *ProtoParser) failed() error {
+	if p.diagnostics.HasErrors() {
+		return p.diagnostics
+	}
+	return nil
+}
@@ -0,0 +1,280 @@
+package storpc
+
+import (
+	"bytes"
+	"fmt"
+	htmltemplate "html/template"
+	"strings"
+	"text/template"
+)
+
+// Docs selects the format of the data dictionary the plugin writes.
+const Docs ParseArgs = "--docs"
+
+// formats GenerateDocs writes
+const (
+	DocFormatMarkdown = "markdown"
+	DocFormatHTML     = "html"
+)
+
+type docSchema struct {
+	Group    string
+	Tables   []docTable
+	Enums    []docEnum
+	Services []docService
+}
+
+type docTable struct {
+	Name       string
+	Message    string
+	Doc        string
+	Keys       []string
+	Columns    []docColumn
+	Operations []docMethod
+}
+
+type docColumn struct {
+	Name       string
+	Type       string
+	Attributes string
+	Doc        string
+}
+
+type docEnum struct {
+	Name   string
+	Doc    string
+	Values []string
+}
+
+type docService struct {
+	Name    string
+	Methods []docMethod
+}
+
+type docMethod struct {
+	Service string
+	Name    string
+	Op      string
+	Table   string
+	Input   string
+	Output  string
+	Doc     string
+}
+
+// GenerateDocs renders a data dictionary of gen in format: every table with
+// its columns, keys, indexes and the operations that reach it, the enums
+// the tables use and the hosted services. Descriptions come from the
+// comments of the .proto source.
+func GenerateDocs(gen *GenIR, format string) ([]byte, error) {
+	schema := newDocSchema(gen.Body)
+
+	var b bytes.Buffer
+	var err error
+	switch format {
+	case "", DocFormatMarkdown:
+		err = markdownDocs.Execute(&b, schema)
+	case DocFormatHTML:
+		err = htmlDocs.Execute(&b, schema)
+	default:
+		return nil, fmt.Errorf("unknown doc format %q", format)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return b.Bytes(), nil
+}
+
+func newDocSchema(body *GenBody) *docSchema {
+	schema := &docSchema{Group: body.Group}
+
+	operations := make(map[string][]docMethod)
+	for _, svc := range body.Services {
+		service := docService{Name: svc.Name}
+		for _, m := range svc.Methods {
+			method := docMethod{
+				Service: svc.Name,
+				Name:    m.Name,
+				Op:      OpString(m.Operation),
+				Input:   m.Input,
+				Output:  m.Output,
+				Doc:     docText(m.Comments),
+			}
+			if table := body.Message(m.Table); table != nil {
+				method.Table = table.Table
+				operations[table.Name] = append(operations[table.Name], method)
+			}
+			service.Methods = append(service.Methods, method)
+		}
+		schema.Services = append(schema.Services, service)
+	}
+
+	used := make(map[string]bool)
+	for i := range body.Messages {
+		m := &body.Messages[i]
+		if m.Table == "" {
+			continue
+		}
+
+		table := docTable{
+			Name:       m.Table,
+			Message:    m.Name,
+			Doc:        docText(m.Comments),
+			Operations: operations[m.Name],
+		}
+		for _, f := range m.KeyFields() {
+			table.Keys = append(table.Keys, f.Name)
+		}
+		for j := range m.Fields {
+			f := &m.Fields[j]
+			table.Columns = append(table.Columns, docColumn{
+				Name:       f.Name,
+				Type:       docType(f),
+				Attributes: docAttributes(f),
+				Doc:        docText(f.Comments),
+			})
+			for _, t := range []*Field{f, f.MapValue} {
+				if t != nil && t.Kind == KindEnum {
+					used[t.Type] = true
+				}
+			}
+		}
+		schema.Tables = append(schema.Tables, table)
+	}
+
+	for _, e := range body.Enums {
+		if !used[e.Name] {
+			continue
+		}
+		enum := docEnum{Name: e.Name, Doc: docText(e.Comments)}
+		for _, v := range e.Values {
+			enum.Values = append(enum.Values, fmt.Sprintf("%v = %d", v.Name, v.Value))
+		}
+		schema.Enums = append(schema.Enums, enum)
+	}
+
+	return schema
+}
+
+// docText is the description of a declaration: its leading comment, then
+// its trailing one.
+func docText(c Comments) string {
+	var parts []string
+	for _, text := range []string{c.Leading, c.Trailing} {
+		if text != "" {
+			parts = append(parts, text)
+		}
+	}
+	return strings.Join(parts, "\n")
+}
+
+func docType(f *Field) string {
+	name := fieldTypeName(f)
+	if f.Logical != LogicalNone {
+		name += " (" + LogicalString(f.Logical) + ")"
+	}
+	return name
+}
+
+func docAttributes(f *Field) string {
+	var attrs []string
+	switch {
+	case f.Key:
+		attrs = append(attrs, "key")
+	case f.Unique:
+		attrs = append(attrs, "unique index")
+	case f.Index:
+		attrs = append(attrs, "index")
+	}
+	if f.IsRequired() {
+		attrs = append(attrs, "required")
+	}
+	if f.HasDefault {
+		attrs = append(attrs, "default "+f.Default)
+	}
+	return strings.Join(attrs, ", ")
+}
+
+// mdCell keeps text inside one cell of a Markdown table.
+func mdCell(text string) string {
+	text = strings.ReplaceAll(text, "|", `\|`)
+	return strings.ReplaceAll(text, "\n", "<br>")
+}
+
+var markdownDocs = template.Must(template.New("markdown").Funcs(template.FuncMap{"cell": mdCell}).Parse(
+	`# {{if .Group}}{{.Group}} {{end}}data dictionary
+{{range .Tables}}
+## Table ` + "`{{.Name}}`" + `
+
+Message ` + "`{{.Message}}`" + `{{if .Keys}}, keyed by {{range $i, $k := .Keys}}{{if $i}}, {{end}}` + "`{{$k}}`" + `{{end}}{{end}}.
+{{if .Doc}}
+{{.Doc}}
+{{end}}
+| Column | Type | Attributes | Description |
+| --- | --- | --- | --- |
+{{range .Columns}}| {{.Name}} | {{cell .Type}} | {{.Attributes}} | {{cell .Doc}} |
+{{end}}{{if .Operations}}
+| Operation | Method | Description |
+| --- | --- | --- |
+{{range .Operations}}| {{.Op}} | {{.Service}}.{{.Name}} | {{cell .Doc}} |
+{{end}}{{end}}{{end}}{{if .Enums}}
+## Enums
+{{range .Enums}}
+### ` + "`{{.Name}}`" + `
+{{if .Doc}}
+{{.Doc}}
+{{end}}
+{{range .Values}}- ` + "`{{.}}`" + `
+{{end}}{{end}}{{end}}{{if .Services}}
+## Services
+{{range .Services}}
+### ` + "`{{.Name}}`" + `
+
+| Method | Operation | Table | Request | Response | Description |
+| --- | --- | --- | --- | --- | --- |
+{{range .Methods}}| {{.Name}} | {{.Op}} | {{.Table}} | {{.Input}} | {{.Output}} | {{cell .Doc}} |
+{{end}}{{end}}{{end}}`))
+
+var htmlDocs = htmltemplate.Must(htmltemplate.New("html").Parse(
+	`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>{{if .Group}}{{.Group}} {{end}}data dictionary</title>
+<style>
+body { font-family: sans-serif; }
+table { border-collapse: collapse; margin-bottom: 1em; }
+th, td { border: 1px solid #ccc; padding: 4px 8px; text-align: left; vertical-align: top; }
+.doc { white-space: pre-line; }
+</style>
+</head>
+<body>
+<h1>{{if .Group}}{{.Group}} {{end}}data dictionary</h1>
+{{range .Tables}}
+<h2 id="table-{{.Name}}">Table <code>{{.Name}}</code></h2>
+<p>Message <code>{{.Message}}</code>{{if .Keys}}, keyed by {{range $i, $k := .Keys}}{{if $i}}, {{end}}<code>{{$k}}</code>{{end}}{{end}}.</p>
+{{if .Doc}}<p class="doc">{{.Doc}}</p>
+{{end}}<table>
+<tr><th>Column</th><th>Type</th><th>Attributes</th><th>Description</th></tr>
+{{range .Columns}}<tr><td>{{.Name}}</td><td>{{.Type}}</td><td>{{.Attributes}}</td><td class="doc">{{.Doc}}</td></tr>
+{{end}}</table>
+{{if .Operations}}<table>
+<tr><th>Operation</th><th>Method</th><th>Description</th></tr>
+{{range .Operations}}<tr><td>{{.Op}}</td><td>{{.Service}}.{{.Name}}</td><td class="doc">{{.Doc}}</td></tr>
+{{end}}</table>
+{{end}}{{end}}{{if .Enums}}
+<h2>Enums</h2>
+{{range .Enums}}<h3><code>{{.Name}}</code></h3>
+{{if .Doc}}<p class="doc">{{.Doc}}</p>
+{{end}}<ul>
+{{range .Values}}<li><code>{{.}}</code></li>
+{{end}}</ul>
+{{end}}{{end}}{{if .Services}}
+<h2>Services</h2>
+{{range .Services}}<h3><code>{{.Name}}</code></h3>
+<table>
+<tr><th>Method</th><th>Operation</th><th>Table</th><th>Request</th><th>Response</th><th>Description</th></tr>
+{{range .Methods}}<tr><td>{{.Name}}</td><td>{{.Op}}</td><td>{{.Table}}</td><td>{{.Input}}</td><td>{{.Output}}</td><td class="doc">{{.Doc}}</td></tr>
+{{end}}</table>
+{{end}}{{end}}</body>
+</html>
+`))
@@ -0,0 +1,114 @@
+package storpc
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const docsSchema = `syntax = "proto3";
+package shop;
+
+import "storpc/options.proto";
+import "google/protobuf/timestamp.proto";
+
+// Lifecycle of an order.
+enum Status {
+  STATUS_UNKNOWN = 0;
+  STATUS_OPEN = 1;
+}
+
+// Orders section.
+
+// An order placed by a customer.
+// Orders are never deleted.
+message Order {
+  option (storpc.table) = "orders";
+  string id = 1 [(storpc.key) = true]; // order number | unique
+  Status status = 2 [(storpc.index) = true];
+  google.protobuf.Timestamp placed = 3; // <placed> time
+}
+
+message GetOrderRequest {
+  string id = 1;
+}
+
+service Shop {
+  // Reads one order by id.
+  rpc GetOrder(GetOrderRequest) returns (Order);
+}
+`
+
+func TestParseComments(t *testing.T) {
+	gen := parseSchema(t, map[string]string{"schema.proto": docsSchema})
+
+	order := gen.Body.Message("shop.Order")
+	want := Comments{
+		Leading:  "An order placed by a customer.\nOrders are never deleted.",
+		Detached: []string{"Orders section."},
+	}
+	if !reflect.DeepEqual(order.Comments, want) {
+		t.Errorf("Order comments = %#v, want %#v", order.Comments, want)
+	}
+	if got := order.Fields[0].Comments; got.Trailing != "order number | unique" || got.Leading != "" {
+		t.Errorf("id comments = %#v", got)
+	}
+	if got := gen.Body.Enum("shop.Status").Comments.Leading; got != "Lifecycle of an order." {
+		t.Errorf("Status comment = %q", got)
+	}
+	if got := gen.Body.Method("shop.Shop", "GetOrder").Comments.Leading; got != "Reads one order by id." {
+		t.Errorf("GetOrder comment = %q", got)
+	}
+
+	data, err := MarshalGenIR(gen)
+	if err != nil {
+		t.Fatalf("MarshalGenIR failed: %v", err)
+	}
+	decoded, err := UnmarshalGenIR(data)
+	if err != nil {
+		t.Fatalf("UnmarshalGenIR failed: %v", err)
+	}
+	if !reflect.DeepEqual(decoded.Body, gen.Body) {
+		t.Errorf("comments do not round trip")
+	}
+}
+
+func TestGenerateDocs(t *testing.T) {
+	gen := parseSchema(t, map[string]string{"schema.proto": docsSchema})
+
+	markdown, err := GenerateDocs(gen, DocFormatMarkdown)
+	if err != nil {
+		t.Fatalf("GenerateDocs failed: %v", err)
+	}
+	for _, want := range []string{
+		"## Table `orders`",
+		"Message `shop.Order`, keyed by `id`.",
+		"| id | string | key | order number \\| unique |",
+		"| status | shop.Status | index |  |",
+		"| placed | google.protobuf.Timestamp (time) |  | <placed> time |",
+		"| get | shop.Shop.GetOrder | Reads one order by id. |",
+		"- `STATUS_OPEN = 1`",
+		"| GetOrder | get | orders | shop.GetOrderRequest | shop.Order | Reads one order by id. |",
+	} {
+		if !strings.Contains(string(markdown), want) {
+			t.Errorf("markdown is missing %q:\n%s", want, markdown)
+		}
+	}
+
+	html, err := GenerateDocs(gen, DocFormatHTML)
+	if err != nil {
+		t.Fatalf("GenerateDocs failed: %v", err)
+	}
+	for _, want := range []string{
+		`<h2 id="table-orders">Table <code>orders</code></h2>`,
+		`<td class="doc">&lt;placed&gt; time</td>`,
+	} {
+		if !strings.Contains(string(html), want) {
+			t.Errorf("html is missing %q:\n%s", want, html)
+		}
+	}
+
+	if _, err := GenerateDocs(gen, "pdf"); err == nil {
+		t.Error("unknown format accepted")
+	}
+}
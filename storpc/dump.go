@@ -0,0 +1,466 @@
+package storpc
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"slices"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+)
+
+// WriteText writes the IR for reading: every message with its fields by
+// number, every enum with its values and every service with the operation
+// and table of its methods.
+func (gen *GenIR) WriteText(w io.Writer) error {
+	var b strings.Builder
+	tw := tabwriter.NewWriter(&b, 0, 4, 2, ' ', 0)
+	body := gen.Body
+
+	if h := gen.Header; h != nil {
+		fmt.Fprintf(tw, "GenIR %d.%d.%d, %d messages, %d enums\n",
+			h.VersionMajor, h.VersionMinor, h.VersionPatch, h.NumMessages, h.NumEnums)
+	}
+	fmt.Fprintf(tw, "group %v, key group %v\n", body.Group, body.KeyGroup)
+	for _, file := range body.Files {
+		if slices.Contains(body.Roots, file) {
+			file += " (root)"
+		}
+		fmt.Fprintf(tw, "file %v\n", file)
+	}
+
+	for i := range body.Messages {
+		m := &body.Messages[i]
+		fmt.Fprintf(tw, "\nmessage %v", m.Name)
+		if m.Table != "" {
+			fmt.Fprintf(tw, ", table %v", m.Table)
+		}
+		if m.MapEntry {
+			fmt.Fprint(tw, ", map entry")
+		}
+		fmt.Fprintln(tw)
+		for j := range m.Fields {
+			f := &m.Fields[j]
+			fmt.Fprintf(tw, "  %d\t%v\t%v\t%v\n", f.Number, f.Name, docType(f), docAttributes(f))
+		}
+	}
+
+	for _, e := range body.Enums {
+		fmt.Fprintf(tw, "\nenum %v\n", e.Name)
+		for _, v := range e.Values {
+			fmt.Fprintf(tw, "  %d\t%v\n", v.Value, v.Name)
+		}
+	}
+
+	for _, svc := range body.Services {
+		fmt.Fprintf(tw, "\nservice %v (%v)\n", svc.Name, svc.File)
+		for _, m := range svc.Methods {
+			fmt.Fprintf(tw, "  %v\t%v -> %v\t%v\t%v\n", m.Name, m.Input, m.Output, OpString(m.Operation), m.Table)
+		}
+	}
+	tw.Flush()
+
+	// tabwriter pads empty trailing cells
+	lines := strings.Split(b.String(), "\n")
+	for i := range lines {
+		lines[i] = strings.TrimRight(lines[i], " ")
+	}
+	_, err := io.WriteString(w, strings.Join(lines, "\n"))
+	return err
+}
+
+type jsonGenIR struct {
+	Version  string        `json:"version,omitempty"`
+	Group    string        `json:"group"`
+	KeyGroup string        `json:"key_group"`
+	Files    []string      `json:"files"`
+	Roots    []string      `json:"roots"`
+	Messages []jsonMessage `json:"messages"`
+	Enums    []jsonEnum    `json:"enums"`
+	Services []jsonService `json:"services"`
+}
+
+type jsonMessage struct {
+	Name     string      `json:"name"`
+	Table    string      `json:"table,omitempty"`
+	MapEntry bool        `json:"map_entry,omitempty"`
+	Fields   []jsonField `json:"fields"`
+}
+
+type jsonField struct {
+	Number     int32    `json:"number"`
+	Name       string   `json:"name"`
+	Type       string   `json:"type"`
+	Logical    string   `json:"logical,omitempty"`
+	Attributes []string `json:"attributes,omitempty"`
+}
+
+type jsonEnum struct {
+	Name   string          `json:"name"`
+	Values []jsonEnumValue `json:"values"`
+}
+
+type jsonEnumValue struct {
+	Name  string `json:"name"`
+	Value int32  `json:"value"`
+}
+
+type jsonService struct {
+	Name    string       `json:"name"`
+	File    string       `json:"file"`
+	Methods []jsonMethod `json:"methods"`
+}
+
+type jsonMethod struct {
+	Name      string `json:"name"`
+	Input     string `json:"input"`
+	Output    string `json:"output"`
+	Operation string `json:"operation"`
+	Table     string `json:"table,omitempty"`
+}
+
+// WriteJSON writes the IR as indented JSON, with type names and operations
+// spelled out.
+func (gen *GenIR) WriteJSON(w io.Writer) error {
+	body := gen.Body
+	out := jsonGenIR{
+		Group:    body.Group,
+		KeyGroup: body.KeyGroup,
+		Files:    body.Files,
+		Roots:    body.Roots,
+		Messages: []jsonMessage{},
+		Enums:    []jsonEnum{},
+		Services: []jsonService{},
+	}
+	if h := gen.Header; h != nil {
+		out.Version = fmt.Sprintf("%d.%d.%d", h.VersionMajor, h.VersionMinor, h.VersionPatch)
+	}
+
+	for i := range body.Messages {
+		m := &body.Messages[i]
+		message := jsonMessage{Name: m.Name, Table: m.Table, MapEntry: m.MapEntry, Fields: []jsonField{}}
+		for j := range m.Fields {
+			f := &m.Fields[j]
+			field := jsonField{Number: f.Number, Name: f.Name, Type: fieldTypeName(f)}
+			if f.Logical != LogicalNone {
+				field.Logical = LogicalString(f.Logical)
+			}
+			if attrs := docAttributes(f); attrs != "" {
+				field.Attributes = strings.Split(attrs, ", ")
+			}
+			message.Fields = append(message.Fields, field)
+		}
+		out.Messages = append(out.Messages, message)
+	}
+
+	for _, e := range body.Enums {
+		enum := jsonEnum{Name: e.Name, Values: []jsonEnumValue{}}
+		for _, v := range e.Values {
+			enum.Values = append(enum.Values, jsonEnumValue{v.Name, v.Value})
+		}
+		out.Enums = append(out.Enums, enum)
+	}
+
+	for _, svc := range body.Services {
+		service := jsonService{Name: svc.Name, File: svc.File, Methods: []jsonMethod{}}
+		for _, m := range svc.Methods {
+			service.Methods = append(service.Methods, jsonMethod{
+				Name:      m.Name,
+				Input:     m.Input,
+				Output:    m.Output,
+				Operation: OpString(m.Operation),
+				Table:     m.Table,
+			})
+		}
+		out.Services = append(out.Services, service)
+	}
+
+	return writeIndented(w, out)
+}
+
+// MethodDump writes calls for reading. A MethodIR holds enum values and its
+// key group as numbers; Schema and Groups, either of which may be nil,
+// resolve their names. Field names come from the schema the call was
+// decoded with.
+type MethodDump struct {
+	Schema *GenBody
+	Groups *GroupRegistry
+}
+
+// WriteText writes the header of ir on one line, then its body with one
+// field per line.
+func (d MethodDump) WriteText(w io.Writer, ir *MethodIR) error {
+	var b strings.Builder
+	d.writeHeader(&b, ir.Header)
+	b.WriteString(ir.Body.Type)
+	b.WriteByte(' ')
+	d.writeBody(&b, ir.Body, "")
+	b.WriteByte('\n')
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// WriteBatchText writes a batch item by item.
+func (d MethodDump) WriteBatchText(w io.Writer, batch *MethodBatch) error {
+	mode := "best effort"
+	if batch.Atomic {
+		mode = "atomic"
+	}
+	if _, err := fmt.Fprintf(w, "batch, %v, %d items\n", mode, len(batch.Items)); err != nil {
+		return err
+	}
+	for i, item := range batch.Items {
+		if _, err := fmt.Fprintf(w, "\nitem %d: ", i); err != nil {
+			return err
+		}
+		if err := d.WriteText(w, item); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (d MethodDump) writeHeader(b *strings.Builder, h *MethodHeader) {
+	fmt.Fprintf(b, "MethodIR %d.%d.%d %v group %v", h.VersionMajor, h.VersionMinor, h.VersionPatch,
+		OpString(h.Operation), d.groupName(h.Group))
+	if features := featureNames(h.Features); len(features) > 0 {
+		fmt.Fprintf(b, " features %v", strings.Join(features, ","))
+	}
+	b.WriteByte('\n')
+}
+
+func (d MethodDump) writeBody(b *strings.Builder, body *MethodBody, indent string) {
+	b.WriteString("{\n")
+	for _, fv := range body.Fields {
+		fmt.Fprintf(b, "%v  %d", indent, fv.Number)
+		if fv.Field != nil {
+			fmt.Fprintf(b, " %v", fv.Field.Name)
+		}
+		b.WriteString(": ")
+		d.writeValue(b, fv.Field, fv.Value, indent+"  ")
+		b.WriteByte('\n')
+	}
+	b.WriteString(indent + "}")
+}
+
+// writeValue writes v, a value of f when f is not nil.
+func (d MethodDump) writeValue(b *strings.Builder, f *Field, v Value, indent string) {
+	switch v.Kind {
+	case ValueList:
+		b.WriteByte('[')
+		for i, e := range v.List {
+			if i > 0 {
+				b.WriteString(", ")
+			}
+			d.writeValue(b, f, e, indent)
+		}
+		b.WriteByte(']')
+	case ValueMap:
+		var key, value *Field
+		if f != nil {
+			key, value = f.MapKey, f.MapValue
+		}
+		b.WriteByte('{')
+		for i, e := range v.Map {
+			if i > 0 {
+				b.WriteString(", ")
+			}
+			d.writeValue(b, key, e.Key, indent)
+			b.WriteString(": ")
+			d.writeValue(b, value, e.Value, indent)
+		}
+		b.WriteByte('}')
+	case ValueMessage:
+		b.WriteString(v.Message.Type)
+		b.WriteByte(' ')
+		d.writeBody(b, v.Message, indent)
+	case ValueEnum:
+		if name := d.enumName(f, v.Int); name != "" {
+			b.WriteString(name)
+			return
+		}
+		b.WriteString(strconv.FormatInt(v.Int, 10))
+	case ValueInt:
+		b.WriteString(strconv.FormatInt(v.Int, 10))
+	case ValueUint:
+		b.WriteString(strconv.FormatUint(v.Uint, 10))
+	case ValueDouble:
+		b.WriteString(strconv.FormatFloat(v.Float, 'g', -1, 64))
+	case ValueFloat:
+		b.WriteString(strconv.FormatFloat(v.Float, 'g', -1, 32))
+	case ValueBool:
+		b.WriteString(strconv.FormatBool(v.Bool))
+	case ValueString:
+		b.WriteString(strconv.Quote(v.String))
+	case ValueBytes:
+		fmt.Fprintf(b, "0x%x", v.Bytes)
+	default:
+		b.WriteString(ValueKindString(v.Kind))
+	}
+}
+
+type jsonMethodIR struct {
+	Version   string          `json:"version"`
+	Operation string          `json:"operation"`
+	Group     uint32          `json:"group"`
+	GroupName string          `json:"group_name,omitempty"`
+	Features  []string        `json:"features"`
+	Body      *jsonMethodBody `json:"body"`
+}
+
+type jsonMethodBody struct {
+	Type   string           `json:"type"`
+	Fields []jsonFieldValue `json:"fields"`
+}
+
+type jsonFieldValue struct {
+	Number int32  `json:"number"`
+	Name   string `json:"name,omitempty"`
+	Kind   string `json:"kind"`
+	Value  any    `json:"value"`
+}
+
+type jsonMapEntry struct {
+	Key   any `json:"key"`
+	Value any `json:"value"`
+}
+
+// WriteJSON writes ir as indented JSON. 64 bit integers are written as
+// numbers with every digit; enum values are written by name when the schema
+// has one.
+func (d MethodDump) WriteJSON(w io.Writer, ir *MethodIR) error {
+	return writeIndented(w, d.jsonMethodIR(ir))
+}
+
+func (d MethodDump) WriteBatchJSON(w io.Writer, batch *MethodBatch) error {
+	out := struct {
+		Atomic bool            `json:"atomic"`
+		Items  []*jsonMethodIR `json:"items"`
+	}{Atomic: batch.Atomic, Items: []*jsonMethodIR{}}
+	for _, item := range batch.Items {
+		out.Items = append(out.Items, d.jsonMethodIR(item))
+	}
+	return writeIndented(w, out)
+}
+
+func (d MethodDump) jsonMethodIR(ir *MethodIR) *jsonMethodIR {
+	h := ir.Header
+	out := &jsonMethodIR{
+		Version:   fmt.Sprintf("%d.%d.%d", h.VersionMajor, h.VersionMinor, h.VersionPatch),
+		Operation: OpString(h.Operation),
+		Group:     h.Group,
+		Features:  featureNames(h.Features),
+		Body:      d.jsonBody(ir.Body),
+	}
+	if d.Groups != nil {
+		out.GroupName, _ = d.Groups.Name(h.Group)
+	}
+	if out.Features == nil {
+		out.Features = []string{}
+	}
+	return out
+}
+
+func (d MethodDump) jsonBody(body *MethodBody) *jsonMethodBody {
+	out := &jsonMethodBody{Type: body.Type, Fields: []jsonFieldValue{}}
+	for _, fv := range body.Fields {
+		field := jsonFieldValue{
+			Number: fv.Number,
+			Kind:   ValueKindString(fv.Value.Kind),
+			Value:  d.jsonValue(fv.Field, fv.Value),
+		}
+		if fv.Field != nil {
+			field.Name = fv.Field.Name
+		}
+		out.Fields = append(out.Fields, field)
+	}
+	return out
+}
+
+func (d MethodDump) jsonValue(f *Field, v Value) any {
+	switch v.Kind {
+	case ValueList:
+		list := make([]any, len(v.List))
+		for i, e := range v.List {
+			list[i] = d.jsonValue(f, e)
+		}
+		return list
+	case ValueMap:
+		var key, value *Field
+		if f != nil {
+			key, value = f.MapKey, f.MapValue
+		}
+		// keys need not be strings, so entries are written as pairs
+		entries := make([]jsonMapEntry, len(v.Map))
+		for i, e := range v.Map {
+			entries[i] = jsonMapEntry{d.jsonValue(key, e.Key), d.jsonValue(value, e.Value)}
+		}
+		return entries
+	case ValueMessage:
+		return d.jsonBody(v.Message)
+	case ValueEnum:
+		if name := d.enumName(f, v.Int); name != "" {
+			return name
+		}
+		return json.Number(strconv.FormatInt(v.Int, 10))
+	case ValueInt:
+		return json.Number(strconv.FormatInt(v.Int, 10))
+	case ValueUint:
+		return json.Number(strconv.FormatUint(v.Uint, 10))
+	case ValueDouble, ValueFloat:
+		if math.IsNaN(v.Float) || math.IsInf(v.Float, 0) {
+			return strconv.FormatFloat(v.Float, 'g', -1, 64)
+		}
+		return v.Float
+	case ValueBool:
+		return v.Bool
+	case ValueString:
+		return v.String
+	case ValueBytes:
+		return v.Bytes
+	}
+	return nil
+}
+
+func (d MethodDump) groupName(id uint32) string {
+	if d.Groups != nil {
+		if name, ok := d.Groups.Name(id); ok {
+			return fmt.Sprintf("%d (%v)", id, name)
+		}
+	}
+	return strconv.FormatUint(uint64(id), 10)
+}
+
+// enumName is the name of enum value n of f, empty when it is unknown.
+func (d MethodDump) enumName(f *Field, n int64) string {
+	if d.Schema == nil || f == nil {
+		return ""
+	}
+	e := d.Schema.EnumOf(f)
+	if e == nil {
+		return ""
+	}
+	for _, v := range e.Values {
+		if int64(v.Value) == n && v.AliasOf == "" {
+			return v.Name
+		}
+	}
+	return ""
+}
+
+// featureNames names the MethodHeader features, unknown bits in hex.
+func featureNames(features uint16) []string {
+	var names []string
+	if unknown := features &^ KnownFeatures; unknown != 0 {
+		names = append(names, fmt.Sprintf("%#04x", unknown))
+	}
+	return names
+}
+
+func writeIndented(w io.Writer, v any) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
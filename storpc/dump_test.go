@@ -0,0 +1,185 @@
+package storpc
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestGenIRWriteText(t *testing.T) {
+	schema := parseSchema(t, map[string]string{"schema.proto": methodSchema}).Body
+	gen := &GenIR{Header: NewGenHeader(3, 1), Body: schema}
+
+	var b strings.Builder
+	if err := gen.WriteText(&b); err != nil {
+		t.Fatalf("WriteText failed: %v", err)
+	}
+	out := b.String()
+	for _, want := range []string{
+		"GenIR 1.0.0, 3 messages, 1 enums\n",
+		"message shop.Order, table orders\n",
+		"  1   id        int64                   key\n",
+		"  2   quantity  uint32\n",
+		"  13  counts    map<string, int32>",
+		"  -1  STATUS_VOID\n",
+		"  InsertOrder  shop.Order -> shop.Order  insert  shop.Order\n",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("dump lacks %q:\n%v", want, out)
+		}
+	}
+	if strings.Contains(out, "0x") {
+		t.Errorf("dump holds pointers:\n%v", out)
+	}
+}
+
+func TestGenIRWriteJSON(t *testing.T) {
+	schema := parseSchema(t, map[string]string{"schema.proto": methodSchema}).Body
+	gen := &GenIR{Header: NewGenHeader(3, 1), Body: schema}
+
+	var b strings.Builder
+	if err := gen.WriteJSON(&b); err != nil {
+		t.Fatalf("WriteJSON failed: %v", err)
+	}
+	var out jsonGenIR
+	if err := json.Unmarshal([]byte(b.String()), &out); err != nil {
+		t.Fatalf("invalid JSON: %v\n%v", err, b.String())
+	}
+
+	var order *jsonMessage
+	for i := range out.Messages {
+		if out.Messages[i].Name == "shop.Order" {
+			order = &out.Messages[i]
+		}
+	}
+	if order == nil || order.Table != "orders" || len(order.Fields) != 15 {
+		t.Fatalf("shop.Order = %+v", order)
+	}
+	if f := order.Fields[0]; f.Number != 1 || f.Type != "int64" || len(f.Attributes) != 1 || f.Attributes[0] != "key" {
+		t.Errorf("id = %+v", f)
+	}
+	if m := out.Services[0].Methods[0]; m.Operation != "insert" || m.Table != "shop.Order" {
+		t.Errorf("InsertOrder = %+v", m)
+	}
+}
+
+func TestMethodDump(t *testing.T) {
+	schema := parseSchema(t, map[string]string{"schema.proto": methodSchema}).Body
+	groups, _ := NewGroupRegistry(nil)
+	for _, name := range []string{"a", "b", "c", "d", "e", "f", "shop"} {
+		groups.ID(name)
+	}
+
+	data, err := MarshalMethodIR(goldenOrder())
+	if err != nil {
+		t.Fatalf("MarshalMethodIR failed: %v", err)
+	}
+	ir, err := UnmarshalMethodIR(schema, data)
+	if err != nil {
+		t.Fatalf("UnmarshalMethodIR failed: %v", err)
+	}
+
+	dump := MethodDump{Schema: schema, Groups: groups}
+	var b strings.Builder
+	if err := dump.WriteText(&b, ir); err != nil {
+		t.Fatalf("WriteText failed: %v", err)
+	}
+	want := `MethodIR 1.0.0 insert group 7 (shop)
+shop.Order {
+  1 id: -42
+  2 quantity: 3
+  3 checksum: 1099511627776
+  4 weight: 1.5
+  5 discount: 0.25
+  6 gift: false
+  7 note: "leave at door"
+  8 blob: 0x000102
+  9 status: STATUS_VOID
+  10 total: shop.Money {
+    1 currency: "EUR"
+    2 units: -100
+  }
+  11 tags: ["a", "b"]
+  12 refunds: [shop.Money {
+    1 currency: "EUR"
+    2 units: 5
+  }]
+  13 counts: {"x": 1, "y": 0}
+  14 by_line: {10: shop.Money {
+    1 currency: "USD"
+    2 units: 2
+  }}
+}
+`
+	if b.String() != want {
+		t.Errorf("WriteText =\n%v\nwant\n%v", b.String(), want)
+	}
+
+	// without a schema fields are shown by number only
+	ir, err = UnmarshalMethodIR(nil, data)
+	if err != nil {
+		t.Fatalf("UnmarshalMethodIR failed: %v", err)
+	}
+	b.Reset()
+	if err := (MethodDump{}).WriteText(&b, ir); err != nil {
+		t.Fatalf("WriteText failed: %v", err)
+	}
+	if out := b.String(); !strings.HasPrefix(out, "MethodIR 1.0.0 insert group 7\n") || !strings.Contains(out, "  9: -1\n") {
+		t.Errorf("WriteText without schema =\n%v", out)
+	}
+}
+
+func TestMethodDumpJSON(t *testing.T) {
+	schema := parseSchema(t, map[string]string{"schema.proto": methodSchema}).Body
+	data, err := MarshalMethodBatch(goldenBatch())
+	if err != nil {
+		t.Fatalf("MarshalMethodBatch failed: %v", err)
+	}
+	batch, err := UnmarshalMethodBatch(schema, data)
+	if err != nil {
+		t.Fatalf("UnmarshalMethodBatch failed: %v", err)
+	}
+
+	var b strings.Builder
+	if err := (MethodDump{Schema: schema}).WriteBatchJSON(&b, batch); err != nil {
+		t.Fatalf("WriteBatchJSON failed: %v", err)
+	}
+	var out struct {
+		Atomic bool
+		Items  []struct {
+			Operation string
+			Group     uint32
+			Features  []string
+			Body      struct {
+				Type   string
+				Fields []struct {
+					Number int32
+					Name   string
+					Kind   string
+					Value  json.RawMessage
+				}
+			}
+		}
+	}
+	if err := json.Unmarshal([]byte(b.String()), &out); err != nil {
+		t.Fatalf("invalid JSON: %v\n%v", err, b.String())
+	}
+	if !out.Atomic || len(out.Items) != 2 || out.Items[1].Operation != "delete" || out.Items[1].Group != 8 {
+		t.Fatalf("batch = %+v", out)
+	}
+
+	values := make(map[string]string)
+	for _, f := range out.Items[0].Body.Fields {
+		values[f.Name] = string(f.Value)
+	}
+	for name, want := range map[string]string{
+		"id":       "-42",
+		"checksum": "1099511627776",
+		"status":   `"STATUS_VOID"`,
+		"blob":     `"AAEC"`,
+	} {
+		if values[name] != want {
+			t.Errorf("%v = %v, want %v", name, values[name], want)
+		}
+	}
+}
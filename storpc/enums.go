@@ -1,8 +1,162 @@
 package storpc
 
+import "fmt"
+
 const (
 	OpInsert uint8 = 0
 	OpGet    uint8 = 1
 	OpUpdate uint8 = 2
 	OpDelete uint8 = 3
+	OpList   uint8 = 4
+
+	OpUnknown uint8 = 0xFF // operation could not be resolved
+)
+
+func OpString(op uint8) string {
+	switch op {
+	case OpInsert:
+		return "insert"
+	case OpGet:
+		return "get"
+	case OpUpdate:
+		return "update"
+	case OpDelete:
+		return "delete"
+	case OpList:
+		return "list"
+	case OpUnknown:
+		return "unknown"
+	}
+	return fmt.Sprintf("op(%d)", op)
+}
+
+// kinds of typed values in a MethodBody, also the value tags of the binary
+// MethodIR format
+const (
+	ValueNone    uint8 = 0 // unset
+	ValueInt     uint8 = 1 // every signed integer kind
+	ValueUint    uint8 = 2 // every unsigned integer kind
+	ValueDouble  uint8 = 3
+	ValueFloat   uint8 = 4
+	ValueBool    uint8 = 5
+	ValueString  uint8 = 6
+	ValueBytes   uint8 = 7
+	ValueEnum    uint8 = 8
+	ValueMessage uint8 = 9
+	ValueList    uint8 = 10
+	ValueMap     uint8 = 11
+)
+
+func ValueKindString(kind uint8) string {
+	switch kind {
+	case ValueNone:
+		return "none"
+	case ValueInt:
+		return "int"
+	case ValueUint:
+		return "uint"
+	case ValueDouble:
+		return "double"
+	case ValueFloat:
+		return "float"
+	case ValueBool:
+		return "bool"
+	case ValueString:
+		return "string"
+	case ValueBytes:
+		return "bytes"
+	case ValueEnum:
+		return "enum"
+	case ValueMessage:
+		return "message"
+	case ValueList:
+		return "list"
+	case ValueMap:
+		return "map"
+	}
+	return fmt.Sprintf("value(%d)", kind)
+}
+
+// outcomes of the items of a MethodBatch
+const (
+	ResultOK      uint8 = 0
+	ResultFailed  uint8 = 1
+	ResultAborted uint8 = 2 // not applied because another item of an atomic batch failed
+)
+
+func ResultString(status uint8) string {
+	switch status {
+	case ResultOK:
+		return "ok"
+	case ResultFailed:
+		return "failed"
+	case ResultAborted:
+		return "aborted"
+	}
+	return fmt.Sprintf("result(%d)", status)
+}
+
+const (
+	CardinalityOptional uint8 = 0
+	CardinalityRequired uint8 = 1
+	CardinalityRepeated uint8 = 2
 )
+
+const (
+	KindScalar  uint8 = 0
+	KindMessage uint8 = 1
+	KindEnum    uint8 = 2
+)
+
+// logical column types of fields typed with a well-known type
+const (
+	LogicalNone     uint8 = 0
+	LogicalTime     uint8 = 1 // google.protobuf.Timestamp
+	LogicalDuration uint8 = 2 // google.protobuf.Duration
+	LogicalNullable uint8 = 3 // wrapper types, a scalar that may be null
+	LogicalJSON     uint8 = 4 // google.protobuf.Struct, Value and ListValue
+	LogicalAny      uint8 = 5 // google.protobuf.Any, a value tagged with its type
+)
+
+func LogicalString(logical uint8) string {
+	switch logical {
+	case LogicalNone:
+		return "none"
+	case LogicalTime:
+		return "time"
+	case LogicalDuration:
+		return "duration"
+	case LogicalNullable:
+		return "nullable"
+	case LogicalJSON:
+		return "json"
+	case LogicalAny:
+		return "any"
+	}
+	return fmt.Sprintf("logical(%d)", logical)
+}
+
+// field presence resolved from the syntax or editions features
+const (
+	PresenceImplicit uint8 = 0 // unset reads as the zero value
+	PresenceExplicit uint8 = 1
+	PresenceRequired uint8 = 2 // proto2 required or LEGACY_REQUIRED
+)
+
+const (
+	SeverityError   uint8 = 0
+	SeverityWarning uint8 = 1
+	SeverityInfo    uint8 = 2
+)
+
+func SeverityString(severity uint8) string {
+	switch severity {
+	case SeverityError:
+		return "error"
+	case SeverityWarning:
+		return "warning"
+	case SeverityInfo:
+		return "info"
+	}
+	return fmt.Sprintf("severity(%d)", severity)
+}
@@ -0,0 +1,614 @@
+package storpc
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"os"
+
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+/*
+Binary GenIR format
+
+	magic   4 bytes   "SGIR"
+	header 11 bytes   GenHeader, integers big endian
+	body    n bytes   GenBody record
+
+The header is fixed size so the schema version can be checked before the
+body is read. Readers reject a different major version.
+
+A record is a sequence of properties in protobuf wire format: a tag, then a
+varint or a length delimited value. Strings and nested records are length
+delimited, integers and bools are varints, signed integers are zigzag
+encoded. Zero values are omitted and repeated properties are written once per
+element. Readers skip tags they do not know, so new properties can be added
+in minor versions.
+
+	GenBody     1 Message*  2 Enum*  3 group  4 Service*  5 file*  6 root*
+	            7 key_group
+	Message     1 name  2 case_name  3 Field*  4 Oneof*  5 map_entry  6 table
+	            7 case_table  8 lint_ignore*  9 comments Comments
+	            10 location Location
+	Field       1 name  2 case_name  3 type  4 kind  5 number  6 key  7 index
+	            8 unique  9 cardinality  10 oneof  11 has_presence
+	            12 proto3_optional  13 map_key Field  14 map_value Field
+	            15 logical  16 presence  17 default  18 has_default  19 packed
+	            20 delimited  21 comments Comments
+	Oneof       1 name  2 field_number*
+	Enum        1 name  2 case_name  3 EnumValue*  4 closed  5 EnumRange*
+	            6 reserved_name*  7 comments Comments
+	EnumValue   1 name  2 value (zigzag)  3 alias_of
+	EnumRange   1 start (zigzag)  2 end (zigzag)
+	Service     1 name  2 file  3 Method*  4 key_group
+	Method      1 name  2 input  3 output  4 operation  5 table
+	            6 client_streaming  7 server_streaming  8 comments Comments
+	            9 location Location
+	Comments    1 leading  2 trailing  3 detached*
+	Location    1 file  2 line  3 column
+*/
+
+const GenHeaderSize = 11
+
+var genMagic = [4]byte{'S', 'G', 'I', 'R'}
+
+var ErrNotGenIR = errors.New("not a binary GenIR")
+
+// MarshalGenIR encodes the IR in the binary GenIR format. The header is
+// rebuilt from the body so the counts always match.
+func MarshalGenIR(gen *GenIR) ([]byte, error) {
+	if gen == nil || gen.Body == nil {
+		return nil, errors.New("GenIR has no body")
+	}
+	body := gen.Body
+
+	header := NewGenHeader(uint32(len(body.Messages)), uint32(len(body.Enums)))
+
+	b := make([]byte, 0, 256)
+	b = append(b, genMagic[:]...)
+	b = append(b, header.VersionMajor, header.VersionMinor, header.VersionPatch)
+	b = binary.BigEndian.AppendUint32(b, header.NumMessages)
+	b = binary.BigEndian.AppendUint32(b, header.NumEnums)
+
+	w := &recordWriter{b: b}
+	for i := range body.Messages {
+		w.record(1, func(w *recordWriter) { writeMessage(w, &body.Messages[i]) })
+	}
+	for i := range body.Enums {
+		w.record(2, func(w *recordWriter) { writeEnum(w, &body.Enums[i]) })
+	}
+	w.string(3, body.Group)
+	for i := range body.Services {
+		w.record(4, func(w *recordWriter) { writeService(w, &body.Services[i]) })
+	}
+	w.strings(5, body.Files)
+	w.strings(6, body.Roots)
+	w.string(7, body.KeyGroup)
+
+	return w.b, nil
+}
+
+// UnmarshalGenIR decodes a binary GenIR.
+func UnmarshalGenIR(data []byte) (*GenIR, error) {
+	header, rest, err := readGenHeader(data)
+	if err != nil {
+		return nil, err
+	}
+
+	body := NewGenBody()
+	err = readRecord(rest, func(tag protowire.Number, v value) error {
+		switch tag {
+		case 1:
+			var m Message
+			body.Messages = append(body.Messages, m)
+			return readMessage(v.bytes, &body.Messages[len(body.Messages)-1])
+		case 2:
+			var e Enum
+			body.Enums = append(body.Enums, e)
+			return readEnum(v.bytes, &body.Enums[len(body.Enums)-1])
+		case 3:
+			body.Group = v.string()
+		case 4:
+			var s Service
+			body.Services = append(body.Services, s)
+			return readService(v.bytes, &body.Services[len(body.Services)-1])
+		case 5:
+			body.Files = append(body.Files, v.string())
+		case 7:
+			body.KeyGroup = v.string()
+		case 6:
+			body.Roots = append(body.Roots, v.string())
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("GenIR body: %w", err)
+	}
+
+	if int(header.NumMessages) != len(body.Messages) || int(header.NumEnums) != len(body.Enums) {
+		return nil, fmt.Errorf("GenIR header counts %d messages and %d enums, body has %d and %d",
+			header.NumMessages, header.NumEnums, len(body.Messages), len(body.Enums))
+	}
+
+	body.Reindex()
+
+	return NewGenIR(header, body), nil
+}
+
+// LoadGenIR reads a schema from options.Filepath when it holds a binary
+// GenIR, and parses the descriptor set or .proto inputs otherwise.
+func LoadGenIR(options *ProtoParserOptions) (*GenIR, error) {
+	if inputs := options.inputs(); len(inputs) == 1 && !isSourceInput(inputs) {
+		data, err := os.ReadFile(inputs[0])
+		if err != nil {
+			return nil, err
+		}
+		if len(data) >= len(genMagic) && [4]byte(data[:4]) == genMagic {
+			return UnmarshalGenIR(data)
+		}
+	}
+
+	return NewProtoParser(options).Parse()
+}
+
+func readGenHeader(data []byte) (*GenHeader, []byte, error) {
+	if len(data) < len(genMagic)+GenHeaderSize || [4]byte(data[:4]) != genMagic {
+		return nil, nil, ErrNotGenIR
+	}
+	data = data[len(genMagic):]
+
+	header := &GenHeader{
+		VersionMajor: data[0],
+		VersionMinor: data[1],
+		VersionPatch: data[2],
+		NumMessages:  binary.BigEndian.Uint32(data[3:7]),
+		NumEnums:     binary.BigEndian.Uint32(data[7:11]),
+	}
+	if err := checkVersion("GenIR", header.VersionMajor, header.VersionMinor, header.VersionPatch); err != nil {
+		return nil, nil, err
+	}
+
+	return header, data[GenHeaderSize:], nil
+}
+
+func writeMessage(w *recordWriter, m *Message) {
+	w.string(1, m.Name)
+	w.string(2, m.CaseName)
+	for i := range m.Fields {
+		w.record(3, func(w *recordWriter) { writeField(w, &m.Fields[i]) })
+	}
+	for i := range m.Oneofs {
+		w.record(4, func(w *recordWriter) {
+			w.string(1, m.Oneofs[i].Name)
+			for _, n := range m.Oneofs[i].Fields {
+				w.varint(2, uint64(n), true)
+			}
+		})
+	}
+	w.bool(5, m.MapEntry)
+	w.string(6, m.Table)
+	w.string(7, m.CaseTable)
+	w.strings(8, m.LintIgnore)
+	w.comments(9, m.Comments)
+	w.location(10, m.Location)
+}
+
+func readMessage(data []byte, m *Message) error {
+	return readRecord(data, func(tag protowire.Number, v value) error {
+		switch tag {
+		case 1:
+			m.Name = v.string()
+		case 2:
+			m.CaseName = v.string()
+		case 3:
+			var f Field
+			if err := readField(v.bytes, &f); err != nil {
+				return err
+			}
+			m.Fields = append(m.Fields, f)
+		case 4:
+			var o Oneof
+			err := readRecord(v.bytes, func(tag protowire.Number, v value) error {
+				switch tag {
+				case 1:
+					o.Name = v.string()
+				case 2:
+					o.Fields = append(o.Fields, int32(v.uint))
+				}
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+			m.Oneofs = append(m.Oneofs, o)
+		case 5:
+			m.MapEntry = v.bool()
+		case 6:
+			m.Table = v.string()
+		case 7:
+			m.CaseTable = v.string()
+		case 8:
+			m.LintIgnore = append(m.LintIgnore, v.string())
+		case 9:
+			return readComments(v.bytes, &m.Comments)
+		case 10:
+			return readLocation(v.bytes, &m.Location)
+		}
+		return nil
+	})
+}
+
+func writeField(w *recordWriter, f *Field) {
+	w.string(1, f.Name)
+	w.string(2, f.CaseName)
+	w.string(3, f.Type)
+	w.varint(4, uint64(f.Kind), false)
+	w.varint(5, uint64(f.Number), false)
+	w.bool(6, f.Key)
+	w.bool(7, f.Index)
+	w.bool(8, f.Unique)
+	w.varint(9, uint64(f.Cardinality), false)
+	w.string(10, f.Oneof)
+	w.bool(11, f.HasPresence)
+	w.bool(12, f.Proto3Optional)
+	if f.MapKey != nil {
+		w.record(13, func(w *recordWriter) { writeField(w, f.MapKey) })
+	}
+	if f.MapValue != nil {
+		w.record(14, func(w *recordWriter) { writeField(w, f.MapValue) })
+	}
+	w.varint(15, uint64(f.Logical), false)
+	w.varint(16, uint64(f.Presence), false)
+	w.string(17, f.Default)
+	w.bool(18, f.HasDefault)
+	w.bool(19, f.Packed)
+	w.bool(20, f.Delimited)
+	w.comments(21, f.Comments)
+}
+
+func readField(data []byte, f *Field) error {
+	return readRecord(data, func(tag protowire.Number, v value) error {
+		switch tag {
+		case 1:
+			f.Name = v.string()
+		case 2:
+			f.CaseName = v.string()
+		case 3:
+			f.Type = v.string()
+		case 4:
+			f.Kind = uint8(v.uint)
+		case 5:
+			f.Number = int32(v.uint)
+		case 6:
+			f.Key = v.bool()
+		case 7:
+			f.Index = v.bool()
+		case 8:
+			f.Unique = v.bool()
+		case 9:
+			f.Cardinality = uint8(v.uint)
+		case 10:
+			f.Oneof = v.string()
+		case 11:
+			f.HasPresence = v.bool()
+		case 12:
+			f.Proto3Optional = v.bool()
+		case 13:
+			f.MapKey = &Field{}
+			return readField(v.bytes, f.MapKey)
+		case 14:
+			f.MapValue = &Field{}
+			return readField(v.bytes, f.MapValue)
+		case 15:
+			f.Logical = uint8(v.uint)
+		case 16:
+			f.Presence = uint8(v.uint)
+		case 17:
+			f.Default = v.string()
+		case 18:
+			f.HasDefault = v.bool()
+		case 19:
+			f.Packed = v.bool()
+		case 20:
+			f.Delimited = v.bool()
+		case 21:
+			return readComments(v.bytes, &f.Comments)
+		}
+		return nil
+	})
+}
+
+func writeEnum(w *recordWriter, e *Enum) {
+	w.string(1, e.Name)
+	w.string(2, e.CaseName)
+	for _, ev := range e.Values {
+		w.record(3, func(w *recordWriter) {
+			w.string(1, ev.Name)
+			w.sint(2, ev.Value)
+			w.string(3, ev.AliasOf)
+		})
+	}
+	w.bool(4, e.Closed)
+	for _, r := range e.ReservedRanges {
+		w.record(5, func(w *recordWriter) {
+			w.sint(1, r.Start)
+			w.sint(2, r.End)
+		})
+	}
+	w.strings(6, e.ReservedNames)
+	w.comments(7, e.Comments)
+}
+
+func readEnum(data []byte, e *Enum) error {
+	return readRecord(data, func(tag protowire.Number, v value) error {
+		switch tag {
+		case 1:
+			e.Name = v.string()
+		case 2:
+			e.CaseName = v.string()
+		case 3:
+			var ev EnumValue
+			err := readRecord(v.bytes, func(tag protowire.Number, v value) error {
+				switch tag {
+				case 1:
+					ev.Name = v.string()
+				case 2:
+					ev.Value = v.sint()
+				case 3:
+					ev.AliasOf = v.string()
+				}
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+			e.Values = append(e.Values, ev)
+		case 4:
+			e.Closed = v.bool()
+		case 5:
+			var r EnumRange
+			err := readRecord(v.bytes, func(tag protowire.Number, v value) error {
+				switch tag {
+				case 1:
+					r.Start = v.sint()
+				case 2:
+					r.End = v.sint()
+				}
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+			e.ReservedRanges = append(e.ReservedRanges, r)
+		case 6:
+			e.ReservedNames = append(e.ReservedNames, v.string())
+		case 7:
+			return readComments(v.bytes, &e.Comments)
+		}
+		return nil
+	})
+}
+
+func writeService(w *recordWriter, s *Service) {
+	w.string(1, s.Name)
+	w.string(2, s.File)
+	for _, m := range s.Methods {
+		w.record(3, func(w *recordWriter) {
+			w.string(1, m.Name)
+			w.string(2, m.Input)
+			w.string(3, m.Output)
+			w.varint(4, uint64(m.Operation), false)
+			w.string(5, m.Table)
+			w.bool(6, m.ClientStreaming)
+			w.bool(7, m.ServerStreaming)
+			w.comments(8, m.Comments)
+			w.location(9, m.Location)
+		})
+	}
+	w.string(4, s.KeyGroup)
+}
+
+func readService(data []byte, s *Service) error {
+	return readRecord(data, func(tag protowire.Number, v value) error {
+		switch tag {
+		case 1:
+			s.Name = v.string()
+		case 2:
+			s.File = v.string()
+		case 3:
+			var m Method
+			err := readRecord(v.bytes, func(tag protowire.Number, v value) error {
+				switch tag {
+				case 1:
+					m.Name = v.string()
+				case 2:
+					m.Input = v.string()
+				case 3:
+					m.Output = v.string()
+				case 4:
+					m.Operation = uint8(v.uint)
+				case 5:
+					m.Table = v.string()
+				case 6:
+					m.ClientStreaming = v.bool()
+				case 7:
+					m.ServerStreaming = v.bool()
+				case 8:
+					return readComments(v.bytes, &m.Comments)
+				case 9:
+					return readLocation(v.bytes, &m.Location)
+				}
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+			s.Methods = append(s.Methods, m)
+		case 4:
+			s.KeyGroup = v.string()
+		}
+		return nil
+	})
+}
+
+func readComments(data []byte, c *Comments) error {
+	return readRecord(data, func(tag protowire.Number, v value) error {
+		switch tag {
+		case 1:
+			c.Leading = v.string()
+		case 2:
+			c.Trailing = v.string()
+		case 3:
+			c.Detached = append(c.Detached, v.string())
+		}
+		return nil
+	})
+}
+
+func readLocation(data []byte, l *Location) error {
+	return readRecord(data, func(tag protowire.Number, v value) error {
+		switch tag {
+		case 1:
+			l.File = v.string()
+		case 2:
+			l.Line = int(v.uint)
+		case 3:
+			l.Column = int(v.uint)
+		}
+		return nil
+	})
+}
+
+// recordWriter appends record properties in protobuf wire format.
+type recordWriter struct {
+	b []byte
+}
+
+func (w *recordWriter) string(tag protowire.Number, v string) {
+	if v == "" {
+		return
+	}
+	w.b = protowire.AppendTag(w.b, tag, protowire.BytesType)
+	w.b = protowire.AppendString(w.b, v)
+}
+
+func (w *recordWriter) strings(tag protowire.Number, vs []string) {
+	for _, v := range vs {
+		w.b = protowire.AppendTag(w.b, tag, protowire.BytesType)
+		w.b = protowire.AppendString(w.b, v)
+	}
+}
+
+// varint writes v, omitting zero unless always is set (repeated elements).
+func (w *recordWriter) varint(tag protowire.Number, v uint64, always bool) {
+	if v == 0 && !always {
+		return
+	}
+	w.b = protowire.AppendTag(w.b, tag, protowire.VarintType)
+	w.b = protowire.AppendVarint(w.b, v)
+}
+
+func (w *recordWriter) sint(tag protowire.Number, v int32) {
+	w.varint(tag, protowire.EncodeZigZag(int64(v)), false)
+}
+
+func (w *recordWriter) fixed64(tag protowire.Number, v uint64) {
+	w.b = protowire.AppendTag(w.b, tag, protowire.Fixed64Type)
+	w.b = protowire.AppendFixed64(w.b, v)
+}
+
+func (w *recordWriter) fixed32(tag protowire.Number, v uint32) {
+	w.b = protowire.AppendTag(w.b, tag, protowire.Fixed32Type)
+	w.b = protowire.AppendFixed32(w.b, v)
+}
+
+func (w *recordWriter) bool(tag protowire.Number, v bool) {
+	if v {
+		w.varint(tag, 1, false)
+	}
+}
+
+func (w *recordWriter) record(tag protowire.Number, fn func(w *recordWriter)) {
+	nested := &recordWriter{}
+	fn(nested)
+	w.b = protowire.AppendTag(w.b, tag, protowire.BytesType)
+	w.b = protowire.AppendBytes(w.b, nested.b)
+}
+
+// comments writes c as a nested record, omitted when empty.
+func (w *recordWriter) comments(tag protowire.Number, c Comments) {
+	if c.IsEmpty() {
+		return
+	}
+	w.record(tag, func(w *recordWriter) {
+		w.string(1, c.Leading)
+		w.string(2, c.Trailing)
+		w.strings(3, c.Detached)
+	})
+}
+
+// location writes l as a nested record, omitted when unknown.
+func (w *recordWriter) location(tag protowire.Number, l Location) {
+	if l == (Location{}) {
+		return
+	}
+	w.record(tag, func(w *recordWriter) {
+		w.string(1, l.File)
+		w.varint(2, uint64(l.Line), false)
+		w.varint(3, uint64(l.Column), false)
+	})
+}
+
+// value is one decoded property; uint is set for varints and fixed width
+// values, bytes for length delimited values.
+type value struct {
+	uint  uint64
+	bytes []byte
+}
+
+func (v value) string() string { return string(v.bytes) }
+func (v value) bool() bool     { return v.uint != 0 }
+func (v value) sint() int32    { return int32(protowire.DecodeZigZag(v.uint)) }
+
+// readRecord calls fn for every property of a record, skipping values of
+// wire types the format never writes.
+func readRecord(data []byte, fn func(tag protowire.Number, v value) error) error {
+	for len(data) > 0 {
+		tag, typ, n := protowire.ConsumeTag(data)
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		data = data[n:]
+
+		var v value
+		switch typ {
+		case protowire.VarintType:
+			v.uint, n = protowire.ConsumeVarint(data)
+		case protowire.Fixed64Type:
+			v.uint, n = protowire.ConsumeFixed64(data)
+		case protowire.Fixed32Type:
+			var x uint32
+			x, n = protowire.ConsumeFixed32(data)
+			v.uint = uint64(x)
+		case protowire.BytesType:
+			v.bytes, n = protowire.ConsumeBytes(data)
+		default:
+			n = protowire.ConsumeFieldValue(tag, typ, data)
+			if n < 0 {
+				return protowire.ParseError(n)
+			}
+			data = data[n:]
+			continue
+		}
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		data = data[n:]
+
+		if err := fn(tag, v); err != nil {
+			return err
+		}
+	}
+	return nil
+}
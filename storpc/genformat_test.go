@@ -0,0 +1,144 @@
+package storpc
+
+import (
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// genTestFiles are parsed in snake case
+var genTestFiles = map[string]string{
+	"users.proto": usersProto,
+	"shop.proto": `syntax = "proto2";
+package shop;
+
+import "google/protobuf/timestamp.proto";
+
+enum Status {
+  option allow_alias = true;
+  STATUS_UNKNOWN = 0;
+  STATUS_ACTIVE = 1;
+  STATUS_LIVE = 1;
+  STATUS_BACKORDER = -1;
+  reserved 5 to 7;
+  reserved "STATUS_GONE";
+}
+
+message Order {
+  required int64 id = 1;
+  repeated string tags = 2;
+  map<string, int32> counts = 3;
+  oneof payment {
+    string card = 4;
+    string voucher = 5;
+  }
+  optional Status status = 6;
+  optional google.protobuf.Timestamp placed = 7;
+}
+`,
+}
+
+func snakeCase(o *ProtoParserOptions) { o.WordCase = CaseSnake }
+
+func TestGenIRRoundTrip(t *testing.T) {
+	gen := parseSchema(t, genTestFiles, snakeCase)
+
+	data, err := MarshalGenIR(gen)
+	if err != nil {
+		t.Fatalf("MarshalGenIR failed: %v", err)
+	}
+	if string(data[:4]) != "SGIR" {
+		t.Fatalf("missing magic: %q", data[:4])
+	}
+
+	decoded, err := UnmarshalGenIR(data)
+	if err != nil {
+		t.Fatalf("UnmarshalGenIR failed: %v", err)
+	}
+
+	if !reflect.DeepEqual(decoded.Header, gen.Header) {
+		t.Errorf("header = %+v, want %+v", decoded.Header, gen.Header)
+	}
+	if !reflect.DeepEqual(decoded.Body, gen.Body) {
+		t.Errorf("body does not round trip:\n got %+v\nwant %+v", decoded.Body, gen.Body)
+	}
+}
+
+func TestGenBodyIndex(t *testing.T) {
+	data, err := MarshalGenIR(parseSchema(t, genTestFiles, snakeCase))
+	if err != nil {
+		t.Fatalf("MarshalGenIR failed: %v", err)
+	}
+	gen, err := UnmarshalGenIR(data)
+	if err != nil {
+		t.Fatalf("UnmarshalGenIR failed: %v", err)
+	}
+	body := gen.Body
+
+	// lookups only read the index built by the decoder
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := range body.Messages {
+				if body.Message(body.Messages[j].Name) != &body.Messages[j] {
+					t.Errorf("%v not found", body.Messages[j].Name)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	body.Messages = append(body.Messages, Message{Name: "extra.Added"})
+	body.Reindex()
+	if m := body.Message("extra.Added"); m == nil || m != &body.Messages[len(body.Messages)-1] {
+		t.Errorf("extra.Added not found after Reindex")
+	}
+}
+
+func TestGenIRSkipsUnknownProperties(t *testing.T) {
+	gen := parseSchema(t, genTestFiles, snakeCase)
+
+	data, err := MarshalGenIR(gen)
+	if err != nil {
+		t.Fatalf("MarshalGenIR failed: %v", err)
+	}
+
+	// a property from a newer minor version
+	data = protowire.AppendTag(data, 99, protowire.BytesType)
+	data = protowire.AppendString(data, "future")
+
+	decoded, err := UnmarshalGenIR(data)
+	if err != nil {
+		t.Fatalf("UnmarshalGenIR failed: %v", err)
+	}
+	if len(decoded.Body.Messages) != len(gen.Body.Messages) {
+		t.Errorf("messages = %d, want %d", len(decoded.Body.Messages), len(gen.Body.Messages))
+	}
+}
+
+func TestGenIRRejectsBadInput(t *testing.T) {
+	gen := parseSchema(t, genTestFiles, snakeCase)
+	data, err := MarshalGenIR(gen)
+	if err != nil {
+		t.Fatalf("MarshalGenIR failed: %v", err)
+	}
+
+	if _, err := UnmarshalGenIR([]byte("nope")); err != ErrNotGenIR {
+		t.Errorf("expected ErrNotGenIR, got %v", err)
+	}
+
+	future := append([]byte{}, data...)
+	future[4] = STORPC_VERSION_MAJOR + 1
+	if _, err := UnmarshalGenIR(future); err == nil || !strings.Contains(err.Error(), "not supported") {
+		t.Errorf("expected version error, got %v", err)
+	}
+
+	if _, err := UnmarshalGenIR(data[:len(data)-3]); err == nil {
+		t.Errorf("expected error for truncated body")
+	}
+}
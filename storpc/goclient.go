@@ -0,0 +1,519 @@
+package storpc
+
+import (
+	"fmt"
+	"go/format"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// GoPackage names the package of a generated Go client.
+const GoPackage ParseArgs = "--go_package"
+
+const clientRuntime = "github.com/nam2184/storpc/client"
+
+type goWellKnown struct {
+	path string // import path
+	name string // qualified Go type
+}
+
+// protobuf-go types of the well-known messages, used where a method takes
+// or returns one
+var goWellKnownTypes = map[string]goWellKnown{
+	"google.protobuf.Timestamp":   {"google.golang.org/protobuf/types/known/timestamppb", "timestamppb.Timestamp"},
+	"google.protobuf.Duration":    {"google.golang.org/protobuf/types/known/durationpb", "durationpb.Duration"},
+	"google.protobuf.Struct":      {"google.golang.org/protobuf/types/known/structpb", "structpb.Struct"},
+	"google.protobuf.Value":       {"google.golang.org/protobuf/types/known/structpb", "structpb.Value"},
+	"google.protobuf.ListValue":   {"google.golang.org/protobuf/types/known/structpb", "structpb.ListValue"},
+	"google.protobuf.Any":         {"google.golang.org/protobuf/types/known/anypb", "anypb.Any"},
+	"google.protobuf.Empty":       {"google.golang.org/protobuf/types/known/emptypb", "emptypb.Empty"},
+	"google.protobuf.FieldMask":   {"google.golang.org/protobuf/types/known/fieldmaskpb", "fieldmaskpb.FieldMask"},
+	"google.protobuf.DoubleValue": {"google.golang.org/protobuf/types/known/wrapperspb", "wrapperspb.DoubleValue"},
+	"google.protobuf.FloatValue":  {"google.golang.org/protobuf/types/known/wrapperspb", "wrapperspb.FloatValue"},
+	"google.protobuf.Int64Value":  {"google.golang.org/protobuf/types/known/wrapperspb", "wrapperspb.Int64Value"},
+	"google.protobuf.UInt64Value": {"google.golang.org/protobuf/types/known/wrapperspb", "wrapperspb.UInt64Value"},
+	"google.protobuf.Int32Value":  {"google.golang.org/protobuf/types/known/wrapperspb", "wrapperspb.Int32Value"},
+	"google.protobuf.UInt32Value": {"google.golang.org/protobuf/types/known/wrapperspb", "wrapperspb.UInt32Value"},
+	"google.protobuf.BoolValue":   {"google.golang.org/protobuf/types/known/wrapperspb", "wrapperspb.BoolValue"},
+	"google.protobuf.StringValue": {"google.golang.org/protobuf/types/known/wrapperspb", "wrapperspb.StringValue"},
+	"google.protobuf.BytesValue":  {"google.golang.org/protobuf/types/known/wrapperspb", "wrapperspb.BytesValue"},
+}
+
+var goScalars = map[string]string{
+	"double":   "float64",
+	"float":    "float32",
+	"int32":    "int32",
+	"sint32":   "int32",
+	"sfixed32": "int32",
+	"int64":    "int64",
+	"sint64":   "int64",
+	"sfixed64": "int64",
+	"uint32":   "uint32",
+	"fixed32":  "uint32",
+	"uint64":   "uint64",
+	"fixed64":  "uint64",
+	"bool":     "bool",
+	"string":   "string",
+	"bytes":    "[]byte",
+}
+
+type goClient struct {
+	body    *GenBody
+	names   map[string]string // Go names of messages and enums by full name
+	imports map[string]bool
+	b       strings.Builder
+}
+
+// GenerateGoClient writes a typed Go client for the services of gen: a
+// struct per message they use with generated wire encoding, a client per
+// service and, for every stored message, a repository with its key type.
+// The code depends on grpc, protobuf-go and the storpc client runtime.
+func GenerateGoClient(gen *GenIR, pkg string) ([]byte, error) {
+	if pkg == "" {
+		return nil, fmt.Errorf("no Go package name given")
+	}
+
+	g := &goClient{body: gen.Body, imports: make(map[string]bool)}
+	g.nameTypes()
+	messages, enums := g.reachable()
+
+	for _, e := range enums {
+		g.writeEnum(e)
+	}
+	for _, m := range messages {
+		if err := g.writeMessage(m); err != nil {
+			return nil, err
+		}
+	}
+	for i := range gen.Body.Services {
+		g.writeService(&gen.Body.Services[i])
+	}
+	for _, m := range messages {
+		if m.Table != "" {
+			if err := g.writeRepository(m); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	var out strings.Builder
+	fmt.Fprintf(&out, "// Code generated by storpc. DO NOT EDIT.\n\npackage %s\n\n", pkg)
+	if len(g.imports) > 0 {
+		// standard library first, as goimports groups them
+		paths := make([]string, 0, len(g.imports))
+		for path := range g.imports {
+			paths = append(paths, path)
+		}
+		sort.Slice(paths, func(i, j int) bool {
+			si, sj := !strings.Contains(paths[i], "."), !strings.Contains(paths[j], ".")
+			if si != sj {
+				return si
+			}
+			return paths[i] < paths[j]
+		})
+		out.WriteString("import (\n")
+		for i, path := range paths {
+			if i > 0 && strings.Contains(path, ".") && !strings.Contains(paths[i-1], ".") {
+				out.WriteString("\n")
+			}
+			fmt.Fprintf(&out, "\t%q\n", path)
+		}
+		out.WriteString(")\n\n")
+	}
+	out.WriteString(g.b.String())
+
+	src, err := format.Source([]byte(out.String()))
+	if err != nil {
+		return nil, fmt.Errorf("generated code is not valid Go syntax: %w", err)
+	}
+	return src, nil
+}
+
+// reachable collects the messages and enums the services use, in the order
+// of the type table. Well-known types and map entries have no struct of
+// their own.
+func (g *goClient) reachable() ([]*Message, []*Enum) {
+	seen := make(map[string]bool)
+
+	var visit func(name string)
+	visit = func(name string) {
+		if seen[name] || strings.HasPrefix(name, "google.protobuf.") {
+			return
+		}
+		seen[name] = true
+
+		m := g.body.Message(name)
+		if m == nil {
+			return
+		}
+		for i := range m.Fields {
+			f := &m.Fields[i]
+			if f.IsMap() {
+				f = f.MapValue
+			}
+			if f.Kind != KindScalar {
+				visit(f.Type)
+			}
+		}
+	}
+
+	for _, svc := range g.body.Services {
+		for _, method := range svc.Methods {
+			visit(method.Input)
+			visit(method.Output)
+		}
+	}
+
+	var messages []*Message
+	for i := range g.body.Messages {
+		m := &g.body.Messages[i]
+		if seen[m.Name] && !m.MapEntry {
+			messages = append(messages, m)
+		}
+	}
+	var enums []*Enum
+	for i := range g.body.Enums {
+		if seen[g.body.Enums[i].Name] {
+			enums = append(enums, &g.body.Enums[i])
+		}
+	}
+	return messages, enums
+}
+
+// nameTypes names the messages and enums in the generated package, nested
+// names joined by '_' as protoc-gen-go does. Types of the root package drop
+// it, those of other packages keep theirs, and a name taken twice gets a
+// trailing '_'.
+func (g *goClient) nameTypes() {
+	var full []string
+	for i := range g.body.Messages {
+		if !g.body.Messages[i].MapEntry {
+			full = append(full, g.body.Messages[i].Name)
+		}
+	}
+	for i := range g.body.Enums {
+		full = append(full, g.body.Enums[i].Name)
+	}
+	root := g.body.Group + "."
+	// root types first, so they keep the short names
+	sort.SliceStable(full, func(i, j int) bool {
+		return strings.HasPrefix(full[i], root) && !strings.HasPrefix(full[j], root)
+	})
+
+	g.names = make(map[string]string, len(full))
+	taken := make(map[string]bool, len(full))
+	for _, name := range full {
+		if strings.HasPrefix(name, "google.protobuf.") {
+			continue
+		}
+		parts := strings.Split(strings.TrimPrefix(name, root), ".")
+		for i, part := range parts {
+			parts[i] = title(part)
+		}
+		goName := strings.Join(parts, "_")
+		for taken[goName] {
+			goName += "_"
+		}
+		taken[goName] = true
+		g.names[name] = goName
+	}
+}
+
+func (g *goClient) goTypeName(fullName string) string {
+	return g.names[fullName]
+}
+
+func goFieldName(name string) string {
+	goName, _ := ToCase(name, CasePascal)
+	if goName == "" || !unicode.IsLetter([]rune(goName)[0]) {
+		goName = "X" + goName
+	}
+	return goName
+}
+
+// rpcType is the Go type of a method's request or response message.
+func (g *goClient) rpcType(name string) string {
+	if wk, ok := goWellKnownTypes[name]; ok {
+		g.imports[wk.path] = true
+		return wk.name
+	}
+	return g.goTypeName(name)
+}
+
+// elemType is the Go type of a single value of f.
+func (g *goClient) elemType(f *Field) (string, error) {
+	switch f.Kind {
+	case KindScalar:
+		return goScalars[f.Type], nil
+	case KindEnum:
+		return g.goTypeName(f.Type), nil
+	}
+
+	switch f.Logical {
+	case LogicalTime:
+		g.imports["time"] = true
+		return "time.Time", nil
+	case LogicalDuration:
+		g.imports["time"] = true
+		return "time.Duration", nil
+	case LogicalNullable:
+		return "*" + goScalars[f.ScalarType()], nil
+	}
+
+	if wk, ok := goWellKnownTypes[f.Type]; ok {
+		g.imports[wk.path] = true
+		return "*" + wk.name, nil
+	}
+	if strings.HasPrefix(f.Type, "google.protobuf.") {
+		return "", fmt.Errorf("%s has no Go client mapping", f.Type)
+	}
+	return "*" + g.goTypeName(f.Type), nil
+}
+
+// fieldType is the Go type of the struct field for f.
+func (g *goClient) fieldType(f *Field) (string, error) {
+	if f.IsMap() {
+		key, err := g.elemType(f.MapKey)
+		if err != nil {
+			return "", err
+		}
+		value, err := g.elemType(f.MapValue)
+		if err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("map[%s]%s", key, value), nil
+	}
+
+	typ, err := g.elemType(f)
+	if err != nil {
+		return "", err
+	}
+	switch {
+	case f.IsRepeated():
+		return "[]" + typ, nil
+	case pointerScalar(f):
+		return "*" + typ, nil
+	}
+	return typ, nil
+}
+
+// pointerScalar reports whether f is a scalar or enum held by pointer, so
+// that unset can be told from zero.
+func pointerScalar(f *Field) bool {
+	return f.Presence == PresenceExplicit && f.Kind != KindMessage && !f.IsRepeated()
+}
+
+func (g *goClient) writeEnum(e *Enum) {
+	name := g.goTypeName(e.Name)
+	fmt.Fprintf(&g.b, "// %s is the %s enum.\ntype %s int32\n\nconst (\n", name, e.Name, name)
+	for _, v := range e.Values {
+		fmt.Fprintf(&g.b, "\t%s_%s %s = %d\n", name, v.Name, name, v.Value)
+	}
+	g.b.WriteString(")\n\n")
+}
+
+func (g *goClient) writeMessage(m *Message) error {
+	name := g.goTypeName(m.Name)
+	fmt.Fprintf(&g.b, "// %s is the %s message.\ntype %s struct {\n", name, m.Name, name)
+	for i := range m.Fields {
+		f := &m.Fields[i]
+		typ, err := g.fieldType(f)
+		if err != nil {
+			return fmt.Errorf("%s.%s: %w", m.Name, f.Name, err)
+		}
+		fmt.Fprintf(&g.b, "\t%s %s\n", goFieldName(f.Name), typ)
+	}
+	g.b.WriteString("}\n\n")
+
+	g.writeAppendWire(m, name)
+	return g.writeConsumeWire(m, name)
+}
+
+// writeCall writes the body of a method invoking a unary RPC with in.
+// Calls answering google.protobuf.Empty return only an error.
+func (g *goClient) writeCall(receiver, path, output string) {
+	if output == "google.protobuf.Empty" {
+		fmt.Fprintf(&g.b, "\treturn %s.cc.Invoke(ctx, %q, in, new(%s), %s.opts...)\n}\n\n", receiver, path, g.rpcType(output), receiver)
+		return
+	}
+	fmt.Fprintf(&g.b, "\tout := new(%s)\n", g.rpcType(output))
+	fmt.Fprintf(&g.b, "\tif err := %s.cc.Invoke(ctx, %q, in, out, %s.opts...); err != nil {\n\t\treturn nil, err\n\t}\n", receiver, path, receiver)
+	g.b.WriteString("\treturn out, nil\n}\n\n")
+}
+
+func (g *goClient) results(output string) string {
+	if output == "google.protobuf.Empty" {
+		return "error"
+	}
+	return fmt.Sprintf("(*%s, error)", g.rpcType(output))
+}
+
+func (g *goClient) writeConstructor(name, doc string) {
+	g.imports["context"] = true
+	g.imports["google.golang.org/grpc"] = true
+	g.imports[clientRuntime] = true
+
+	fmt.Fprintf(&g.b, "// %s %s\ntype %s struct {\n\tcc   grpc.ClientConnInterface\n\topts []grpc.CallOption\n}\n\n", name, doc, name)
+	fmt.Fprintf(&g.b, "func New%s(cc grpc.ClientConnInterface, opts ...grpc.CallOption) *%s {\n", name, name)
+	fmt.Fprintf(&g.b, "\treturn &%s{cc: cc, opts: append(opts, grpc.ForceCodec(client.Codec{}))}\n}\n\n", name)
+}
+
+func (g *goClient) writeService(svc *Service) {
+	short := svc.Name[strings.LastIndex(svc.Name, ".")+1:]
+	name := title(short) + "Client"
+	g.writeConstructor(name, "calls every method of "+svc.Name+".")
+
+	for _, method := range svc.Methods {
+		fmt.Fprintf(&g.b, "func (c *%s) %s(ctx context.Context, in *%s) (*%s, error) {\n",
+			name, title(method.Name), g.rpcType(method.Input), g.rpcType(method.Output))
+		fmt.Fprintf(&g.b, "\tout := new(%s)\n", g.rpcType(method.Output))
+		fmt.Fprintf(&g.b, "\tif err := c.cc.Invoke(ctx, %q, in, out, c.opts...); err != nil {\n\t\treturn nil, err\n\t}\n", "/"+svc.Name+"/"+method.Name)
+		g.b.WriteString("\treturn out, nil\n}\n\n")
+	}
+}
+
+// keyInput reports whether every key field of table has a field of the
+// same name and type in input, so a key alone can fill the request.
+func (g *goClient) keyInput(table *Message, input string) bool {
+	in := g.body.Message(input)
+	keys := table.KeyFields()
+	if in == nil || len(keys) == 0 {
+		return false
+	}
+	for _, key := range keys {
+		found := false
+		for _, f := range in.Fields {
+			if f.Name == key.Name && f.Type == key.Type && !f.IsRepeated() {
+				found = true
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+func (g *goClient) writeRepository(m *Message) error {
+	// the first method of each operation on the table
+	paths := make(map[uint8]string)
+	var calls [OpList + 1]*Method
+	for _, svc := range g.body.Services {
+		for i := range svc.Methods {
+			method := &svc.Methods[i]
+			if method.Table != m.Name || method.Operation > OpList || calls[method.Operation] != nil {
+				continue
+			}
+			calls[method.Operation] = method
+			paths[method.Operation] = "/" + svc.Name + "/" + method.Name
+		}
+	}
+
+	typeName := g.goTypeName(m.Name)
+	name := goFieldName(m.Table) + "Repository"
+	keyName := typeName + "Key"
+	keys := m.KeyFields()
+
+	if len(keys) > 0 {
+		fmt.Fprintf(&g.b, "// %s identifies a row of the %s table.\ntype %s struct {\n", keyName, m.Table, keyName)
+		for i := range keys {
+			typ, err := g.elemType(&keys[i])
+			if err != nil {
+				return err
+			}
+			fmt.Fprintf(&g.b, "\t%s %s\n", goFieldName(keys[i].Name), typ)
+		}
+		g.b.WriteString("}\n\n")
+
+		fmt.Fprintf(&g.b, "// %sOf returns the key of a row.\nfunc %sOf(m *%s) %s {\n\tvar key %s\n", keyName, keyName, typeName, keyName, keyName)
+		for i := range keys {
+			g.assignKey("key", "m", &keys[i], false)
+		}
+		g.b.WriteString("\treturn key\n}\n\n")
+	}
+
+	g.writeConstructor(name, fmt.Sprintf("reads and writes %s rows of the %s table.", m.Name, m.Table))
+
+	for op, call := range calls {
+		if call == nil {
+			continue
+		}
+		op := uint8(op)
+		verb := title(OpString(op))
+		path := paths[op]
+		input := g.rpcType(call.Input)
+
+		switch {
+		case op == OpList:
+			if call.Input == "google.protobuf.Empty" {
+				fmt.Fprintf(&g.b, "func (r *%s) List(ctx context.Context) %s {\n\tin := new(%s)\n", name, g.results(call.Output), input)
+				break
+			}
+			option := goFieldName(m.Table) + "List"
+			if err := g.writeListOptions(option, call.Input); err != nil {
+				return err
+			}
+			fmt.Fprintf(&g.b, "func (r *%s) List(ctx context.Context, opts ...%sOption) %s {\n", name, option, g.results(call.Output))
+			fmt.Fprintf(&g.b, "\tin := new(%s)\n\tfor _, opt := range opts {\n\t\topt(in)\n\t}\n", input)
+		case (op == OpGet || op == OpDelete) && g.keyInput(m, call.Input):
+			fmt.Fprintf(&g.b, "func (r *%s) %s(ctx context.Context, key %s) %s {\n\tin := new(%s)\n", name, verb, keyName, g.results(call.Output), input)
+			in := g.body.Message(call.Input)
+			for _, key := range keys {
+				for i := range in.Fields {
+					if in.Fields[i].Name == key.Name {
+						g.assignKey("in", "key", &in.Fields[i], true)
+					}
+				}
+			}
+		default:
+			fmt.Fprintf(&g.b, "func (r *%s) %s(ctx context.Context, in *%s) %s {\n", name, verb, input, g.results(call.Output))
+		}
+		g.writeCall("r", path, call.Output)
+	}
+	return nil
+}
+
+// assignKey copies a key field between a message and a key struct. Fields
+// with explicit presence are pointers in messages.
+func (g *goClient) assignKey(to, from string, f *Field, toMessage bool) {
+	name := goFieldName(f.Name)
+	pointer := pointerScalar(f)
+	switch {
+	case pointer && toMessage:
+		fmt.Fprintf(&g.b, "\t%s.%s = &%s.%s\n", to, name, from, name)
+	case pointer:
+		fmt.Fprintf(&g.b, "\tif %s.%s != nil {\n\t\t%s.%s = *%s.%s\n\t}\n", from, name, to, name, from, name)
+	default:
+		fmt.Fprintf(&g.b, "\t%s.%s = %s.%s\n", to, name, from, name)
+	}
+}
+
+// writeListOptions writes the <prefix>Option type setting the fields of a
+// List request, and a <prefix>With builder per field.
+func (g *goClient) writeListOptions(prefix, input string) error {
+	in := g.body.Message(input)
+	inputType := g.rpcType(input)
+	option := prefix + "Option"
+	fmt.Fprintf(&g.b, "// %s sets a field of the %s request.\ntype %s func(*%s)\n\n", option, input, option, inputType)
+	if in == nil {
+		return nil
+	}
+
+	for i := range in.Fields {
+		f := &in.Fields[i]
+		typ, err := g.fieldType(f)
+		if err != nil {
+			return err
+		}
+		field := goFieldName(f.Name)
+		if strings.HasPrefix(typ, "*") && f.Kind != KindMessage {
+			fmt.Fprintf(&g.b, "func %sWith%s(v %s) %s {\n\treturn func(in *%s) { in.%s = &v }\n}\n\n",
+				prefix, field, typ[1:], option, inputType, field)
+			continue
+		}
+		fmt.Fprintf(&g.b, "func %sWith%s(v %s) %s {\n\treturn func(in *%s) { in.%s = v }\n}\n\n",
+			prefix, field, typ, option, inputType, field)
+	}
+	return nil
+}
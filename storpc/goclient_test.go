@@ -0,0 +1,403 @@
+package storpc
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/descriptorpb"
+)
+
+const goClientSchema = `syntax = "proto3";
+package shop;
+
+import "storpc/options.proto";
+import "google/protobuf/duration.proto";
+import "google/protobuf/empty.proto";
+import "google/protobuf/struct.proto";
+import "google/protobuf/timestamp.proto";
+import "google/protobuf/wrappers.proto";
+
+enum Status {
+  STATUS_UNKNOWN = 0;
+  STATUS_OPEN = 1;
+}
+
+message Order {
+  option (storpc.table) = "orders";
+  int64 id = 1 [(storpc.key) = true];
+  repeated Line lines = 2;
+  map<string, int32> counts = 3;
+  Status status = 4;
+  google.protobuf.Timestamp placed = 5;
+  google.protobuf.Int32Value retries = 6;
+  optional string note = 7;
+  repeated sint64 codes = 8;
+  repeated float scores = 9 [packed = false];
+  bytes blob = 10;
+  google.protobuf.Duration took = 11;
+  optional fixed32 zero = 12;
+  map<string, Line> parts = 13;
+  google.protobuf.Struct attributes = 14;
+  repeated Status history = 15;
+  double ratio = 16;
+  sfixed64 offset = 17;
+  bool flag = 18;
+
+  message Line {
+    string sku = 1;
+  }
+}
+
+message OrderID {
+  int64 id = 1;
+}
+
+message ListOrdersRequest {
+  int32 page_size = 1;
+  optional string customer = 2;
+}
+
+message Orders {
+  repeated Order orders = 1;
+}
+
+message Unused {
+  string name = 1;
+}
+
+service OrderService {
+  rpc CreateOrder(Order) returns (google.protobuf.Empty);
+  rpc GetOrder(OrderID) returns (Order);
+  rpc ListOrders(ListOrdersRequest) returns (Orders) {
+    option (storpc.op) = OP_LIST;
+  }
+}
+`
+
+// legacyGoClientSchema covers what only proto2 has: groups, required
+// fields and declared defaults.
+const legacyGoClientSchema = `syntax = "proto2";
+package shop;
+
+message Legacy {
+  optional group Extra = 1 {
+    optional bytes blob = 1;
+  }
+  required int32 count = 2;
+  repeated int32 ids = 3 [packed = true];
+  optional sint32 delta = 4 [default = -1];
+}
+
+service LegacyService {
+  rpc Echo(Legacy) returns (Legacy);
+}
+`
+
+func TestGenerateGoClient(t *testing.T) {
+	gen := parseSchema(t, map[string]string{"schema.proto": goClientSchema})
+
+	src, err := GenerateGoClient(gen, "shopclient")
+	if err != nil {
+		t.Fatalf("GenerateGoClient failed: %v", err)
+	}
+	// gofmt aligns struct fields and constants
+	code := strings.Join(strings.Fields(string(src)), " ")
+
+	for _, want := range []string{
+		"package shopclient",
+		"Status_STATUS_OPEN Status = 1",
+		"type Order_Line struct",
+		"Lines []*Order_Line",
+		"Counts map[string]int32",
+		"Placed time.Time",
+		"Retries *int32",
+		"Note *string",
+		"func (m *Order) AppendWire(b []byte) ([]byte, error)",
+		"func (m *Order) ConsumeWire(b []byte) error",
+		"func (c *OrderServiceClient) GetOrder(ctx context.Context, in *OrderID) (*Order, error)",
+		"type OrderKey struct",
+		"func (r *OrdersRepository) Insert(ctx context.Context, in *Order) error",
+		"func (r *OrdersRepository) Get(ctx context.Context, key OrderKey) (*Order, error)",
+		"func OrdersListWithCustomer(v string) OrdersListOption",
+		"func (r *OrdersRepository) List(ctx context.Context, opts ...OrdersListOption) (*Orders, error)",
+		"grpc.ForceCodec(client.Codec{})",
+	} {
+		if !strings.Contains(code, want) {
+			t.Errorf("generated code lacks %q", want)
+		}
+	}
+
+	if strings.Contains(code, "Unused") {
+		t.Errorf("messages no service uses should not be generated")
+	}
+
+	if _, err := GenerateGoClient(gen, ""); err == nil {
+		t.Errorf("expected error without a package name")
+	}
+}
+
+func TestGoClientTypeNames(t *testing.T) {
+	gen := parseSchema(t, map[string]string{
+		"a/shop.proto": `syntax = "proto3";
+package shop;
+
+import "b/common.proto";
+
+message User {
+  string name = 1;
+}
+
+message Common {
+  message User {
+    string name = 1;
+  }
+}
+
+message Pair {
+  User user = 1;
+  common.User other = 2;
+  Common.User nested = 3;
+}
+
+service Users {
+  rpc Swap(Pair) returns (Pair);
+}
+`,
+		"b/common.proto": `syntax = "proto3";
+package common;
+
+message User {
+  int64 id = 1;
+}
+`,
+	})
+
+	src, err := GenerateGoClient(gen, "shopclient")
+	if err != nil {
+		t.Fatalf("GenerateGoClient failed: %v", err)
+	}
+	code := strings.Join(strings.Fields(string(src)), " ")
+	for _, want := range []string{
+		"type User struct { Name string }",
+		"type Common_User struct { Name string }",
+		"type Common_User_ struct { Id int64 }",
+		"User *User Other *Common_User_ Nested *Common_User",
+	} {
+		if !strings.Contains(code, want) {
+			t.Errorf("generated code lacks %q", want)
+		}
+	}
+}
+
+// roundTripTest runs inside the generated package: a message encoded by
+// the generated code must read the same with protobuf, and back.
+const roundTripTest = `package shopclient
+
+import (
+	"encoding/json"
+	"os"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/nam2184/storpc/client"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/encoding/protowire"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/dynamicpb"
+	"google.golang.org/protobuf/types/known/structpb"
+)
+
+func schema(t *testing.T) *protoregistry.Files {
+	data, err := os.ReadFile("schema.pb")
+	if err != nil {
+		t.Fatal(err)
+	}
+	set := &descriptorpb.FileDescriptorSet{}
+	if err := proto.Unmarshal(data, set); err != nil {
+		t.Fatal(err)
+	}
+	files, err := protodesc.NewFiles(set)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return files
+}
+
+// roundTrip encodes in, checks what protobuf reads from it against want,
+// and returns protobuf's encoding of that decoded into a message of empty.
+func roundTrip(t *testing.T, name string, in client.Message, empty func() client.Message, want string) client.Message {
+	t.Helper()
+
+	desc, err := schema(t).FindDescriptorByName(protoreflect.FullName(name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := client.Codec{}.Marshal(in)
+	if err != nil {
+		t.Fatalf("Codec.Marshal failed: %v", err)
+	}
+
+	msg := dynamicpb.NewMessage(desc.(protoreflect.MessageDescriptor))
+	if err := proto.Unmarshal(data, msg); err != nil {
+		t.Fatalf("protobuf cannot read the encoding: %v", err)
+	}
+	text, err := protojson.Marshal(msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got, expected any
+	json.Unmarshal(text, &got)
+	json.Unmarshal([]byte(want), &expected)
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("protobuf read %s", text)
+	}
+
+	data, err = proto.Marshal(msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := (client.Codec{}).Unmarshal(data[:len(data)-1], empty()); err == nil {
+		t.Errorf("truncated %s decoded", name)
+	}
+	out := empty()
+	if err := (client.Codec{}).Unmarshal(data, out); err != nil {
+		t.Fatalf("Codec.Unmarshal failed: %v", err)
+	}
+	return out
+}
+
+func TestRoundTrip(t *testing.T) {
+	retries, note, zero := int32(0), "gift", uint32(0)
+	attributes, _ := structpb.NewStruct(map[string]any{"source": "web"})
+	in := &Order{
+		Id:         42,
+		Lines:      []*Order_Line{{Sku: "x"}},
+		Counts:     map[string]int32{"a": 2},
+		Status:     Status_STATUS_OPEN,
+		Placed:     time.Date(2024, 3, 1, 12, 0, 0, 5, time.UTC),
+		Retries:    &retries,
+		Note:       &note,
+		Codes:      []int64{1, -2, 300},
+		Scores:     []float32{0.5, -1},
+		Blob:       []byte{0, 1},
+		Took:       -1500 * time.Millisecond,
+		Zero:       &zero,
+		Parts:      map[string]*Order_Line{"p": {Sku: "y"}},
+		Attributes: attributes,
+		History:    []Status{Status_STATUS_OPEN, Status_STATUS_UNKNOWN},
+		Ratio:      0.25,
+		Offset:     -3,
+		Flag:       true,
+	}
+	out := roundTrip(t, "shop.Order", in, func() client.Message { return &Order{} }, ` + "`" + `{"id": "42", "lines": [{"sku": "x"}], "counts": {"a": 2}, "status": "STATUS_OPEN",
+		"placed": "2024-03-01T12:00:00.000000005Z", "retries": 0, "note": "gift", "codes": ["1", "-2", "300"],
+		"scores": [0.5, -1], "blob": "AAE=", "took": "-1.500s", "zero": 0, "parts": {"p": {"sku": "y"}},
+		"attributes": {"source": "web"}, "history": ["STATUS_OPEN", "STATUS_UNKNOWN"], "ratio": 0.25,
+		"offset": "-3", "flag": true}` + "`" + `).(*Order)
+
+	if !proto.Equal(in.Attributes, out.Attributes) {
+		t.Errorf("attributes = %v", out.Attributes)
+	}
+	in.Attributes, out.Attributes = nil, nil
+	if !reflect.DeepEqual(out, in) {
+		t.Errorf("round trip = %+v, want %+v", out, in)
+	}
+}
+
+func TestRoundTripLegacy(t *testing.T) {
+	delta, blob := int32(-5), []byte("b")
+	in := &Legacy{
+		Extra: &Legacy_Extra{Blob: &blob},
+		Ids:   []int32{1, 2},
+		Delta: &delta,
+	}
+	out := roundTrip(t, "shop.Legacy", in, func() client.Message { return &Legacy{} },
+		` + "`" + `{"extra": {"blob": "Yg=="}, "count": 0, "ids": [1, 2], "delta": -5}` + "`" + `)
+	if !reflect.DeepEqual(out, in) {
+		t.Errorf("round trip = %+v, want %+v", out, in)
+	}
+}
+
+func TestSkipsChangedWireType(t *testing.T) {
+	// a newer schema turned note into an int and codes into a fixed64
+	var b []byte
+	b = protowire.AppendTag(b, 1, protowire.VarintType)
+	b = protowire.AppendVarint(b, 7)
+	b = protowire.AppendTag(b, 7, protowire.VarintType)
+	b = protowire.AppendVarint(b, 99)
+	b = protowire.AppendTag(b, 8, protowire.Fixed64Type)
+	b = protowire.AppendFixed64(b, 1)
+	b = protowire.AppendTag(b, 3, protowire.BytesType)
+	b = protowire.AppendBytes(b, protowire.AppendVarint(protowire.AppendTag(nil, 1, protowire.VarintType), 3))
+
+	out := &Order{}
+	if err := (client.Codec{}).Unmarshal(b, out); err != nil {
+		t.Fatalf("Codec.Unmarshal failed: %v", err)
+	}
+	if out.Id != 7 || out.Note != nil || out.Codes != nil {
+		t.Errorf("decoded %+v", out)
+	}
+	if len(out.Counts) != 1 || out.Counts[""] != 0 {
+		t.Errorf("map entry with a changed key type = %v, want the key skipped", out.Counts)
+	}
+}
+`
+
+func TestGeneratedGoClientRoundTrip(t *testing.T) {
+	if testing.Short() {
+		t.Skip("builds the generated package")
+	}
+	gobin, err := exec.LookPath("go")
+	if err != nil {
+		t.Skip("go command not found")
+	}
+
+	gen, parser := parseSchemaParser(t, map[string]string{"schema.proto": goClientSchema, "legacy.proto": legacyGoClientSchema})
+	src, err := GenerateGoClient(gen, "shopclient")
+	if err != nil {
+		t.Fatalf("GenerateGoClient failed: %v", err)
+	}
+
+	set := &descriptorpb.FileDescriptorSet{}
+	parser.Files().RangeFiles(func(fd protoreflect.FileDescriptor) bool {
+		set.File = append(set.File, protodesc.ToFileDescriptorProto(fd))
+		return true
+	})
+	schema, err := proto.Marshal(set)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// inside the module, so the package builds against this client runtime
+	pkg, err := os.MkdirTemp("testdata", "goclient")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(pkg) })
+	for name, data := range map[string][]byte{
+		"client.go":      src,
+		"client_test.go": []byte(roundTripTest),
+		"schema.pb":      schema,
+	} {
+		if err := os.WriteFile(filepath.Join(pkg, name), data, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	cmd := exec.Command(gobin, "test", "-count=1", ".")
+	cmd.Dir = pkg
+	if out, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("generated package fails:\n%s", out)
+	}
+}
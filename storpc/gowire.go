@@ -0,0 +1,304 @@
+package storpc
+
+import (
+	"fmt"
+	"strings"
+)
+
+const protowirePackage = "google.golang.org/protobuf/encoding/protowire"
+
+// scalarWire is how generated code writes and reads a scalar kind. In the
+// expressions $v is the value and $T its Go type.
+type scalarWire struct {
+	wire    string // protowire type
+	append  string // protowire function appending a value
+	consume string // protowire function consuming a value into v
+	encode  string // the value as append takes it
+	decode  string // the Go value of a consumed v
+	set     string // whether an implicit presence value is written
+}
+
+var scalarWires = map[string]scalarWire{
+	"bool":     {"VarintType", "AppendVarint", "ConsumeVarint", "protowire.EncodeBool($v)", "protowire.DecodeBool(v)", "$v"},
+	"int32":    {"VarintType", "AppendVarint", "ConsumeVarint", "uint64($v)", "$T(v)", "$v != 0"},
+	"int64":    {"VarintType", "AppendVarint", "ConsumeVarint", "uint64($v)", "$T(v)", "$v != 0"},
+	"uint32":   {"VarintType", "AppendVarint", "ConsumeVarint", "uint64($v)", "$T(v)", "$v != 0"},
+	"uint64":   {"VarintType", "AppendVarint", "ConsumeVarint", "$v", "v", "$v != 0"},
+	"sint32":   {"VarintType", "AppendVarint", "ConsumeVarint", "protowire.EncodeZigZag(int64($v))", "int32(protowire.DecodeZigZag(uint64(uint32(v))))", "$v != 0"},
+	"sint64":   {"VarintType", "AppendVarint", "ConsumeVarint", "protowire.EncodeZigZag($v)", "protowire.DecodeZigZag(v)", "$v != 0"},
+	"enum":     {"VarintType", "AppendVarint", "ConsumeVarint", "uint64($v)", "$T(v)", "$v != 0"},
+	"fixed32":  {"Fixed32Type", "AppendFixed32", "ConsumeFixed32", "$v", "v", "$v != 0"},
+	"sfixed32": {"Fixed32Type", "AppendFixed32", "ConsumeFixed32", "uint32($v)", "int32(v)", "$v != 0"},
+	"float":    {"Fixed32Type", "AppendFixed32", "ConsumeFixed32", "math.Float32bits($v)", "math.Float32frombits(v)", "$v != 0"},
+	"fixed64":  {"Fixed64Type", "AppendFixed64", "ConsumeFixed64", "$v", "v", "$v != 0"},
+	"sfixed64": {"Fixed64Type", "AppendFixed64", "ConsumeFixed64", "uint64($v)", "int64(v)", "$v != 0"},
+	"double":   {"Fixed64Type", "AppendFixed64", "ConsumeFixed64", "math.Float64bits($v)", "math.Float64frombits(v)", "$v != 0"},
+	"string":   {"BytesType", "AppendString", "ConsumeString", "$v", "v", `$v != ""`},
+	"bytes":    {"BytesType", "AppendBytes", "ConsumeBytes", "$v", "append([]byte{}, v...)", "len($v) > 0"},
+}
+
+// wireKind is how a value of f is encoded: a scalar kind, "enum", a
+// well-known type mapped to a Go type ("time", "duration", "wrapper"),
+// another protobuf-go message ("proto"), or a generated message ("message",
+// "group").
+func wireKind(f *Field) string {
+	switch {
+	case f.Kind == KindScalar:
+		return f.Type
+	case f.Kind == KindEnum:
+		return "enum"
+	case f.Logical == LogicalTime:
+		return "time"
+	case f.Logical == LogicalDuration:
+		return "duration"
+	case f.Logical == LogicalNullable:
+		return "wrapper"
+	case strings.HasPrefix(f.Type, "google.protobuf."):
+		return "proto"
+	case f.Delimited:
+		return "group"
+	}
+	return "message"
+}
+
+func expand(format, value, typ string) string {
+	return strings.NewReplacer("$v", value, "$T", typ).Replace(format)
+}
+
+// wellKnownType imports and returns the protobuf-go type of a well-known
+// message, which the mapped Go types are converted through.
+func (g *goClient) wellKnownType(name string) string {
+	wk := goWellKnownTypes[name]
+	g.imports[wk.path] = true
+	return wk.name
+}
+
+func (g *goClient) writeAppendWire(m *Message, name string) {
+	var w strings.Builder
+	usesErr := false
+	for i := range m.Fields {
+		g.appendField(&w, &m.Fields[i], &usesErr)
+	}
+
+	fmt.Fprintf(&g.b, "// AppendWire appends the encoded %s to b.\nfunc (m *%s) AppendWire(b []byte) ([]byte, error) {\nif m == nil {\nreturn b, nil\n}\n", m.Name, name)
+	if usesErr {
+		g.b.WriteString("var err error\n")
+	}
+	g.b.WriteString(w.String())
+	g.b.WriteString("return b, nil\n}\n\n")
+}
+
+// appendField writes code appending f, when set, to b. Field types were
+// checked when the struct was written.
+func (g *goClient) appendField(w *strings.Builder, f *Field, usesErr *bool) {
+	g.imports[protowirePackage] = true
+	field := "m." + goFieldName(f.Name)
+	kind := wireKind(f)
+
+	switch {
+	case f.IsMap():
+		fmt.Fprintf(w, "for k, v := range %s {\nvar e []byte\n", field)
+		g.appendValue(w, "e", 1, f.MapKey, "k", usesErr)
+		if value := wireKind(f.MapValue); scalarWires[value].wire != "" || value == "time" || value == "duration" {
+			g.appendValue(w, "e", 2, f.MapValue, "v", usesErr)
+		} else {
+			w.WriteString("if v != nil {\n")
+			g.appendValue(w, "e", 2, f.MapValue, "v", usesErr)
+			w.WriteString("}\n")
+		}
+		fmt.Fprintf(w, "b = protowire.AppendTag(b, %d, protowire.BytesType)\nb = protowire.AppendBytes(b, e)\n}\n", f.Number)
+	case f.IsRepeated() && f.Packed && scalarWires[kind].wire != "" && scalarWires[kind].wire != "BytesType":
+		sw := scalarWires[kind]
+		g.useMath(kind)
+		fmt.Fprintf(w, "if len(%s) > 0 {\nvar p []byte\nfor _, v := range %s {\np = protowire.%s(p, %s)\n}\n", field, field, sw.append, expand(sw.encode, "v", ""))
+		fmt.Fprintf(w, "b = protowire.AppendTag(b, %d, protowire.BytesType)\nb = protowire.AppendBytes(b, p)\n}\n", f.Number)
+	case f.IsRepeated():
+		fmt.Fprintf(w, "for _, v := range %s {\n", field)
+		g.appendValue(w, "b", f.Number, f, "v", usesErr)
+		w.WriteString("}\n")
+	default:
+		value := field
+		var set string
+		switch {
+		case pointerScalar(f):
+			value = "*" + field
+			set = field + " != nil"
+		case scalarWires[kind].wire != "":
+			if !f.IsRequired() {
+				set = expand(scalarWires[kind].set, field, "")
+			}
+		case kind == "time":
+			if !f.IsRequired() {
+				set = "!" + field + ".IsZero()"
+			}
+		case kind == "duration":
+			if !f.IsRequired() {
+				set = field + " != 0"
+			}
+		default:
+			set = field + " != nil"
+		}
+
+		if set != "" {
+			fmt.Fprintf(w, "if %s {\n", set)
+		}
+		g.appendValue(w, "b", f.Number, f, value, usesErr)
+		if set != "" {
+			w.WriteString("}\n")
+		}
+	}
+}
+
+// appendValue writes code appending one value of f, with its tag, to buf.
+func (g *goClient) appendValue(w *strings.Builder, buf string, number int32, f *Field, value string, usesErr *bool) {
+	kind := wireKind(f)
+	if sw, ok := scalarWires[kind]; ok {
+		g.useMath(kind)
+		fmt.Fprintf(w, "%s = protowire.AppendTag(%s, %d, protowire.%s)\n", buf, buf, number, sw.wire)
+		fmt.Fprintf(w, "%s = protowire.%s(%s, %s)\n", buf, sw.append, buf, expand(sw.encode, value, ""))
+		return
+	}
+
+	*usesErr = true
+	if kind == "group" {
+		fmt.Fprintf(w, "%s = protowire.AppendTag(%s, %d, protowire.StartGroupType)\n", buf, buf, number)
+		fmt.Fprintf(w, "if %s, err = %s.AppendWire(%s); err != nil {\nreturn nil, err\n}\n", buf, value, buf)
+		fmt.Fprintf(w, "%s = protowire.AppendTag(%s, %d, protowire.EndGroupType)\n", buf, buf, number)
+		return
+	}
+
+	g.imports[clientRuntime] = true
+	switch kind {
+	case "time":
+		g.wellKnownType("google.protobuf.Timestamp")
+		value = "timestamppb.New(" + value + ")"
+	case "duration":
+		g.wellKnownType("google.protobuf.Duration")
+		value = "durationpb.New(" + value + ")"
+	case "wrapper":
+		value = fmt.Sprintf("&%s{Value: *%s}", g.wellKnownType(f.Type), value)
+	}
+	fmt.Fprintf(w, "if %s, err = client.AppendMessage(%s, %d, %s); err != nil {\nreturn nil, err\n}\n", buf, buf, number, value)
+}
+
+func (g *goClient) useMath(kind string) {
+	if kind == "float" || kind == "double" {
+		g.imports["math"] = true
+	}
+}
+
+func (g *goClient) writeConsumeWire(m *Message, name string) error {
+	g.imports[protowirePackage] = true
+	fmt.Fprintf(&g.b, "// ConsumeWire merges an encoded %s into m.\nfunc (m *%s) ConsumeWire(b []byte) error {\n", m.Name, name)
+	g.b.WriteString("for len(b) > 0 {\nnum, typ, n := protowire.ConsumeTag(b)\nif n < 0 {\nreturn protowire.ParseError(n)\n}\nb = b[n:]\nswitch {\n")
+
+	for i := range m.Fields {
+		f := &m.Fields[i]
+		field := "m." + goFieldName(f.Name)
+		if !f.IsMap() {
+			if err := g.consumeValue(&g.b, "b", f.Number, f, field); err != nil {
+				return fmt.Errorf("%s.%s: %w", m.Name, f.Name, err)
+			}
+			continue
+		}
+
+		key, err := g.elemType(f.MapKey)
+		if err != nil {
+			return err
+		}
+		value, err := g.elemType(f.MapValue)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(&g.b, "case num == %d && typ == protowire.BytesType:\ne, n := protowire.ConsumeBytes(b)\nif n < 0 {\nreturn protowire.ParseError(n)\n}\n", f.Number)
+		fmt.Fprintf(&g.b, "var mk %s\nvar mv %s\n", key, value)
+		g.b.WriteString("for len(e) > 0 {\nnum, typ, n := protowire.ConsumeTag(e)\nif n < 0 {\nreturn protowire.ParseError(n)\n}\ne = e[n:]\nswitch {\n")
+		if err := g.consumeValue(&g.b, "e", 1, f.MapKey, "mk"); err != nil {
+			return err
+		}
+		if err := g.consumeValue(&g.b, "e", 2, f.MapValue, "mv"); err != nil {
+			return err
+		}
+		writeSkip(&g.b, "e")
+		g.b.WriteString("}\n}\n")
+		fmt.Fprintf(&g.b, "if %s == nil {\n%s = make(map[%s]%s)\n}\n%s[mk] = mv\nb = b[n:]\n", field, field, key, value, field)
+	}
+
+	writeSkip(&g.b, "b")
+	g.b.WriteString("}\n}\nreturn nil\n}\n\n")
+	return nil
+}
+
+// writeSkip writes the default case skipping a field the message does not
+// know or that comes in another wire type than the schema's.
+func writeSkip(w *strings.Builder, buf string) {
+	fmt.Fprintf(w, "default:\nn = protowire.ConsumeFieldValue(num, typ, %s)\nif n < 0 {\nreturn protowire.ParseError(n)\n}\n%s = %s[n:]\n", buf, buf, buf)
+}
+
+// consumeValue writes the cases reading a value of f from buf into target.
+// Repeated fields are appended to, and repeated numbers are read packed or
+// not, whichever the peer wrote.
+func (g *goClient) consumeValue(w *strings.Builder, buf string, number int32, f *Field, target string) error {
+	typ, err := g.elemType(f)
+	if err != nil {
+		return err
+	}
+	kind := wireKind(f)
+
+	assign := func(value string) {
+		switch {
+		case f.IsRepeated():
+			fmt.Fprintf(w, "%s = append(%s, %s)\n", target, target, value)
+		case pointerScalar(f):
+			fmt.Fprintf(w, "x := %s\n%s = &x\n", value, target)
+		default:
+			fmt.Fprintf(w, "%s = %s\n", target, value)
+		}
+	}
+
+	if sw, ok := scalarWires[kind]; ok {
+		g.useMath(kind)
+		decode := expand(sw.decode, "", typ)
+		fmt.Fprintf(w, "case num == %d && typ == protowire.%s:\nv, n := protowire.%s(%s)\nif n < 0 {\nreturn protowire.ParseError(n)\n}\n", number, sw.wire, sw.consume, buf)
+		assign(decode)
+		fmt.Fprintf(w, "%s = %s[n:]\n", buf, buf)
+
+		if f.IsRepeated() && sw.wire != "BytesType" {
+			fmt.Fprintf(w, "case num == %d && typ == protowire.BytesType:\np, n := protowire.ConsumeBytes(%s)\nif n < 0 {\nreturn protowire.ParseError(n)\n}\n", number, buf)
+			fmt.Fprintf(w, "for len(p) > 0 {\nv, k := protowire.%s(p)\nif k < 0 {\nreturn protowire.ParseError(k)\n}\n", sw.consume)
+			fmt.Fprintf(w, "%s = append(%s, %s)\np = p[k:]\n}\n%s = %s[n:]\n", target, target, decode, buf, buf)
+		}
+		return nil
+	}
+
+	g.imports[clientRuntime] = true
+	if kind == "group" {
+		fmt.Fprintf(w, "case num == %d && typ == protowire.StartGroupType:\nv, n := protowire.ConsumeGroup(%d, %s)\n", number, number, buf)
+	} else {
+		fmt.Fprintf(w, "case num == %d && typ == protowire.BytesType:\nv, n := protowire.ConsumeBytes(%s)\n", number, buf)
+	}
+	w.WriteString("if n < 0 {\nreturn protowire.ParseError(n)\n}\n")
+
+	switch kind {
+	case "time", "duration", "wrapper":
+		name := map[string]string{"time": "google.protobuf.Timestamp", "duration": "google.protobuf.Duration", "wrapper": f.Type}[kind]
+		fmt.Fprintf(w, "x := &%s{}\nif err := client.Merge(v, x); err != nil {\nreturn err\n}\n", g.wellKnownType(name))
+		switch kind {
+		case "time":
+			assign("x.AsTime()")
+		case "duration":
+			assign("x.AsDuration()")
+		default:
+			assign("&x.Value")
+		}
+	default:
+		elem := strings.TrimPrefix(typ, "*")
+		if f.IsRepeated() {
+			fmt.Fprintf(w, "x := new(%s)\nif err := client.Merge(v, x); err != nil {\nreturn err\n}\n%s = append(%s, x)\n", elem, target, target)
+			break
+		}
+		fmt.Fprintf(w, "if %s == nil {\n%s = new(%s)\n}\nif err := client.Merge(v, %s); err != nil {\nreturn err\n}\n", target, target, elem, target)
+	}
+	fmt.Fprintf(w, "%s = %s[n:]\n", buf, buf)
+	return nil
+}
@@ -0,0 +1,90 @@
+package storpc
+
+import (
+	"fmt"
+	"maps"
+	"sync"
+)
+
+// GroupStore keeps the group ids of a GroupRegistry with the data they
+// namespace.
+type GroupStore interface {
+	LoadGroups() (map[string]uint32, error) // nil when nothing was saved
+	SaveGroups(groups map[string]uint32) error
+}
+
+// GroupRegistry gives every key group a stable id, the MethodHeader.Group
+// of the calls on its tables. Ids start at 1 and are never reused, 0 is
+// left for calls without a group.
+type GroupRegistry struct {
+	store GroupStore
+
+	mu   sync.Mutex
+	ids  map[string]uint32
+	next uint32
+}
+
+// NewGroupRegistry loads the ids saved in store. A nil store keeps them in
+// memory only.
+func NewGroupRegistry(store GroupStore) (*GroupRegistry, error) {
+	r := &GroupRegistry{store: store, ids: make(map[string]uint32), next: 1}
+	if store == nil {
+		return r, nil
+	}
+
+	saved, err := store.LoadGroups()
+	if err != nil {
+		return nil, err
+	}
+	for name, id := range saved {
+		if id == 0 {
+			return nil, fmt.Errorf("group %q has the reserved id 0", name)
+		}
+		r.ids[name] = id
+		if id >= r.next {
+			r.next = id + 1
+		}
+	}
+	return r, nil
+}
+
+// ID returns the id of group, assigning and saving the next free one when
+// the group is new. The empty group has id 0.
+func (r *GroupRegistry) ID(group string) (uint32, error) {
+	if group == "" {
+		return 0, nil
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if id, ok := r.ids[group]; ok {
+		return id, nil
+	}
+
+	id := r.next
+	if r.store != nil {
+		// the store may keep the map, it gets its own
+		groups := maps.Clone(r.ids)
+		groups[group] = id
+		if err := r.store.SaveGroups(groups); err != nil {
+			return 0, fmt.Errorf("save group %q: %w", group, err)
+		}
+	}
+	r.ids[group] = id
+	r.next++
+	return id, nil
+}
+
+// Name returns the group of an id handed out by ID.
+func (r *GroupRegistry) Name(id uint32) (string, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for name, v := range r.ids {
+		if v == id {
+			return name, true
+		}
+	}
+	return "", false
+}
@@ -0,0 +1,186 @@
+package storpc
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/dynamicpb"
+)
+
+type memoryGroupStore struct {
+	groups map[string]uint32
+	err    error
+}
+
+func (s *memoryGroupStore) LoadGroups() (map[string]uint32, error) {
+	return s.groups, nil
+}
+
+func (s *memoryGroupStore) SaveGroups(groups map[string]uint32) error {
+	if s.err != nil {
+		return s.err
+	}
+	s.groups = make(map[string]uint32, len(groups))
+	for k, v := range groups {
+		s.groups[k] = v
+	}
+	return nil
+}
+
+func TestGroupRegistry(t *testing.T) {
+	store := &memoryGroupStore{groups: map[string]uint32{"shop": 4}}
+	groups, err := NewGroupRegistry(store)
+	if err != nil {
+		t.Fatalf("NewGroupRegistry failed: %v", err)
+	}
+
+	if id, _ := groups.ID(""); id != 0 {
+		t.Errorf("empty group = %d, want 0", id)
+	}
+	if id, _ := groups.ID("shop"); id != 4 {
+		t.Errorf("shop = %d, want the saved 4", id)
+	}
+	if id, _ := groups.ID("users"); id != 5 {
+		t.Errorf("users = %d, want 5", id)
+	}
+	if store.groups["users"] != 5 {
+		t.Errorf("users not saved: %v", store.groups)
+	}
+	if name, ok := groups.Name(5); !ok || name != "users" {
+		t.Errorf("Name(5) = %q, %v", name, ok)
+	}
+
+	// an id that could not be saved is not handed out
+	store.err = errors.New("disk full")
+	if _, err := groups.ID("billing"); err == nil {
+		t.Fatal("ID did not fail with the store")
+	}
+	store.err = nil
+	if id, _ := groups.ID("billing"); id != 6 {
+		t.Errorf("billing = %d, want 6", id)
+	}
+
+	if _, err := NewGroupRegistry(&memoryGroupStore{groups: map[string]uint32{"bad": 0}}); err == nil {
+		t.Error("reserved id 0 accepted")
+	}
+}
+
+// retainingGroupStore keeps the map it is given.
+type retainingGroupStore struct {
+	saved []map[string]uint32
+}
+
+func (s *retainingGroupStore) LoadGroups() (map[string]uint32, error) {
+	return nil, nil
+}
+
+func (s *retainingGroupStore) SaveGroups(groups map[string]uint32) error {
+	s.saved = append(s.saved, groups)
+	return nil
+}
+
+func TestGroupRegistrySavesCopies(t *testing.T) {
+	store := &retainingGroupStore{}
+	groups, err := NewGroupRegistry(store)
+	if err != nil {
+		t.Fatalf("NewGroupRegistry failed: %v", err)
+	}
+	groups.ID("shop")
+	groups.ID("users")
+
+	if len(store.saved) != 2 || len(store.saved[0]) != 1 || len(store.saved[1]) != 2 {
+		t.Errorf("saved %v, want each save to keep its own groups", store.saved)
+	}
+}
+
+func TestKeyGroup(t *testing.T) {
+	dir := writeProtoFiles(t, map[string]string{"users.proto": usersProto})
+	input := []string{filepath.Join(dir, "users.proto")}
+
+	parser := NewProtoParser(&ProtoParserOptions{Inputs: input, Quiet: true})
+	gen, err := parser.Parse()
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+	if gen.Body.KeyGroup != "users" {
+		t.Errorf("KeyGroup = %q, want the package", gen.Body.KeyGroup)
+	}
+
+	tenant, err := NewProtoParser(&ProtoParserOptions{Inputs: input, Quiet: true, KeyGroup: "tenant-a"}).Parse()
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+	if tenant.Body.KeyGroup != "tenant-a" || tenant.Body.Group != "users" {
+		t.Errorf("KeyGroup = %q, Group = %q", tenant.Body.KeyGroup, tenant.Body.Group)
+	}
+
+	groups, _ := NewGroupRegistry(nil)
+	groups.ID("other")
+	id, _ := groups.ID(gen.Body.KeyGroup)
+
+	desc, err := parser.Files().FindDescriptorByName("users.Users")
+	if err != nil {
+		t.Fatalf("service not found: %v", err)
+	}
+	md := desc.(protoreflect.ServiceDescriptor).Methods().ByName("Fetch")
+	rpc := NewRpcMethod(gen.Body, md, gen.Body.Method("users.Users", "Fetch"), id)
+
+	ir := rpc.Operate(dynamicpb.NewMessage(md.Input()))
+	if ir.Header.Group != 2 || ir.Header.Operation != OpGet {
+		t.Errorf("header = %+v, want group 2", ir.Header)
+	}
+}
+
+func TestKeyGroupPerService(t *testing.T) {
+	dir := writeProtoFiles(t, map[string]string{
+		"users.proto": usersProto,
+		"billing.proto": `syntax = "proto3";
+package billing;
+
+message Invoice {
+  int64 id = 1;
+}
+
+service Invoices {
+  rpc GetInvoice(Invoice) returns (Invoice);
+}
+`,
+	})
+	input := []string{filepath.Join(dir, "users.proto"), filepath.Join(dir, "billing.proto")}
+
+	gen, err := NewProtoParser(&ProtoParserOptions{Inputs: input, Quiet: true}).Parse()
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+	got := make(map[string]string)
+	for _, svc := range gen.Body.Services {
+		got[svc.Name] = svc.KeyGroup
+	}
+	if got["users.Users"] != "users" || got["billing.Invoices"] != "billing" {
+		t.Errorf("key groups = %v, want the package of each service", got)
+	}
+
+	data, err := MarshalGenIR(gen)
+	if err != nil {
+		t.Fatalf("MarshalGenIR failed: %v", err)
+	}
+	decoded, err := UnmarshalGenIR(data)
+	if err != nil {
+		t.Fatalf("UnmarshalGenIR failed: %v", err)
+	}
+	if svc := decoded.Body.Services[1]; svc.KeyGroup != gen.Body.Services[1].KeyGroup {
+		t.Errorf("decoded key group = %q", svc.KeyGroup)
+	}
+
+	tenant, err := NewProtoParser(&ProtoParserOptions{Inputs: input, Quiet: true, KeyGroup: "tenant-a"}).Parse()
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+	for _, svc := range tenant.Body.Services {
+		if svc.KeyGroup != "tenant-a" {
+			t.Errorf("%v key group = %q, want tenant-a", svc.Name, svc.KeyGroup)
+		}
+	}
+}
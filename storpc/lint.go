@@ -0,0 +1,214 @@
+package storpc
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// lint rules, reported as the diagnostic code
+const (
+	RuleTableNoKey        = "TABLE_NO_KEY"
+	RuleUnindexableField  = "UNINDEXABLE_FIELD"
+	RuleUnknownOperation  = CodeUnknownOperation
+	RuleStreamingRPC      = CodeStreamingRPC
+	RuleUnboundedRepeated = "UNBOUNDED_REPEATED"
+)
+
+// CodeUnknownHotTable reports a configured hot table the schema does not
+// have. It is not a rule and cannot be turned off.
+const CodeUnknownHotTable = "UNKNOWN_HOT_TABLE"
+
+// severity of each rule unless configured otherwise
+var lintRules = map[string]uint8{
+	RuleTableNoKey:        SeverityError,
+	RuleUnindexableField:  SeverityError,
+	RuleUnknownOperation:  SeverityWarning,
+	RuleStreamingRPC:      SeverityError,
+	RuleUnboundedRepeated: SeverityWarning,
+}
+
+// LintConfig selects the rules Lint applies. The zero value applies every
+// rule at its default severity.
+type LintConfig struct {
+	Severities map[string]uint8 // per rule, overriding the default
+	Disabled   map[string]bool
+	HotTables  []string // tables checked for unbounded repeated fields, every table a method operates on if empty
+}
+
+// NewLintConfig builds a configuration from command line values: a rule
+// spec such as "UNKNOWN_OPERATION=error,UNBOUNDED_REPEATED=off" and a comma
+// separated list of hot tables.
+func NewLintConfig(rules, hotTables string) (*LintConfig, error) {
+	config := &LintConfig{HotTables: splitArg(hotTables)}
+	for _, spec := range splitArg(rules) {
+		rule, name, ok := strings.Cut(spec, "=")
+		if !ok {
+			return nil, fmt.Errorf("bad lint rule %q, want RULE=severity", spec)
+		}
+		rule = strings.ToUpper(strings.TrimSpace(rule))
+		name = strings.ToLower(strings.TrimSpace(name))
+		if _, ok := lintRules[rule]; !ok {
+			return nil, fmt.Errorf("unknown lint rule %q", rule)
+		}
+
+		if name == "off" {
+			if config.Disabled == nil {
+				config.Disabled = make(map[string]bool)
+			}
+			config.Disabled[rule] = true
+			continue
+		}
+		severity, ok := severityOf(name)
+		if !ok {
+			return nil, fmt.Errorf("bad severity %q for lint rule %s", name, rule)
+		}
+		if config.Severities == nil {
+			config.Severities = make(map[string]uint8)
+		}
+		config.Severities[rule] = severity
+	}
+	return config, nil
+}
+
+func severityOf(name string) (uint8, bool) {
+	for _, severity := range []uint8{SeverityError, SeverityWarning, SeverityInfo} {
+		if SeverityString(severity) == name {
+			return severity, true
+		}
+	}
+	return 0, false
+}
+
+// Lint checks that the schema is ready to be stored: tables have keys,
+// indexed fields have indexable types, hosted methods map to storage
+// operations, and hot tables hold no unbounded repeated fields. Messages
+// suppress rules with (storpc.lint_ignore); a method's rules are suppressed
+// by its request message.
+func Lint(gen *GenIR, config *LintConfig) Diagnostics {
+	if config == nil {
+		config = &LintConfig{}
+	}
+	l := &linter{config: config}
+
+	for _, name := range config.HotTables {
+		if !slices.ContainsFunc(gen.Body.Messages, func(m Message) bool {
+			return m.Table != "" && (m.Table == name || m.Name == name)
+		}) {
+			l.diagnostics = append(l.diagnostics, Diagnostic{
+				Severity: SeverityError,
+				Code:     CodeUnknownHotTable,
+				Message:  fmt.Sprintf("hot table %v is not a table of the schema", name),
+			})
+		}
+	}
+
+	hot := config.HotTables
+	if len(hot) == 0 {
+		for _, svc := range gen.Body.Services {
+			for _, m := range svc.Methods {
+				if m.Table != "" && !slices.Contains(hot, m.Table) {
+					hot = append(hot, m.Table)
+				}
+			}
+		}
+	}
+
+	for i := range gen.Body.Messages {
+		m := &gen.Body.Messages[i]
+		if m.Table == "" {
+			continue
+		}
+
+		if len(m.KeyFields()) == 0 {
+			l.report(m, RuleTableNoKey, m.Location, "table %v of %v has no (storpc.key) field", m.Table, m.Name)
+		}
+		for j := range m.Fields {
+			f := &m.Fields[j]
+			if (f.Key || f.Index || f.Unique) && !indexable(f) {
+				l.report(m, RuleUnindexableField, m.Location, "%v.%v of type %v cannot be indexed", m.Name, f.Name, fieldTypeName(f))
+			}
+		}
+
+		if slices.Contains(hot, m.Name) || slices.Contains(hot, m.Table) {
+			for j := range m.Fields {
+				f := &m.Fields[j]
+				if f.Cardinality == CardinalityRepeated {
+					l.report(m, RuleUnboundedRepeated, m.Location, "%v.%v is an unbounded repeated field on hot table %v", m.Name, f.Name, m.Table)
+				}
+			}
+		}
+	}
+
+	for _, svc := range gen.Body.Services {
+		for _, method := range svc.Methods {
+			request := gen.Body.Message(method.Input)
+			loc := method.Location
+			if loc.File == "" {
+				loc.File = svc.File
+			}
+			if method.ClientStreaming || method.ServerStreaming {
+				l.report(request, RuleStreamingRPC, loc, "%v.%v is a streaming RPC and cannot be stored", svc.Name, method.Name)
+			}
+			if method.Operation == OpUnknown {
+				l.report(request, RuleUnknownOperation, loc, "cannot infer operation of %v.%v, set (storpc.op)", svc.Name, method.Name)
+			}
+		}
+	}
+
+	return l.diagnostics
+}
+
+type linter struct {
+	config      *LintConfig
+	diagnostics Diagnostics
+}
+
+// report adds a finding of rule unless the rule is disabled or m ignores it.
+func (l *linter) report(m *Message, rule string, loc Location, format string, args ...any) {
+	if l.config.Disabled[rule] {
+		return
+	}
+	if m != nil && slices.Contains(m.LintIgnore, rule) {
+		return
+	}
+
+	severity, ok := l.config.Severities[rule]
+	if !ok {
+		severity = lintRules[rule]
+	}
+	l.diagnostics = append(l.diagnostics, Diagnostic{
+		Severity: severity,
+		Code:     rule,
+		Message:  fmt.Sprintf(format, args...),
+		File:     loc.File,
+		Line:     loc.Line,
+		Column:   loc.Column,
+	})
+}
+
+// indexable reports whether the storage engine can build an index over f:
+// single scalars, enums, and well-known types stored as a scalar column.
+func indexable(f *Field) bool {
+	if f.Cardinality == CardinalityRepeated {
+		return false
+	}
+	if f.Kind != KindMessage {
+		return true
+	}
+	switch f.Logical {
+	case LogicalTime, LogicalDuration, LogicalNullable:
+		return true
+	}
+	return false
+}
+
+func fieldTypeName(f *Field) string {
+	switch {
+	case f.IsMap():
+		return fmt.Sprintf("map<%v, %v>", f.MapKey.Type, f.MapValue.Type)
+	case f.IsRepeated():
+		return "repeated " + f.Type
+	}
+	return f.Type
+}
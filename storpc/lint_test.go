@@ -0,0 +1,152 @@
+package storpc
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const lintSchema = `syntax = "proto3";
+package shop;
+
+import "google/protobuf/timestamp.proto";
+import "storpc/options.proto";
+
+message Address {
+  string city = 1;
+}
+
+message Order {
+  option (storpc.table) = "orders";
+  string id = 1 [(storpc.key) = true];
+  repeated string tags = 2;
+  Address address = 3 [(storpc.index) = true];
+  google.protobuf.Timestamp placed = 4 [(storpc.index) = true];
+}
+
+message Audit {
+  option (storpc.table) = "audits";
+  string line = 1;
+}
+
+message Event {
+  option (storpc.table) = "events";
+  option (storpc.lint_ignore) = "TABLE_NO_KEY";
+  repeated string lines = 1;
+}
+
+message WatchRequest {
+  option (storpc.lint_ignore) = "UNKNOWN_OPERATION";
+  string id = 1;
+}
+
+message GetOrderRequest {
+  string id = 1;
+}
+
+service Shop {
+  rpc GetOrder(GetOrderRequest) returns (Order);
+  rpc Settle(GetOrderRequest) returns (Order);
+  rpc WatchOrders(WatchRequest) returns (stream Order);
+}
+`
+
+func lintCodes(ds Diagnostics) map[string]uint8 {
+	codes := make(map[string]uint8)
+	for _, d := range ds {
+		codes[d.Code+" "+d.Message] = d.Severity
+	}
+	return codes
+}
+
+func keepStreaming(o *ProtoParserOptions) { o.KeepStreaming = true }
+
+func TestLint(t *testing.T) {
+	gen := parseSchema(t, map[string]string{"schema.proto": lintSchema}, keepStreaming)
+
+	// lint options and streaming methods survive the binary format
+	data, err := MarshalGenIR(gen)
+	if err != nil {
+		t.Fatalf("MarshalGenIR failed: %v", err)
+	}
+	gen, err = UnmarshalGenIR(data)
+	if err != nil {
+		t.Fatalf("UnmarshalGenIR failed: %v", err)
+	}
+
+	got := lintCodes(Lint(gen, nil))
+
+	want := map[string]uint8{
+		"TABLE_NO_KEY table audits of shop.Audit has no (storpc.key) field":                     SeverityError,
+		"UNINDEXABLE_FIELD shop.Order.address of type shop.Address cannot be indexed":           SeverityError,
+		"UNBOUNDED_REPEATED shop.Order.tags is an unbounded repeated field on hot table orders": SeverityWarning,
+		"UNKNOWN_OPERATION cannot infer operation of shop.Shop.Settle, set (storpc.op)":         SeverityWarning,
+		"STREAMING_RPC shop.Shop.WatchOrders is a streaming RPC and cannot be stored":           SeverityError,
+	}
+	for code, severity := range want {
+		if s, ok := got[code]; !ok || s != severity {
+			t.Errorf("missing %s %q", SeverityString(severity), code)
+		}
+	}
+	if len(got) != len(want) {
+		t.Errorf("got %d findings, want %d: %v", len(got), len(want), got)
+	}
+
+	// findings point at the message or method declaration
+	at := make(map[string]string)
+	for _, d := range Lint(gen, nil) {
+		at[d.Code] = fmt.Sprintf("%s:%d:%d", d.File, d.Line, d.Column)
+	}
+	for code, loc := range map[string]string{
+		RuleTableNoKey:        "schema.proto:19:1",
+		RuleUnboundedRepeated: "schema.proto:11:1",
+		RuleStreamingRPC:      "schema.proto:42:3",
+	} {
+		if at[code] != loc {
+			t.Errorf("%s reported at %s, want %s", code, at[code], loc)
+		}
+	}
+}
+
+func TestLintConfig(t *testing.T) {
+	config, err := NewLintConfig("unbounded_repeated=off, TABLE_NO_KEY= Warning ", "events,shop.Audit,nowhere")
+	if err != nil {
+		t.Fatalf("NewLintConfig failed: %v", err)
+	}
+
+	got := lintCodes(Lint(parseSchema(t, map[string]string{"schema.proto": lintSchema}, keepStreaming), config))
+	if got["TABLE_NO_KEY table audits of shop.Audit has no (storpc.key) field"] != SeverityWarning {
+		t.Errorf("TABLE_NO_KEY not downgraded: %v", got)
+	}
+	for code := range got {
+		if strings.HasPrefix(code, RuleUnboundedRepeated) {
+			t.Errorf("disabled rule reported: %v", code)
+		}
+	}
+	if got["UNKNOWN_HOT_TABLE hot table nowhere is not a table of the schema"] != SeverityError {
+		t.Errorf("unknown hot table not reported: %v", got)
+	}
+	for code := range got {
+		if strings.HasPrefix(code, CodeUnknownHotTable) && !strings.Contains(code, "nowhere") {
+			t.Errorf("known hot table reported: %v", code)
+		}
+	}
+
+	for _, bad := range []string{"TABLE_NO_KEY", "NO_SUCH_RULE=error", "TABLE_NO_KEY=fatal"} {
+		if _, err := NewLintConfig(bad, ""); err == nil {
+			t.Errorf("NewLintConfig(%q) did not fail", bad)
+		}
+	}
+}
+
+func TestParseRejectsStreaming(t *testing.T) {
+	dir := writeProtoFiles(t, map[string]string{"schema.proto": lintSchema})
+	_, err := NewProtoParser(&ProtoParserOptions{
+		Inputs: []string{filepath.Join(dir, "schema.proto")},
+		Quiet:  true,
+	}).Parse()
+	if err == nil {
+		t.Fatal("streaming method parsed without KeepStreaming")
+	}
+}
@@ -0,0 +1,118 @@
+package storpc
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+)
+
+// subsystems that log, each can have its own level
+const (
+	SubsystemParser = "parser"
+	SubsystemServer = "server"
+	SubsystemDriver = "driver"
+)
+
+const (
+	LogFormatText = "text"
+	LogFormatJSON = "json"
+)
+
+// LogConfig is the logging configuration shared by the parser, the server
+// and the driver. With Logger set, records go to its handler, which may
+// filter further; otherwise a handler in Format is built on Output.
+type LogConfig struct {
+	Logger *slog.Logger
+	Output io.Writer // stdout if nil
+	Format string    // LogFormatText or LogFormatJSON, text if empty
+	Level  slog.Level
+	Levels map[string]slog.Level // per subsystem, overriding Level
+}
+
+// NewLogConfig builds a configuration from command line values: a format
+// and a level spec such as "info" or "warn,parser=debug".
+func NewLogConfig(format, levels string) (*LogConfig, error) {
+	switch format {
+	case "", LogFormatText, LogFormatJSON:
+	default:
+		return nil, fmt.Errorf("unknown log format %q", format)
+	}
+
+	config := &LogConfig{Format: format, Level: slog.LevelInfo}
+	for _, spec := range splitArg(levels) {
+		subsystem, name, scoped := strings.Cut(spec, "=")
+		if !scoped {
+			name = subsystem
+		}
+
+		var level slog.Level
+		if err := level.UnmarshalText([]byte(name)); err != nil {
+			return nil, fmt.Errorf("bad log level %q", spec)
+		}
+
+		if !scoped {
+			config.Level = level
+			continue
+		}
+		switch subsystem {
+		case SubsystemParser, SubsystemServer, SubsystemDriver:
+		default:
+			return nil, fmt.Errorf("unknown log subsystem %q", subsystem)
+		}
+		if config.Levels == nil {
+			config.Levels = make(map[string]slog.Level)
+		}
+		config.Levels[subsystem] = level
+	}
+	return config, nil
+}
+
+// For returns the logger of a subsystem. Its records carry a subsystem
+// attribute and are dropped below the subsystem's level.
+func (c *LogConfig) For(subsystem string) *slog.Logger {
+	level, ok := c.Levels[subsystem]
+	if !ok {
+		level = c.Level
+	}
+
+	var handler slog.Handler
+	switch {
+	case c.Logger != nil:
+		handler = c.Logger.Handler()
+	case c.Format == LogFormatJSON:
+		handler = slog.NewJSONHandler(c.output(), &slog.HandlerOptions{Level: slog.LevelDebug})
+	default:
+		handler = slog.NewTextHandler(c.output(), &slog.HandlerOptions{Level: slog.LevelDebug})
+	}
+
+	return slog.New(levelHandler{level: level, Handler: handler}).With("subsystem", subsystem)
+}
+
+func (c *LogConfig) output() io.Writer {
+	if c.Output == nil {
+		return os.Stdout
+	}
+	return c.Output
+}
+
+// levelHandler drops records below level before the wrapped handler sees
+// them.
+type levelHandler struct {
+	level slog.Level
+	slog.Handler
+}
+
+func (h levelHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	return level >= h.level && h.Handler.Enabled(ctx, level)
+}
+
+func (h levelHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return levelHandler{level: h.level, Handler: h.Handler.WithAttrs(attrs)}
+}
+
+func (h levelHandler) WithGroup(name string) slog.Handler {
+	return levelHandler{level: h.level, Handler: h.Handler.WithGroup(name)}
+}
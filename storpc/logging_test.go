@@ -0,0 +1,90 @@
+package storpc
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestNewLogConfig(t *testing.T) {
+	config, err := NewLogConfig("json", "warn, parser=debug,driver=error")
+	if err != nil {
+		t.Fatalf("NewLogConfig failed: %v", err)
+	}
+	if config.Format != LogFormatJSON || config.Level != slog.LevelWarn {
+		t.Errorf("got format %q level %v", config.Format, config.Level)
+	}
+	if config.Levels[SubsystemParser] != slog.LevelDebug || config.Levels[SubsystemDriver] != slog.LevelError {
+		t.Errorf("got levels %v", config.Levels)
+	}
+
+	for _, bad := range [][2]string{{"xml", ""}, {"", "loud"}, {"", "client=debug"}} {
+		if _, err := NewLogConfig(bad[0], bad[1]); err == nil {
+			t.Errorf("NewLogConfig(%q, %q) did not fail", bad[0], bad[1])
+		}
+	}
+}
+
+func TestLogConfigLevels(t *testing.T) {
+	var out bytes.Buffer
+	config := &LogConfig{
+		Output: &out,
+		Format: LogFormatJSON,
+		Level:  slog.LevelWarn,
+		Levels: map[string]slog.Level{SubsystemServer: slog.LevelDebug},
+	}
+
+	config.For(SubsystemParser).Info("dropped")
+	config.For(SubsystemServer).Debug("kept", "method", "/Users/Get")
+
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	if len(lines) != 1 {
+		t.Fatalf("got %d records, want 1:\n%s", len(lines), out.String())
+	}
+	var record map[string]any
+	if err := json.Unmarshal([]byte(lines[0]), &record); err != nil {
+		t.Fatalf("record is not JSON: %v", err)
+	}
+	if record["msg"] != "kept" || record["subsystem"] != SubsystemServer || record["method"] != "/Users/Get" {
+		t.Errorf("got record %v", record)
+	}
+}
+
+func TestLogConfigLogger(t *testing.T) {
+	var out bytes.Buffer
+	logger := slog.New(slog.NewTextHandler(&out, &slog.HandlerOptions{Level: slog.LevelInfo}))
+	config := &LogConfig{Logger: logger, Level: slog.LevelDebug}
+
+	// the caller's handler still filters below its own level
+	config.For(SubsystemDriver).Debug("dropped")
+	config.For(SubsystemDriver).Info("kept")
+
+	if got := out.String(); strings.Contains(got, "dropped") || !strings.Contains(got, "msg=kept subsystem=driver") {
+		t.Errorf("got %q", got)
+	}
+}
+
+func TestParserLogConfig(t *testing.T) {
+	dir := writeProtoFiles(t, map[string]string{"users.proto": usersProto})
+
+	var out bytes.Buffer
+	parser := NewProtoParser(&ProtoParserOptions{
+		Inputs: []string{filepath.Join(dir, "users.proto")},
+		Log: &LogConfig{
+			Output: &out,
+			Format: LogFormatJSON,
+			Level:  slog.LevelError,
+			Levels: map[string]slog.Level{SubsystemParser: slog.LevelDebug},
+		},
+	})
+	if _, err := parser.Parse(); err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+
+	if !strings.Contains(out.String(), `"subsystem":"parser"`) {
+		t.Errorf("no parser records in %q", out.String())
+	}
+}
@@ -0,0 +1,419 @@
+package storpc
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"math"
+
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+/*
+Binary MethodIR format
+
+	magic     4 bytes   "SMIR"
+	header   10 bytes   MethodHeader, integers big endian
+	checksum  4 bytes   CRC32C, big endian
+	body      n bytes   MethodBody record
+
+The checksum is the CRC32C (Castagnoli) of the whole frame with the checksum
+bytes zeroed. Every frame has one and readers check it before anything else
+in the header but the version, so a damaged header cannot turn it off.
+
+Features are bits. The low byte holds optional features, which readers that
+do not know them ignore; the high byte holds required ones, and readers
+reject frames using a required feature they do not know. Readers accept any
+minor version of their major version and reject other majors, so nodes of
+one major version can be rolled out in any order.
+
+Records are written as in the GenIR format. Values are tagged with their
+kind, the Value constants, so a frame can be read without a schema and
+checked against the one it is read with. Values are written even when zero.
+
+	MethodBody  1 type  2 Field*
+	Field       1 number  2 Value
+	Value       one of  1 int (zigzag)  2 uint  3 double (fixed64)
+	            4 float (fixed32)  5 bool  6 string  7 bytes  8 enum (zigzag)
+	            9 message MethodBody  10 list Value*  11 map Entry*
+	Entry       1 key Value  2 value Value
+
+Int covers every signed integer kind, uint every unsigned one. Nested
+messages repeat their type.
+*/
+
+const MethodHeaderSize = 14 // header and checksum
+
+// MethodHeader features. No feature is defined yet.
+const (
+	FeaturesRequired uint16 = 0xFF00 // bits a reader must know to read the frame
+	KnownFeatures    uint16 = 0
+)
+
+var methodMagic = [4]byte{'S', 'M', 'I', 'R'}
+
+var castagnoli = crc32.MakeTable(crc32.Castagnoli)
+
+var (
+	ErrNotMethodIR = errors.New("not a binary MethodIR")
+	ErrTruncated   = errors.New("frame is truncated")
+)
+
+// VersionError reports a frame or file written by another major version.
+type VersionError struct {
+	Format              string
+	Major, Minor, Patch uint8
+}
+
+func (e *VersionError) Error() string {
+	return fmt.Sprintf("%v version %d.%d.%d is not supported by %d.%d.%d, the major versions differ",
+		e.Format, e.Major, e.Minor, e.Patch,
+		STORPC_VERSION_MAJOR, STORPC_VERSION_MINOR, STORPC_VERSION_PATCH)
+}
+
+// ChecksumError reports a frame that does not match its checksum.
+type ChecksumError struct {
+	Format    string
+	Want, Got uint32
+}
+
+func (e *ChecksumError) Error() string {
+	return fmt.Sprintf("corrupt %v: checksum is %08x, content hashes to %08x", e.Format, e.Want, e.Got)
+}
+
+// FeatureError reports a frame using required features this version does
+// not know.
+type FeatureError struct {
+	Format   string
+	Features uint16 // the unknown required features
+}
+
+func (e *FeatureError) Error() string {
+	return fmt.Sprintf("%v uses unsupported required features %#04x", e.Format, e.Features)
+}
+
+// NegotiateFeatures returns the features to write for a peer that knows
+// peer: the ones both sides know.
+func NegotiateFeatures(peer uint16) uint16 {
+	return peer & KnownFeatures
+}
+
+// Check reports whether a frame with header h can be read by this version.
+func (h *MethodHeader) Check() error {
+	if err := checkVersion("MethodIR", h.VersionMajor, h.VersionMinor, h.VersionPatch); err != nil {
+		return err
+	}
+	if unknown := h.Features &^ KnownFeatures & FeaturesRequired; unknown != 0 {
+		return &FeatureError{Format: "MethodIR", Features: unknown}
+	}
+	return nil
+}
+
+// MarshalMethodIR encodes a call in the binary MethodIR format.
+func MarshalMethodIR(ir *MethodIR) ([]byte, error) {
+	if ir == nil || ir.Header == nil || ir.Body == nil {
+		return nil, errors.New("MethodIR has no header or body")
+	}
+	header := ir.Header
+
+	b := make([]byte, 0, 64)
+	b = append(b, methodMagic[:]...)
+	b = append(b, header.VersionMajor, header.VersionMinor, header.VersionPatch)
+	b = binary.BigEndian.AppendUint32(b, header.Group)
+	b = append(b, header.Operation)
+	b = binary.BigEndian.AppendUint16(b, header.Features)
+	b = append(b, 0, 0, 0, 0)
+
+	w := &recordWriter{b: b}
+	if err := writeMethodBody(w, ir.Body); err != nil {
+		return nil, err
+	}
+	sealFrame(w.b, len(methodMagic)+MethodHeaderSize-4)
+	return w.b, nil
+}
+
+// UnmarshalMethodIR decodes a binary MethodIR. With a schema, fields are
+// linked to the IR fields of the same number and their values checked
+// against them; fields the schema does not declare are kept unlinked.
+// Damaged frames fail with a *ChecksumError, frames this version cannot
+// read with a *VersionError or *FeatureError.
+func UnmarshalMethodIR(schema *GenBody, data []byte) (*MethodIR, error) {
+	if len(data) < len(methodMagic) || [4]byte(data[:4]) != methodMagic {
+		return nil, ErrNotMethodIR
+	}
+	if len(data) < len(methodMagic)+3 {
+		return nil, ErrTruncated
+	}
+	if err := checkVersion("MethodIR", data[4], data[5], data[6]); err != nil {
+		return nil, err
+	}
+	if len(data) < len(methodMagic)+MethodHeaderSize {
+		return nil, ErrTruncated
+	}
+	frame := data
+	data = data[len(methodMagic):]
+
+	header := &MethodHeader{
+		VersionMajor: data[0],
+		VersionMinor: data[1],
+		VersionPatch: data[2],
+		Group:        binary.BigEndian.Uint32(data[3:7]),
+		Operation:    data[7],
+		Features:     binary.BigEndian.Uint16(data[8:10]),
+	}
+	if err := checkFrame("MethodIR", frame, len(methodMagic)+MethodHeaderSize-4); err != nil {
+		return nil, err
+	}
+	if err := header.Check(); err != nil {
+		return nil, err
+	}
+
+	body, err := readMethodBody(schema, data[MethodHeaderSize:])
+	if err != nil {
+		return nil, err
+	}
+	return NewMethodIR(header, body), nil
+}
+
+// sealFrame writes the checksum of frame into the four bytes at offset.
+func sealFrame(frame []byte, offset int) {
+	binary.BigEndian.PutUint32(frame[offset:], 0)
+	binary.BigEndian.PutUint32(frame[offset:], crc32.Checksum(frame, castagnoli))
+}
+
+// checkFrame checks the checksum sealFrame wrote at offset.
+func checkFrame(format string, frame []byte, offset int) error {
+	want := binary.BigEndian.Uint32(frame[offset:])
+
+	crc := crc32.Update(0, castagnoli, frame[:offset])
+	crc = crc32.Update(crc, castagnoli, []byte{0, 0, 0, 0})
+	crc = crc32.Update(crc, castagnoli, frame[offset+4:])
+	if crc != want {
+		return &ChecksumError{Format: format, Want: want, Got: crc}
+	}
+	return nil
+}
+
+func checkVersion(format string, major, minor, patch uint8) error {
+	if major != STORPC_VERSION_MAJOR {
+		return &VersionError{Format: format, Major: major, Minor: minor, Patch: patch}
+	}
+	return nil
+}
+
+func writeMethodBody(w *recordWriter, body *MethodBody) error {
+	w.string(1, body.Type)
+	for i := range body.Fields {
+		fv := &body.Fields[i]
+
+		var err error
+		w.record(2, func(w *recordWriter) {
+			w.varint(1, uint64(fv.Number), true)
+			w.record(2, func(w *recordWriter) { err = writeValue(w, fv.Value) })
+		})
+		if err != nil {
+			return fmt.Errorf("%v field %d: %w", body.Type, fv.Number, err)
+		}
+	}
+	return nil
+}
+
+func writeValue(w *recordWriter, v Value) error {
+	tag := protowire.Number(v.Kind)
+	var err error
+	switch v.Kind {
+	case ValueInt, ValueEnum:
+		w.varint(tag, protowire.EncodeZigZag(v.Int), true)
+	case ValueUint:
+		w.varint(tag, v.Uint, true)
+	case ValueDouble:
+		w.fixed64(tag, math.Float64bits(v.Float))
+	case ValueFloat:
+		w.fixed32(tag, math.Float32bits(float32(v.Float)))
+	case ValueBool:
+		w.varint(tag, protowire.EncodeBool(v.Bool), true)
+	case ValueString:
+		w.b = protowire.AppendTag(w.b, tag, protowire.BytesType)
+		w.b = protowire.AppendString(w.b, v.String)
+	case ValueBytes:
+		w.b = protowire.AppendTag(w.b, tag, protowire.BytesType)
+		w.b = protowire.AppendBytes(w.b, v.Bytes)
+	case ValueMessage:
+		if v.Message == nil {
+			return errors.New("message value without a body")
+		}
+		w.record(tag, func(w *recordWriter) { err = writeMethodBody(w, v.Message) })
+	case ValueList:
+		w.record(tag, func(w *recordWriter) {
+			for _, e := range v.List {
+				w.record(1, func(w *recordWriter) { err = errors.Join(err, writeValue(w, e)) })
+			}
+		})
+	case ValueMap:
+		w.record(tag, func(w *recordWriter) {
+			for _, e := range v.Map {
+				w.record(1, func(w *recordWriter) {
+					w.record(1, func(w *recordWriter) { err = errors.Join(err, writeValue(w, e.Key)) })
+					w.record(2, func(w *recordWriter) { err = errors.Join(err, writeValue(w, e.Value)) })
+				})
+			}
+		})
+	default:
+		return fmt.Errorf("cannot write a value of kind %v", ValueKindString(v.Kind))
+	}
+	return err
+}
+
+func readMethodBody(schema *GenBody, data []byte) (*MethodBody, error) {
+	body := &MethodBody{}
+	err := readRecord(data, func(tag protowire.Number, v value) error {
+		switch tag {
+		case 1:
+			body.Type = v.string()
+		case 2:
+			var fv FieldValue
+			err := readRecord(v.bytes, func(tag protowire.Number, v value) error {
+				switch tag {
+				case 1:
+					fv.Number = int32(v.uint)
+				case 2:
+					var err error
+					fv.Value, err = readValue(schema, v.bytes)
+					return err
+				}
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+			body.Fields = append(body.Fields, fv)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sortFields(body.Fields)
+	for i := 1; i < len(body.Fields); i++ {
+		if body.Fields[i].Number == body.Fields[i-1].Number {
+			return nil, fmt.Errorf("%v field %d: set twice", body.Type, body.Fields[i].Number)
+		}
+	}
+
+	if schema == nil {
+		return body, nil
+	}
+	m := schema.Message(body.Type)
+	if m == nil {
+		return body, nil
+	}
+	for i := range body.Fields {
+		fv := &body.Fields[i]
+		fv.Field = fieldByNumber(m, fv.Number)
+		if fv.Field == nil {
+			continue
+		}
+		if err := checkValue(fv.Field, fv.Value); err != nil {
+			return nil, fmt.Errorf("%v.%v: %w", m.Name, fv.Field.Name, err)
+		}
+	}
+	return body, nil
+}
+
+// readValue reads the single property of a Value record.
+func readValue(schema *GenBody, data []byte) (Value, error) {
+	var out Value
+	err := readRecord(data, func(tag protowire.Number, v value) error {
+		out = Value{Kind: uint8(tag)}
+		var err error
+		switch out.Kind {
+		case ValueInt, ValueEnum:
+			out.Int = protowire.DecodeZigZag(v.uint)
+		case ValueUint:
+			out.Uint = v.uint
+		case ValueDouble:
+			out.Float = math.Float64frombits(v.uint)
+		case ValueFloat:
+			out.Float = float64(math.Float32frombits(uint32(v.uint)))
+		case ValueBool:
+			out.Bool = v.bool()
+		case ValueString:
+			out.String = v.string()
+		case ValueBytes:
+			out.Bytes = append([]byte{}, v.bytes...)
+		case ValueMessage:
+			out.Message, err = readMethodBody(schema, v.bytes)
+		case ValueList:
+			out.List = []Value{}
+			err = readRecord(v.bytes, func(_ protowire.Number, elem value) error {
+				e, err := readValue(schema, elem.bytes)
+				out.List = append(out.List, e)
+				return err
+			})
+		case ValueMap:
+			out.Map = []MapEntry{}
+			err = readRecord(v.bytes, func(_ protowire.Number, entry value) error {
+				var e MapEntry
+				err := readRecord(entry.bytes, func(tag protowire.Number, v value) error {
+					var err error
+					switch tag {
+					case 1:
+						e.Key, err = readValue(schema, v.bytes)
+					case 2:
+						e.Value, err = readValue(schema, v.bytes)
+					}
+					return err
+				})
+				out.Map = append(out.Map, e)
+				return err
+			})
+		default:
+			err = fmt.Errorf("unknown value kind %d", tag)
+		}
+		return err
+	})
+	if err == nil && out.Kind == ValueNone {
+		err = errors.New("empty value")
+	}
+	return out, err
+}
+
+// checkValue reports a value that does not have the kind f declares.
+func checkValue(f *Field, v Value) error {
+	mismatch := func(got, want uint8) error {
+		return fmt.Errorf("got %v value, want %v for %v", ValueKindString(got), ValueKindString(want), f.Type)
+	}
+
+	switch {
+	case f.IsMap():
+		if v.Kind != ValueMap {
+			return mismatch(v.Kind, ValueMap)
+		}
+		key, value := valueKind(f.MapKey), valueKind(f.MapValue)
+		for _, e := range v.Map {
+			if e.Key.Kind != key {
+				return mismatch(e.Key.Kind, key)
+			}
+			if e.Value.Kind != value {
+				return mismatch(e.Value.Kind, value)
+			}
+		}
+	case f.IsRepeated():
+		if v.Kind != ValueList {
+			return mismatch(v.Kind, ValueList)
+		}
+		want := valueKind(f)
+		for _, e := range v.List {
+			if e.Kind != want {
+				return mismatch(e.Kind, want)
+			}
+		}
+	default:
+		if want := valueKind(f); v.Kind != want {
+			return mismatch(v.Kind, want)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,362 @@
+package storpc
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"google.golang.org/protobuf/reflect/protoreflect"
+)
+
+var update = flag.Bool("update", false, "rewrite golden files")
+
+const methodSchema = `syntax = "proto3";
+package shop;
+
+import "storpc/options.proto";
+
+enum Status {
+  STATUS_UNKNOWN = 0;
+  STATUS_OPEN = 1;
+  STATUS_VOID = -1;
+}
+
+message Money {
+  string currency = 1;
+  sint64 units = 2;
+}
+
+message Order {
+  option (storpc.table) = "orders";
+  int64 id = 1 [(storpc.key) = true];
+  uint32 quantity = 2;
+  fixed64 checksum = 3;
+  double weight = 4;
+  float discount = 5;
+  bool gift = 6;
+  string note = 7;
+  bytes blob = 8;
+  Status status = 9;
+  Money total = 10;
+  repeated string tags = 11;
+  repeated Money refunds = 12;
+  map<string, int32> counts = 13;
+  map<int32, Money> by_line = 14;
+  optional int32 priority = 15;
+}
+
+service Orders {
+  rpc InsertOrder(Order) returns (Order);
+}
+`
+
+// goldenOrder is the call pinned by testdata/method_insert.golden.
+func goldenOrder() *MethodIR {
+	money := func(currency string, units int64) Value {
+		return Value{Kind: ValueMessage, Message: NewMethodBody("shop.Money", []FieldValue{
+			{Number: 1, Value: Value{Kind: ValueString, String: currency}},
+			{Number: 2, Value: Value{Kind: ValueInt, Int: units}},
+		})}
+	}
+
+	header := NewMethodHeader(OpInsert)
+	header.Group = 7
+	return NewMethodIR(header, NewMethodBody("shop.Order", []FieldValue{
+		{Number: 1, Value: Value{Kind: ValueInt, Int: -42}},
+		{Number: 2, Value: Value{Kind: ValueUint, Uint: 3}},
+		{Number: 3, Value: Value{Kind: ValueUint, Uint: 1 << 40}},
+		{Number: 4, Value: Value{Kind: ValueDouble, Float: 1.5}},
+		{Number: 5, Value: Value{Kind: ValueFloat, Float: 0.25}},
+		{Number: 6, Value: Value{Kind: ValueBool, Bool: false}},
+		{Number: 7, Value: Value{Kind: ValueString, String: "leave at door"}},
+		{Number: 8, Value: Value{Kind: ValueBytes, Bytes: []byte{0, 1, 2}}},
+		{Number: 9, Value: Value{Kind: ValueEnum, Int: -1}},
+		{Number: 10, Value: money("EUR", -100)},
+		{Number: 11, Value: Value{Kind: ValueList, List: []Value{
+			{Kind: ValueString, String: "a"},
+			{Kind: ValueString, String: "b"},
+		}}},
+		{Number: 12, Value: Value{Kind: ValueList, List: []Value{money("EUR", 5)}}},
+		{Number: 13, Value: Value{Kind: ValueMap, Map: []MapEntry{
+			{Key: Value{Kind: ValueString, String: "x"}, Value: Value{Kind: ValueInt, Int: 1}},
+			{Key: Value{Kind: ValueString, String: "y"}, Value: Value{Kind: ValueInt, Int: 0}},
+		}}},
+		{Number: 14, Value: Value{Kind: ValueMap, Map: []MapEntry{
+			{Key: Value{Kind: ValueInt, Int: 10}, Value: money("USD", 2)},
+		}}},
+	}))
+}
+
+// unlink drops the IR links UnmarshalMethodIR adds, so bodies can be
+// compared with ones built by hand.
+func unlink(body *MethodBody) {
+	for i := range body.Fields {
+		body.Fields[i].Field = nil
+		unlinkValue(&body.Fields[i].Value)
+	}
+}
+
+func unlinkValue(v *Value) {
+	if v.Message != nil {
+		unlink(v.Message)
+	}
+	for i := range v.List {
+		unlinkValue(&v.List[i])
+	}
+	for i := range v.Map {
+		unlinkValue(&v.Map[i].Value)
+	}
+}
+
+func checkGolden(t *testing.T, name string, got []byte) {
+	t.Helper()
+
+	path := filepath.Join("testdata", name)
+	if *update {
+		if err := os.WriteFile(path, got, 0644); err != nil {
+			t.Fatalf("failed to update %v: %v", path, err)
+		}
+	}
+	want, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("failed to read golden file: %v", err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Errorf("%v changed, the format is pinned:\n got %x\nwant %x", path, got, want)
+	}
+}
+
+func TestMethodIRGolden(t *testing.T) {
+	schema := parseSchema(t, map[string]string{"schema.proto": methodSchema}).Body
+
+	data, err := MarshalMethodIR(goldenOrder())
+	if err != nil {
+		t.Fatalf("MarshalMethodIR failed: %v", err)
+	}
+	checkGolden(t, "method_insert.golden", data)
+
+	for _, s := range []*GenBody{schema, nil} {
+		decoded, err := UnmarshalMethodIR(s, data)
+		if err != nil {
+			t.Fatalf("UnmarshalMethodIR failed: %v", err)
+		}
+		if s != nil && decoded.Body.Get(10).Field != fieldByNumber(schema.Message("shop.Order"), 10) {
+			t.Errorf("total not linked to the schema")
+		}
+		unlink(decoded.Body)
+		if !reflect.DeepEqual(decoded, goldenOrder()) {
+			t.Errorf("decoded = %+v\nwant %+v", decoded.Body, goldenOrder().Body)
+		}
+	}
+}
+
+func TestMethodIRFromOperate(t *testing.T) {
+	gen, parser := parseSchemaParser(t, map[string]string{"schema.proto": methodSchema})
+	schema := gen.Body
+	rpc, req := orderRequest(t, schema, parser)
+
+	fields := req.Descriptor().Fields()
+	req.Set(fields.ByName("id"), protoreflect.ValueOfInt64(9))
+	req.Mutable(fields.ByName("counts")).Map().Set(protoreflect.ValueOfString("k").MapKey(), protoreflect.ValueOfInt32(4))
+	req.Mutable(fields.ByName("counts")).Map().Set(protoreflect.ValueOfString("a").MapKey(), protoreflect.ValueOfInt32(5))
+	total := req.Mutable(fields.ByName("total")).Message()
+	total.Set(total.Descriptor().Fields().ByName("units"), protoreflect.ValueOfInt64(3))
+
+	ir := rpc.Operate(req)
+	data, err := MarshalMethodIR(&ir)
+	if err != nil {
+		t.Fatalf("MarshalMethodIR failed: %v", err)
+	}
+	decoded, err := UnmarshalMethodIR(schema, data)
+	if err != nil {
+		t.Fatalf("UnmarshalMethodIR failed: %v", err)
+	}
+	if !reflect.DeepEqual(decoded, &ir) {
+		t.Errorf("decoded = %+v\nwant %+v", decoded.Body, ir.Body)
+	}
+
+	// map entries are kept in key order, so a call always has one encoding
+	counts := decoded.Body.Lookup("counts").Value.Map
+	if len(counts) != 2 || counts[0].Key.String != "a" || counts[1].Key.String != "k" {
+		t.Errorf("counts = %+v", counts)
+	}
+}
+
+func TestMethodIRRenamedFields(t *testing.T) {
+	schema := parseSchema(t, map[string]string{"schema.proto": methodSchema}).Body
+	data, err := MarshalMethodIR(goldenOrder())
+	if err != nil {
+		t.Fatalf("MarshalMethodIR failed: %v", err)
+	}
+
+	// the next schema renames note and no longer declares blob
+	order := schema.Message("shop.Order")
+	fieldByNumber(order, 7).Name = "comment"
+	for i, f := range order.Fields {
+		if f.Number == 8 {
+			order.Fields = append(order.Fields[:i], order.Fields[i+1:]...)
+			break
+		}
+	}
+
+	decoded, err := UnmarshalMethodIR(schema, data)
+	if err != nil {
+		t.Fatalf("UnmarshalMethodIR failed: %v", err)
+	}
+	if fv := decoded.Body.Lookup("comment"); fv == nil || fv.Value.String != "leave at door" {
+		t.Errorf("renamed field = %+v", fv)
+	}
+	if fv := decoded.Body.Get(8); fv == nil || fv.Field != nil || !bytes.Equal(fv.Value.Bytes, []byte{0, 1, 2}) {
+		t.Errorf("undeclared field = %+v, want it kept unlinked", fv)
+	}
+}
+
+func TestMethodIRRejectsBadInput(t *testing.T) {
+	schema := parseSchema(t, map[string]string{"schema.proto": methodSchema}).Body
+
+	data, err := MarshalMethodIR(goldenOrder())
+	if err != nil {
+		t.Fatalf("MarshalMethodIR failed: %v", err)
+	}
+
+	if _, err := UnmarshalMethodIR(schema, []byte("SGIR....")); err != ErrNotMethodIR {
+		t.Errorf("wrong magic: err = %v", err)
+	}
+
+	major := append([]byte{}, data...)
+	major[4] = STORPC_VERSION_MAJOR + 1
+	var version *VersionError
+	if _, err := UnmarshalMethodIR(schema, major); !errors.As(err, &version) || version.Major != STORPC_VERSION_MAJOR+1 {
+		t.Errorf("other major version: err = %v", err)
+	}
+
+	var checksum *ChecksumError
+	if _, err := UnmarshalMethodIR(schema, data[:len(data)-3]); !errors.As(err, &checksum) {
+		t.Errorf("truncated frame: err = %v", err)
+	}
+	if _, err := UnmarshalMethodIR(schema, data[:10]); err != ErrTruncated {
+		t.Errorf("truncated header: err = %v", err)
+	}
+	for _, at := range []int{7, 12, 20, len(data) - 1} {
+		flipped := append([]byte{}, data...)
+		flipped[at] ^= 0x10
+		if _, err := UnmarshalMethodIR(schema, flipped); !errors.As(err, &checksum) {
+			t.Errorf("byte %d flipped: err = %v", at, err)
+		}
+	}
+
+	wrong := goldenOrder()
+	wrong.Body.Fields[1].Value = Value{Kind: ValueString, String: "three"}
+	data, err = MarshalMethodIR(wrong)
+	if err != nil {
+		t.Fatalf("MarshalMethodIR failed: %v", err)
+	}
+	if _, err := UnmarshalMethodIR(schema, data); err == nil {
+		t.Error("string read as uint32")
+	}
+	if _, err := UnmarshalMethodIR(nil, data); err != nil {
+		t.Errorf("frame not readable without a schema: %v", err)
+	}
+
+	wrong.Body.Fields[1].Value = Value{}
+	if _, err := MarshalMethodIR(wrong); err == nil {
+		t.Error("unset value written")
+	}
+}
+
+func TestMethodBodyFieldOrder(t *testing.T) {
+	schema := parseSchema(t, map[string]string{"schema.proto": methodSchema}).Body
+
+	note := Value{Kind: ValueString, String: "fragile"}
+	body := NewMethodBody("shop.Order", []FieldValue{
+		{Number: 7, Value: note},
+		{Number: 1, Value: Value{Kind: ValueInt, Int: 5}},
+		{Number: 2, Value: Value{Kind: ValueUint, Uint: 1}},
+	})
+	if f := body.Get(7); f == nil || f.Value.String != "fragile" {
+		t.Errorf("Get(7) = %v on a body built out of order", f)
+	}
+
+	// frames written by other encoders need not be in order
+	unsorted := &MethodIR{Header: NewMethodHeader(OpInsert), Body: &MethodBody{Type: "shop.Order", Fields: []FieldValue{
+		{Number: 7, Value: note},
+		{Number: 1, Value: Value{Kind: ValueInt, Int: 5}},
+	}}}
+	data, err := MarshalMethodIR(unsorted)
+	if err != nil {
+		t.Fatalf("MarshalMethodIR failed: %v", err)
+	}
+	ir, err := UnmarshalMethodIR(schema, data)
+	if err != nil {
+		t.Fatalf("UnmarshalMethodIR failed: %v", err)
+	}
+	if f := ir.Body.Get(1); f == nil || f.Value.Int != 5 {
+		t.Errorf("Get(1) = %v after decoding", f)
+	}
+	if f := ir.Body.Get(7); f == nil || f.Field == nil || f.Field.Name != "note" {
+		t.Errorf("Get(7) = %v after decoding", f)
+	}
+
+	unsorted.Body.Fields = append(unsorted.Body.Fields, FieldValue{Number: 7, Value: note})
+	data, err = MarshalMethodIR(unsorted)
+	if err != nil {
+		t.Fatalf("MarshalMethodIR failed: %v", err)
+	}
+	if _, err := UnmarshalMethodIR(schema, data); err == nil {
+		t.Error("field set twice accepted")
+	}
+}
+
+func TestMethodIRFeatures(t *testing.T) {
+	schema := parseSchema(t, map[string]string{"schema.proto": methodSchema}).Body
+
+	// a newer minor version using an optional feature this one lacks
+	newer := goldenOrder()
+	newer.Header.VersionMinor = STORPC_VERSION_MINOR + 1
+	newer.Header.Features |= 0x0040
+	data, err := MarshalMethodIR(newer)
+	if err != nil {
+		t.Fatalf("MarshalMethodIR failed: %v", err)
+	}
+	decoded, err := UnmarshalMethodIR(schema, data)
+	if err != nil {
+		t.Fatalf("unknown optional feature rejected: %v", err)
+	}
+	if decoded.Header.Features != 0x0040 {
+		t.Errorf("features = %#x", decoded.Header.Features)
+	}
+
+	newer.Header.Features |= 0x0100
+	data, err = MarshalMethodIR(newer)
+	if err != nil {
+		t.Fatalf("MarshalMethodIR failed: %v", err)
+	}
+	var feature *FeatureError
+	if _, err := UnmarshalMethodIR(schema, data); !errors.As(err, &feature) || feature.Features != 0x0100 {
+		t.Errorf("unknown required feature: err = %v", err)
+	}
+
+	// the checksum covers the features, flipping one cannot skip it
+	data, err = MarshalMethodIR(goldenOrder())
+	if err != nil {
+		t.Fatalf("MarshalMethodIR failed: %v", err)
+	}
+	for _, at := range []int{12, 13} {
+		for bit := 0; bit < 8; bit++ {
+			flipped := append([]byte{}, data...)
+			flipped[at] ^= 1 << bit
+			var checksum *ChecksumError
+			if _, err := UnmarshalMethodIR(schema, flipped); !errors.As(err, &checksum) {
+				t.Errorf("features byte %d bit %d flipped: err = %v", at, bit, err)
+			}
+		}
+	}
+
+	if got := NegotiateFeatures(0xFFFF); got != KnownFeatures {
+		t.Errorf("NegotiateFeatures = %#x, want %#x", got, KnownFeatures)
+	}
+}
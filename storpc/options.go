@@ -0,0 +1,148 @@
+package storpc
+
+import (
+	"context"
+	_ "embed"
+	"io"
+	"strings"
+	"sync"
+
+	"github.com/bufbuild/protocompile"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+	"google.golang.org/protobuf/types/dynamicpb"
+)
+
+// import path of the storpc options in user schemas
+const OptionsFile = "storpc/options.proto"
+
+//go:embed options.proto
+var optionsSource string
+
+var (
+	optionsOnce  sync.Once
+	optionsDesc  protoreflect.FileDescriptor
+	optionsTypes *protoregistry.Types
+)
+
+// storpc.Op enum numbers mapped to operations
+var protoOps = map[protoreflect.EnumNumber]uint8{
+	1: OpInsert,
+	2: OpGet,
+	3: OpUpdate,
+	4: OpDelete,
+	5: OpList,
+}
+
+// optionsResolver serves the embedded options file so schemas can import it
+// without having it on their import path.
+func optionsResolver() protocompile.Resolver {
+	return &protocompile.SourceResolver{
+		Accessor: func(path string) (io.ReadCloser, error) {
+			if path != OptionsFile {
+				return nil, protoregistry.NotFound
+			}
+			return io.NopCloser(strings.NewReader(optionsSource)), nil
+		},
+	}
+}
+
+// storpcOptions compiles the embedded options file once and returns its
+// descriptor together with the extension types it declares.
+func storpcOptions() (protoreflect.FileDescriptor, *protoregistry.Types) {
+	optionsOnce.Do(func() {
+		compiler := protocompile.Compiler{
+			Resolver: protocompile.WithStandardImports(optionsResolver()),
+		}
+		files, err := compiler.Compile(context.Background(), OptionsFile)
+		if err != nil {
+			panic("storpc: embedded options.proto does not compile: " + err.Error())
+		}
+
+		// rebuilt through protodesc so the descriptor implements the full
+		// protoreflect API of the protobuf version in use
+		fdp := protodesc.ToFileDescriptorProto(files[0])
+		optionsDesc, err = protodesc.NewFile(fdp, protoregistry.GlobalFiles)
+		if err != nil {
+			panic("storpc: " + err.Error())
+		}
+
+		optionsTypes = &protoregistry.Types{}
+		extensions := optionsDesc.Extensions()
+		for i := 0; i < extensions.Len(); i++ {
+			xt := dynamicpb.NewExtensionType(extensions.Get(i))
+			if err := optionsTypes.RegisterExtension(xt); err != nil {
+				panic("storpc: " + err.Error())
+			}
+		}
+	})
+
+	return optionsDesc, optionsTypes
+}
+
+// optionValue reads a storpc extension out of a descriptor's options. The
+// options are re-decoded against the storpc extension types, as descriptors
+// loaded from a set only carry them as unknown fields.
+func optionValue(options proto.Message, name protoreflect.FullName) (protoreflect.Value, bool) {
+	if options == nil || !options.ProtoReflect().IsValid() {
+		return protoreflect.Value{}, false
+	}
+
+	_, types := storpcOptions()
+	xt, err := types.FindExtensionByName(name)
+	if err != nil {
+		return protoreflect.Value{}, false
+	}
+
+	data, err := proto.Marshal(options)
+	if err != nil {
+		return protoreflect.Value{}, false
+	}
+	decoded := options.ProtoReflect().Type().New().Interface()
+	if err := (proto.UnmarshalOptions{Resolver: types}).Unmarshal(data, decoded); err != nil {
+		return protoreflect.Value{}, false
+	}
+
+	if !proto.HasExtension(decoded, xt) {
+		return protoreflect.Value{}, false
+	}
+	return decoded.ProtoReflect().Get(xt.TypeDescriptor()), true
+}
+
+func boolOption(options proto.Message, name protoreflect.FullName) bool {
+	v, ok := optionValue(options, name)
+	return ok && v.Bool()
+}
+
+func stringOption(options proto.Message, name protoreflect.FullName) string {
+	v, ok := optionValue(options, name)
+	if !ok {
+		return ""
+	}
+	return v.String()
+}
+
+func stringsOption(options proto.Message, name protoreflect.FullName) []string {
+	v, ok := optionValue(options, name)
+	if !ok {
+		return nil
+	}
+	list := v.List()
+	out := make([]string, 0, list.Len())
+	for i := 0; i < list.Len(); i++ {
+		out = append(out, list.Get(i).String())
+	}
+	return out
+}
+
+// methodOp returns the operation declared with (storpc.op), if any.
+func methodOp(md protoreflect.MethodDescriptor) (uint8, bool) {
+	v, ok := optionValue(md.Options(), "storpc.op")
+	if !ok {
+		return 0, false
+	}
+	op, ok := protoOps[v.Enum()]
+	return op, ok
+}
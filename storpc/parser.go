@@ -1,15 +1,29 @@
 package storpc
 
 import (
-	"errors"
 	"fmt"
 	"log/slog"
 	"os"
+	"strings"
+	"unicode"
 
 	"google.golang.org/protobuf/proto"
 	"google.golang.org/protobuf/reflect/protodesc"
 	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
 	"google.golang.org/protobuf/types/descriptorpb"
+
+	// well-known types, registered so imports of them always resolve
+	_ "google.golang.org/protobuf/types/known/anypb"
+	_ "google.golang.org/protobuf/types/known/apipb"
+	_ "google.golang.org/protobuf/types/known/durationpb"
+	_ "google.golang.org/protobuf/types/known/emptypb"
+	_ "google.golang.org/protobuf/types/known/fieldmaskpb"
+	_ "google.golang.org/protobuf/types/known/sourcecontextpb"
+	_ "google.golang.org/protobuf/types/known/structpb"
+	_ "google.golang.org/protobuf/types/known/timestamppb"
+	_ "google.golang.org/protobuf/types/known/typepb"
+	_ "google.golang.org/protobuf/types/known/wrapperspb"
 )
 
 type ParseArgs string
@@ -20,38 +34,54 @@ const (
 	Input   ParseArgs = "--input"
 	Case    ParseArgs = "--case"
 	Group   ParseArgs = "--group"
+	Root    ParseArgs = "--root"
+	Import  ParseArgs = "--proto_path"
+
+	LogFormat ParseArgs = "--log_format"
+	LogLevel  ParseArgs = "--log_level"
 )
 
 type ProtoParser struct {
-	fileDesc protoreflect.FileDescriptor
+	wordCase string // normalised options.WordCase
+	files    *protoregistry.Files
+	ordered  []protoreflect.FileDescriptor // every file, dependencies first
+	roots    []protoreflect.FileDescriptor // files whose services are hosted
 	options  *ProtoParserOptions
 	logger   *slog.Logger
+
+	diagnostics Diagnostics // problems found by the last parse
 }
 
 func NewProtoParser(options *ProtoParserOptions) *ProtoParser {
-	var level slog.Level
-
-	switch {
-	case options.Verbose:
-		level = slog.LevelDebug
-	case options.Quiet:
-		level = slog.LevelWarn
-	default:
-		level = slog.LevelInfo
+	config := options.Log
+	if config == nil {
+		config = &LogConfig{Level: options.level()}
 	}
 
-	handler := slog.NewTextHandler(os.Stdout, &slog.HandlerOptions{Level: level})
-	logger := slog.New(handler)
-
 	parser := &ProtoParser{
 		options: options,
-		logger:  logger,
+		logger:  config.For(SubsystemParser),
 	}
 
 	return parser
 }
 
 func (p *ProtoParser) Parse() (*GenIR, error) {
+	p.diagnostics = nil
+	inputs := p.options.inputs()
+	if isSourceInput(inputs) {
+		set, names, err := p.compileSources(inputs)
+		if err != nil {
+			return nil, err
+		}
+
+		roots := p.options.Roots
+		if len(roots) == 0 {
+			roots = names
+		}
+		return p.parseSet(set, roots)
+	}
+
 	p.logger.Debug(fmt.Sprintf("parsing filepath : %v", p.options.Filepath))
 	data, err := os.ReadFile(p.options.Filepath)
 	if err != nil {
@@ -63,19 +93,34 @@ func (p *ProtoParser) Parse() (*GenIR, error) {
 		return nil, err
 	}
 
-	fd, err := protodesc.NewFile(set.File[0], nil)
+	return p.ParseSet(set)
+}
+
+func (p *ProtoParser) ParseSet(set *descriptorpb.FileDescriptorSet) (*GenIR, error) {
+	p.diagnostics = nil
+	return p.parseSet(set, p.options.Roots)
+}
+
+func (p *ProtoParser) parseSet(set *descriptorpb.FileDescriptorSet, roots []string) (*GenIR, error) {
+	if len(set.GetFile()) == 0 {
+		p.report(SeverityError, CodeDescriptorSet, "", "descriptor set contains no files")
+		return nil, p.failed()
+	}
+
+	wordCase, err := NormaliseCase(p.options.WordCase)
 	if err != nil {
 		return nil, err
 	}
+	p.wordCase = wordCase
 
-	p.fileDesc = fd
-
-	if string(p.fileDesc.Package()) == "" {
-		p.options.KeyGroup = string(p.fileDesc.Package())
+	p.loadFiles(set)
+	p.selectRoots(set, roots)
+	if err := p.failed(); err != nil {
+		return nil, err
 	}
 
-	err = p.filterServices()
-	if err != nil {
+	p.filterServices()
+	if err := p.failed(); err != nil {
 		return nil, err
 	}
 
@@ -84,99 +129,628 @@ func (p *ProtoParser) Parse() (*GenIR, error) {
 	return gen, nil
 }
 
+func (p *ProtoParser) Files() *protoregistry.Files {
+	return p.files
+}
+
+func (p *ProtoParser) Roots() []protoreflect.FileDescriptor {
+	return p.roots
+}
+
+// loadFiles registers every file of the set in dependency order. Imports
+// missing from the set (e.g. a set built without --include_imports) fall
+// back to the well-known types and storpc options linked into the binary.
+func (p *ProtoParser) loadFiles(set *descriptorpb.FileDescriptorSet) {
+	byName := make(map[string]*descriptorpb.FileDescriptorProto, len(set.GetFile()))
+	for _, f := range set.GetFile() {
+		if _, ok := byName[f.GetName()]; ok {
+			p.report(SeverityError, CodeDescriptorSet, f.GetName(), "duplicate file in descriptor set")
+			continue
+		}
+		byName[f.GetName()] = f
+	}
+
+	p.files = &protoregistry.Files{}
+	p.ordered = nil
+
+	const (
+		visiting = 1
+		done     = 2
+		broken   = 3 // the file or one of its imports could not be loaded
+	)
+	state := make(map[string]int, len(byName))
+
+	// visit loads a file after its imports and reports whether it loaded.
+	// Every missing import is reported, not just the first.
+	var visit func(name, importedBy string) bool
+	visit = func(name, importedBy string) bool {
+		switch state[name] {
+		case done:
+			return true
+		case broken:
+			return false
+		case visiting:
+			p.report(SeverityError, CodeImport, importedBy, "import cycle detected at %q", name)
+			return false
+		}
+		state[name] = visiting
+
+		fdp, ok := byName[name]
+		if !ok && name == OptionsFile {
+			p.logger.Debug(fmt.Sprintf("resolved import %v from embedded storpc options", name))
+			state[name] = done
+			fd, _ := storpcOptions()
+			return p.register(fd)
+		}
+		if !ok && strings.HasPrefix(name, "google/protobuf/") {
+			fd, err := protoregistry.GlobalFiles.FindFileByPath(name)
+			if err != nil {
+				p.report(SeverityError, CodeImport, importedBy, "import %q not found in descriptor set", name)
+				state[name] = broken
+				return false
+			}
+			p.logger.Debug(fmt.Sprintf("resolved import %v from well-known types", name))
+			state[name] = done
+			return p.register(fd)
+		}
+		if !ok {
+			p.report(SeverityError, CodeImport, importedBy, "import %q not found in descriptor set", name)
+			state[name] = broken
+			return false
+		}
+
+		loaded := true
+		for _, dep := range fdp.GetDependency() {
+			if !visit(dep, name) {
+				loaded = false
+			}
+		}
+		if !loaded {
+			state[name] = broken
+			return false
+		}
+
+		fd, err := protodesc.NewFile(fdp, p.files)
+		if err != nil {
+			p.report(SeverityError, CodeDescriptorSet, name, "%v", err)
+			state[name] = broken
+			return false
+		}
+		state[name] = done
+		return p.register(fd)
+	}
+
+	for _, f := range set.GetFile() {
+		visit(f.GetName(), "")
+	}
+}
+
+// external reports whether fd is a well-known types file or the storpc
+// options that the schema only imports. Well-known types are column types
+// and the options describe the schema, so neither is part of the body.
+func (p *ProtoParser) external(fd protoreflect.FileDescriptor) bool {
+	if fd.Path() != OptionsFile && !strings.HasPrefix(fd.Path(), "google/protobuf/") {
+		return false
+	}
+	for _, root := range p.roots {
+		if root.Path() == fd.Path() {
+			return false
+		}
+	}
+	return true
+}
+
+func (p *ProtoParser) register(fd protoreflect.FileDescriptor) bool {
+	if err := p.files.RegisterFile(fd); err != nil {
+		p.report(SeverityError, CodeDescriptorSet, fd.Path(), "%v", err)
+		return false
+	}
+	p.ordered = append(p.ordered, fd)
+	return true
+}
+
+// selectRoots picks the files whose services are hosted. Without explicit
+// roots every file of the set that no other file imports is a root.
+func (p *ProtoParser) selectRoots(set *descriptorpb.FileDescriptorSet, roots []string) {
+	p.roots = nil
+
+	if len(roots) > 0 {
+		for _, name := range roots {
+			fd, err := p.files.FindFileByPath(name)
+			if err != nil {
+				p.report(SeverityError, CodeRoot, name, "root not found in descriptor set")
+				continue
+			}
+			p.roots = append(p.roots, fd)
+		}
+		return
+	}
+
+	imported := make(map[string]bool)
+	for _, f := range set.GetFile() {
+		for _, dep := range f.GetDependency() {
+			imported[dep] = true
+		}
+	}
+
+	for _, f := range set.GetFile() {
+		if imported[f.GetName()] {
+			continue
+		}
+		// files that failed to load are already reported
+		if fd, err := p.files.FindFileByPath(f.GetName()); err == nil {
+			p.roots = append(p.roots, fd)
+		}
+	}
+
+	if len(p.roots) == 0 && !p.diagnostics.HasErrors() {
+		p.report(SeverityError, CodeRoot, "", "descriptor set has no root files")
+	}
+}
+
 func (p *ProtoParser) ParseHeader() *GenHeader {
-	messages := p.fileDesc.Messages()
-	enums := p.fileDesc.Messages()
+	var numMessages, numEnums int
+	for _, fd := range p.ordered {
+		if p.external(fd) {
+			continue
+		}
+		numMessages += countMessages(fd.Messages())
+		numEnums += countEnums(fd.Enums(), fd.Messages())
+	}
+
+	return NewGenHeader(uint32(numMessages), uint32(numEnums))
+}
 
-	return NewGenHeader(uint32(messages.Len()), uint32(enums.Len()))
+func countMessages(messages protoreflect.MessageDescriptors) int {
+	n := messages.Len()
+	for i := 0; i < messages.Len(); i++ {
+		n += countMessages(messages.Get(i).Messages())
+	}
+	return n
+}
+
+func countEnums(enums protoreflect.EnumDescriptors, messages protoreflect.MessageDescriptors) int {
+	n := enums.Len()
+	for i := 0; i < messages.Len(); i++ {
+		message := messages.Get(i)
+		n += countEnums(message.Enums(), message.Messages())
+	}
+	return n
 }
 
 func (p *ProtoParser) ParseBody() *GenBody {
-	filed := p.fileDesc
 	body := NewGenBody()
 
-	if filed.Package().IsValid() {
-		body.Group = string(filed.Package())
+	if root := p.roots[0]; root.Package().IsValid() {
+		body.Group = string(root.Package())
+	}
+	body.KeyGroup = p.options.KeyGroup
+	if body.KeyGroup == "" {
+		body.KeyGroup = body.Group
 	}
 
-	messages := filed.Messages()
+	for _, filed := range p.ordered {
+		body.Files = append(body.Files, filed.Path())
+		if p.external(filed) {
+			continue
+		}
 
-	for i := 0; i < messages.Len(); i++ {
-		message := messages.Get(i)
-		body.Messages = append(body.Messages, p.ParseMessage(message))
+		messages := filed.Messages()
+
+		body.Messages = append(body.Messages, p.parseMessages(messages)...)
+		body.Enums = append(body.Enums, p.parseEnums(filed.Enums(), messages)...)
+	}
+
+	body.Reindex()
+
+	for _, root := range p.roots {
+		body.Roots = append(body.Roots, root.Path())
+
+		services := root.Services()
+		for i := 0; i < services.Len(); i++ {
+			body.Services = append(body.Services, p.ParseService(services.Get(i), body))
+		}
 	}
 
 	return body
 }
 
+// parseMessages collects the given messages and every message declared inside
+// them, depth first. Each message is parsed once no matter how many fields
+// refer to it, so recursive and shared types need no special casing.
+func (p *ProtoParser) parseMessages(messages protoreflect.MessageDescriptors) []Message {
+	var out []Message
+	for i := 0; i < messages.Len(); i++ {
+		message := messages.Get(i)
+		out = append(out, p.ParseMessage(message))
+		out = append(out, p.parseMessages(message.Messages())...)
+	}
+	return out
+}
+
 func (p *ProtoParser) ParseMessage(message protoreflect.MessageDescriptor) Message {
 	serialisedMessage := Message{
-		Name: string(message.FullName()),
+		Name:       string(message.FullName()),
+		CaseName:   p.caseName(relativeName(message)),
+		MapEntry:   message.IsMapEntry(),
+		Table:      stringOption(message.Options(), "storpc.table"),
+		LintIgnore: stringsOption(message.Options(), "storpc.lint_ignore"),
+		Comments:   commentsOf(message),
+		Location:   locationOf(message),
 	}
+	serialisedMessage.CaseTable = p.caseName(serialisedMessage.Table)
 
 	fields := message.Fields()
 	for j := 0; j < fields.Len(); j++ {
-		field := fields.Get(j)
+		serialisedMessage.Fields = append(serialisedMessage.Fields, p.parseField(fields.Get(j)))
+	}
+
+	oneofs := message.Oneofs()
+	for j := 0; j < oneofs.Len(); j++ {
+		oneof := oneofs.Get(j)
+		if oneof.IsSynthetic() {
+			continue
+		}
 
-		var typeName string
-		kind := field.Kind()
+		serialisedOneof := Oneof{Name: string(oneof.Name())}
+		members := oneof.Fields()
+		for k := 0; k < members.Len(); k++ {
+			serialisedOneof.Fields = append(serialisedOneof.Fields, int32(members.Get(k).Number()))
+		}
+		serialisedMessage.Oneofs = append(serialisedMessage.Oneofs, serialisedOneof)
+	}
+
+	return serialisedMessage
+}
+
+func (p *ProtoParser) parseField(field protoreflect.FieldDescriptor) Field {
+	var typeName string
+	var typeKind uint8
+	kind := field.Kind()
+
+	switch kind {
+	case protoreflect.MessageKind, protoreflect.GroupKind:
+		typeName = string(field.Message().FullName())
+		typeKind = KindMessage
+	case protoreflect.EnumKind:
+		typeName = string(field.Enum().FullName())
+		typeKind = KindEnum
+	default:
+		typeName = kind.String()
+		typeKind = KindScalar
+	}
+
+	serialisedField := Field{
+		Type:           typeName,
+		Kind:           typeKind,
+		Logical:        LogicalOf(typeName),
+		Name:           field.TextName(),
+		CaseName:       p.caseName(field.TextName()),
+		Number:         int32(field.Number()),
+		Key:            boolOption(field.Options(), "storpc.key"),
+		Index:          boolOption(field.Options(), "storpc.index"),
+		Unique:         boolOption(field.Options(), "storpc.unique"),
+		HasPresence:    field.HasPresence(),
+		Proto3Optional: field.HasOptionalKeyword() && field.Syntax() == protoreflect.Proto3,
+		Packed:         field.IsPacked(),
+		Delimited:      kind == protoreflect.GroupKind,
+		Comments:       commentsOf(field),
+	}
+
+	if field.HasDefault() {
+		serialisedField.HasDefault = true
+		serialisedField.Default = protodesc.ToFieldDescriptorProto(field).GetDefaultValue()
+	}
+
+	// editions resolve LEGACY_REQUIRED presence to the required cardinality
+	switch field.Cardinality() {
+	case protoreflect.Required:
+		serialisedField.Cardinality = CardinalityRequired
+		serialisedField.Presence = PresenceRequired
+	case protoreflect.Repeated:
+		serialisedField.Cardinality = CardinalityRepeated
+	default:
+		serialisedField.Cardinality = CardinalityOptional
+	}
+	if serialisedField.Presence != PresenceRequired && field.HasPresence() {
+		serialisedField.Presence = PresenceExplicit
+	}
+
+	if oneof := field.ContainingOneof(); oneof != nil && !oneof.IsSynthetic() {
+		serialisedField.Oneof = string(oneof.Name())
+	}
+
+	if field.IsMap() {
+		key := p.parseField(field.MapKey())
+		value := p.parseField(field.MapValue())
+		serialisedField.MapKey = &key
+		serialisedField.MapValue = &value
+	}
+
+	return serialisedField
+}
+
+// ParseService describes a hosted service. body must already hold the type
+// table so target tables can be resolved.
+func (p *ProtoParser) ParseService(service protoreflect.ServiceDescriptor, body *GenBody) Service {
+	serialisedService := Service{
+		Name:     string(service.FullName()),
+		File:     service.ParentFile().Path(),
+		KeyGroup: p.options.KeyGroup,
+	}
+	if serialisedService.KeyGroup == "" {
+		serialisedService.KeyGroup = string(service.ParentFile().Package())
+	}
 
-		var nestedMessage Message
-		switch kind {
-		case protoreflect.MessageKind, protoreflect.GroupKind:
-			typeName = string(field.Message().FullName())
-			nestedMessage = p.ParseMessage(field.Message())
-		case protoreflect.EnumKind:
-			typeName = string(field.Enum().FullName())
-		default:
-			typeName = kind.String()
+	methods := service.Methods()
+	for i := 0; i < methods.Len(); i++ {
+		method := methods.Get(i)
+
+		serialisedMethod := Method{
+			Name:            string(method.Name()),
+			Input:           string(method.Input().FullName()),
+			Output:          string(method.Output().FullName()),
+			Operation:       resolveOperation(method),
+			ClientStreaming: method.IsStreamingClient(),
+			ServerStreaming: method.IsStreamingServer(),
+			Comments:        commentsOf(method),
+			Location:        locationOf(method),
 		}
+		serialisedMethod.Table = resolveTable(body, serialisedMethod.Input, serialisedMethod.Output)
 
-		serialisedField := Field{
-			Type:   typeName,
-			Name:   field.TextName(),
-			Number: int32(field.Number()),
-			Nested: &nestedMessage,
+		if serialisedMethod.Operation == OpUnknown {
+			p.logger.Debug(fmt.Sprintf("cannot infer operation of %v.%v", serialisedService.Name, serialisedMethod.Name))
+			p.reportAt(method, SeverityWarning, CodeUnknownOperation, "cannot infer operation of %v.%v", serialisedService.Name, serialisedMethod.Name)
 		}
 
-		serialisedMessage.Fields = append(serialisedMessage.Fields, serialisedField)
+		serialisedService.Methods = append(serialisedService.Methods, serialisedMethod)
 	}
-	return serialisedMessage
+
+	return serialisedService
+}
+
+// method name prefixes an operation is inferred from when (storpc.op) is unset
+var opPrefixes = []struct {
+	prefix string
+	op     uint8
+}{
+	{"Insert", OpInsert}, {"Create", OpInsert}, {"Add", OpInsert}, {"Put", OpInsert},
+	{"Get", OpGet}, {"Find", OpGet}, {"Fetch", OpGet}, {"Read", OpGet}, {"Lookup", OpGet},
+	{"Update", OpUpdate}, {"Set", OpUpdate}, {"Patch", OpUpdate}, {"Modify", OpUpdate},
+	{"Delete", OpDelete}, {"Remove", OpDelete}, {"Drop", OpDelete},
+	{"List", OpList}, {"Scan", OpList},
+}
+
+// resolveOperation prefers (storpc.op), then the method name, then an Empty
+// request meaning a read.
+func resolveOperation(method protoreflect.MethodDescriptor) uint8 {
+	if op, ok := methodOp(method); ok {
+		return op
+	}
+
+	name := string(method.Name())
+	for _, candidate := range opPrefixes {
+		rest, ok := strings.CutPrefix(name, candidate.prefix)
+		if !ok {
+			continue
+		}
+		// whole words only, so Settle is not an update
+		if rest == "" || unicode.IsUpper(rune(rest[0])) || rest[0] == '_' {
+			return candidate.op
+		}
+	}
+
+	if method.Input().FullName() == "google.protobuf.Empty" {
+		return OpGet
+	}
+
+	return OpUnknown
 }
 
-func (p *ProtoParser) filterServices() error {
-	fd := p.fileDesc
+// resolveTable finds the stored message a method operates on: the request or
+// response itself, or else the first stored message either one holds.
+func resolveTable(body *GenBody, input, output string) string {
+	for _, name := range []string{input, output} {
+		if m := body.Message(name); m != nil && m.Table != "" {
+			return m.Name
+		}
+	}
 
-	for i := 0; i < fd.Services().Len(); i++ {
-		svc := fd.Services().Get(i)
-		for j := 0; j < svc.Methods().Len(); j++ {
-			m := svc.Methods().Get(j)
-			if m.IsStreamingClient() || m.IsStreamingServer() {
-				return errors.New("streaming RPC detected: service " +
-					string(svc.FullName()) + ", method " + string(m.Name()))
+	for _, name := range []string{input, output} {
+		m := body.Message(name)
+		if m == nil {
+			continue
+		}
+		for _, f := range m.Fields {
+			if nested := body.MessageOf(&f); nested != nil && nested.Table != "" {
+				return nested.Name
 			}
 		}
 	}
 
-	return nil
+	return ""
+}
+
+// parseEnums collects the given enums and every enum nested in the messages,
+// depth first.
+func (p *ProtoParser) parseEnums(enums protoreflect.EnumDescriptors, messages protoreflect.MessageDescriptors) []Enum {
+	var out []Enum
+	for i := 0; i < enums.Len(); i++ {
+		out = append(out, p.ParseEnum(enums.Get(i)))
+	}
+	for i := 0; i < messages.Len(); i++ {
+		message := messages.Get(i)
+		out = append(out, p.parseEnums(message.Enums(), message.Messages())...)
+	}
+	return out
+}
+
+func (p *ProtoParser) ParseEnum(enum protoreflect.EnumDescriptor) Enum {
+	serialisedEnum := Enum{
+		Name:     string(enum.FullName()),
+		CaseName: p.caseName(relativeName(enum)),
+		Closed:   enum.IsClosed(),
+		Comments: commentsOf(enum),
+	}
+
+	first := make(map[protoreflect.EnumNumber]string)
+	values := enum.Values()
+	for i := 0; i < values.Len(); i++ {
+		value := values.Get(i)
+
+		serialisedValue := EnumValue{
+			Name:  string(value.Name()),
+			Value: int32(value.Number()),
+		}
+		if name, ok := first[value.Number()]; ok {
+			serialisedValue.AliasOf = name
+		} else {
+			first[value.Number()] = serialisedValue.Name
+		}
+
+		serialisedEnum.Values = append(serialisedEnum.Values, serialisedValue)
+	}
+
+	ranges := enum.ReservedRanges()
+	for i := 0; i < ranges.Len(); i++ {
+		r := ranges.Get(i)
+		serialisedEnum.ReservedRanges = append(serialisedEnum.ReservedRanges, EnumRange{
+			Start: int32(r[0]),
+			End:   int32(r[1]),
+		})
+	}
+
+	names := enum.ReservedNames()
+	for i := 0; i < names.Len(); i++ {
+		serialisedEnum.ReservedNames = append(serialisedEnum.ReservedNames, string(names.Get(i)))
+	}
+
+	return serialisedEnum
+}
+
+// caseName applies the configured word case. The case was validated when
+// parsing started, so conversion cannot fail here.
+func (p *ProtoParser) caseName(name string) string {
+	cased, _ := ToCase(name, p.wordCase)
+	return cased
+}
+
+// relativeName is a declaration's full name without the package, so nested
+// types keep their parents: LoginRequest.Asset.
+func relativeName(desc protoreflect.Descriptor) string {
+	name := string(desc.FullName())
+	if pkg := string(desc.ParentFile().Package()); pkg != "" {
+		name = strings.TrimPrefix(name, pkg+".")
+	}
+	return name
+}
+
+// commentsOf reads the comments of desc from the SourceCodeInfo of its file.
+func commentsOf(desc protoreflect.Descriptor) Comments {
+	loc := desc.ParentFile().SourceLocations().ByDescriptor(desc)
+	comments := Comments{
+		Leading:  cleanComment(loc.LeadingComments),
+		Trailing: cleanComment(loc.TrailingComments),
+	}
+	for _, detached := range loc.LeadingDetachedComments {
+		comments.Detached = append(comments.Detached, cleanComment(detached))
+	}
+	return comments
+}
+
+// locationOf finds the declaration of desc through the SourceCodeInfo of
+// its file.
+func locationOf(desc protoreflect.Descriptor) Location {
+	file := desc.ParentFile()
+	location := Location{File: file.Path()}
+
+	loc := file.SourceLocations().ByDescriptor(desc)
+	if loc.StartLine != 0 || loc.StartColumn != 0 || loc.EndLine != 0 {
+		location.Line = loc.StartLine + 1
+		location.Column = loc.StartColumn + 1
+	}
+	return location
+}
+
+// cleanComment drops the space protoc keeps after each // and the blank
+// lines around the comment.
+func cleanComment(comment string) string {
+	lines := strings.Split(comment, "\n")
+	for i, line := range lines {
+		lines[i] = strings.TrimRight(strings.TrimPrefix(line, " "), " \t")
+	}
+	return strings.Trim(strings.Join(lines, "\n"), "\n")
+}
+
+func (p *ProtoParser) filterServices() {
+	if p.options.KeepStreaming {
+		return
+	}
+	for _, fd := range p.roots {
+		for i := 0; i < fd.Services().Len(); i++ {
+			svc := fd.Services().Get(i)
+			for j := 0; j < svc.Methods().Len(); j++ {
+				m := svc.Methods().Get(j)
+				if m.IsStreamingClient() || m.IsStreamingServer() {
+					p.reportAt(m, SeverityError, CodeStreamingRPC, "streaming RPC detected: service %v, method %v", svc.FullName(), m.Name())
+				}
+			}
+		}
+	}
 }
 
 type ProtoParserOptions struct {
-	Filepath string
-	WordCase string //camel, snake, etc.
-	Verbose  bool
-	Quiet    bool
-	KeyGroup string //grouping for structs
+	Filepath      string   //descriptor set, or comma separated .proto files
+	Inputs        []string //.proto files, overrides Filepath
+	ImportPaths   []string //where .proto imports are searched, the inputs' directories if empty
+	WordCase      string   //camel, pascal, snake, kebab or screaming-snake
+	Verbose       bool
+	Quiet         bool
+	KeyGroup      string     //key group the tables are stored under, the package of each service if empty
+	Roots         []string   //files whose services are hosted, all leaf files if empty
+	Log           *LogConfig //overrides Verbose and Quiet when set
+	KeepStreaming bool       //streaming methods are kept in the IR instead of failing the parse
 }
 
 func NewProtoParserOptions(args map[ParseArgs]string) *ProtoParserOptions {
 	return &ProtoParserOptions{
-		Filepath: args[Input],
-		Verbose:  args[Verbose] != "",
-		Quiet:    args[Quiet] != "",
-		KeyGroup: args[Group],
-		WordCase: args[Case],
+		Filepath:    args[Input],
+		Inputs:      splitArg(args[Input]),
+		ImportPaths: splitArg(args[Import]),
+		Verbose:     args[Verbose] != "",
+		Quiet:       args[Quiet] != "",
+		KeyGroup:    args[Group],
+		WordCase:    args[Case],
+		Roots:       splitArg(args[Root]),
+	}
+}
+
+// level is the log level the verbosity flags ask for.
+func (o *ProtoParserOptions) level() slog.Level {
+	switch {
+	case o.Verbose:
+		return slog.LevelDebug
+	case o.Quiet:
+		return slog.LevelWarn
+	}
+	return slog.LevelInfo
+}
+
+func (o *ProtoParserOptions) inputs() []string {
+	if len(o.Inputs) > 0 {
+		return o.Inputs
+	}
+	return splitArg(o.Filepath)
+}
+
+func splitArg(arg string) []string {
+	var out []string
+	for _, v := range strings.Split(arg, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			out = append(out, v)
+		}
 	}
+	return out
 }
 
 /*
@@ -1,9 +1,14 @@
 package storpc
 
 import (
-	"fmt"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"maps"
 	"os"
 	"path/filepath"
+	"slices"
+	"strings"
 	"testing"
 
 	"google.golang.org/protobuf/proto"
@@ -106,10 +111,13 @@ func TestParseWithRealMessage(t *testing.T) {
 	if err != nil {
 		t.Fatalf("Parse failed: %v", err)
 	}
-	fmt.Println(*gen.Body)
-	fmt.Println(*gen.Header)
+	var dump strings.Builder
+	if err := gen.WriteText(&dump); err != nil {
+		t.Fatalf("WriteText failed: %v", err)
+	}
+	t.Log(dump.String())
 	// access the message descriptor
-	fd := parser.fileDesc
+	fd := parser.Roots()[0]
 	msgDesc := fd.Messages().Get(0) // LoginRequest
 	if string(msgDesc.Name()) != "LoginRequest" {
 		t.Errorf("expected message LoginRequest, got %s", msgDesc.Name())
@@ -118,8 +126,9 @@ func TestParseWithRealMessage(t *testing.T) {
 
 func TestFilterServices_Streaming(t *testing.T) {
 	fd := &descriptorpb.FileDescriptorProto{
-		Name:    proto.String("stream.proto"),
-		Package: proto.String("streampkg"),
+		Name:       proto.String("stream.proto"),
+		Package:    proto.String("streampkg"),
+		Dependency: []string{"google/protobuf/empty.proto"},
 		Service: []*descriptorpb.ServiceDescriptorProto{
 			{
 				Name: proto.String("StreamService"),
@@ -147,6 +156,141 @@ func TestFilterServices_Streaming(t *testing.T) {
 	if err == nil {
 		t.Fatalf("expected error for streaming RPC, got nil")
 	}
+	if !strings.Contains(err.Error(), "streaming RPC") {
+		t.Fatalf("expected streaming RPC error, got %v", err)
+	}
+}
+
+func writeDescriptorSet(t *testing.T, files ...*descriptorpb.FileDescriptorProto) string {
+	set := &descriptorpb.FileDescriptorSet{File: files}
+	data, err := proto.Marshal(set)
+	if err != nil {
+		t.Fatalf("failed to marshal descriptor set: %v", err)
+	}
+
+	file := filepath.Join(t.TempDir(), "fds.bin")
+	if err := os.WriteFile(file, data, 0644); err != nil {
+		t.Fatalf("failed to write temp file: %v", err)
+	}
+	return file
+}
+
+func TestParseMultiFileSet(t *testing.T) {
+	common := &descriptorpb.FileDescriptorProto{
+		Name:    proto.String("common/user.proto"),
+		Package: proto.String("common"),
+		MessageType: []*descriptorpb.DescriptorProto{
+			{
+				Name: proto.String("User"),
+				Field: []*descriptorpb.FieldDescriptorProto{
+					{
+						Name:   proto.String("id"),
+						Number: proto.Int32(1),
+						Label:  descriptorpb.FieldDescriptorProto_LABEL_OPTIONAL.Enum(),
+						Type:   descriptorpb.FieldDescriptorProto_TYPE_INT64.Enum(),
+					},
+				},
+			},
+		},
+	}
+
+	api := &descriptorpb.FileDescriptorProto{
+		Name:       proto.String("api/users.proto"),
+		Package:    proto.String("api"),
+		Dependency: []string{"common/user.proto", "google/protobuf/empty.proto"},
+		Service: []*descriptorpb.ServiceDescriptorProto{
+			{
+				Name: proto.String("Users"),
+				Method: []*descriptorpb.MethodDescriptorProto{
+					{
+						Name:       proto.String("Insert"),
+						InputType:  proto.String(".common.User"),
+						OutputType: proto.String(".google.protobuf.Empty"),
+					},
+				},
+			},
+		},
+	}
+
+	// dependents listed before their imports on purpose
+	file := writeDescriptorSet(t, api, common)
+	parser := NewProtoParser(&ProtoParserOptions{Filepath: file})
+
+	gen, err := parser.Parse()
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+
+	wantFiles := []string{"common/user.proto", "google/protobuf/empty.proto", "api/users.proto"}
+	if !slices.Equal(gen.Body.Files, wantFiles) {
+		t.Errorf("files = %v, want %v", gen.Body.Files, wantFiles)
+	}
+	if !slices.Equal(gen.Body.Roots, []string{"api/users.proto"}) {
+		t.Errorf("roots = %v", gen.Body.Roots)
+	}
+	if gen.Body.Group != "api" {
+		t.Errorf("group = %q, want api", gen.Body.Group)
+	}
+
+	var names []string
+	for _, m := range gen.Body.Messages {
+		names = append(names, m.Name)
+	}
+	// imported well-known types are column types, not messages of the schema
+	if !slices.Equal(names, []string{"common.User"}) {
+		t.Errorf("messages = %v", names)
+	}
+	if gen.Header.NumMessages != 1 {
+		t.Errorf("NumMessages = %d, want 1", gen.Header.NumMessages)
+	}
+}
+
+func TestParseExplicitRoots(t *testing.T) {
+	a := &descriptorpb.FileDescriptorProto{
+		Name:    proto.String("a.proto"),
+		Package: proto.String("a"),
+	}
+	b := &descriptorpb.FileDescriptorProto{
+		Name:       proto.String("b.proto"),
+		Package:    proto.String("b"),
+		Dependency: []string{"a.proto"},
+	}
+
+	file := writeDescriptorSet(t, a, b)
+
+	parser := NewProtoParser(&ProtoParserOptions{Filepath: file, Roots: []string{"a.proto"}})
+	gen, err := parser.Parse()
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+	if !slices.Equal(gen.Body.Roots, []string{"a.proto"}) {
+		t.Errorf("roots = %v", gen.Body.Roots)
+	}
+
+	parser = NewProtoParser(&ProtoParserOptions{Filepath: file, Roots: []string{"missing.proto"}})
+	if _, err := parser.Parse(); err == nil {
+		t.Fatalf("expected error for unknown root")
+	}
+}
+
+func TestParseMissingImport(t *testing.T) {
+	fd := &descriptorpb.FileDescriptorProto{
+		Name:       proto.String("orphan.proto"),
+		Dependency: []string{"nowhere.proto"},
+	}
+
+	parser := NewProtoParser(&ProtoParserOptions{Filepath: writeDescriptorSet(t, fd)})
+	if _, err := parser.Parse(); err == nil {
+		t.Fatalf("expected error for missing import")
+	}
+
+	// linked into the binary by grpc, but only well-known types are taken
+	// from there
+	fd.Dependency = []string{"google/rpc/status.proto"}
+	parser = NewProtoParser(&ProtoParserOptions{Filepath: writeDescriptorSet(t, fd), Quiet: true})
+	if _, err := parser.Parse(); err == nil || !strings.Contains(err.Error(), `import "google/rpc/status.proto" not found`) {
+		t.Errorf("err = %v, want import not found", err)
+	}
 }
 
 func TestNewProtoParserOptions(t *testing.T) {
@@ -157,6 +301,8 @@ func TestNewProtoParserOptions(t *testing.T) {
 		Case:    "snake",
 	}
 
+	args[Root] = "a.proto, b.proto"
+
 	opts := NewProtoParserOptions(args)
 
 	if opts.Filepath != "/tmp/file.proto" {
@@ -171,4 +317,836 @@ func TestNewProtoParserOptions(t *testing.T) {
 	if opts.WordCase != "snake" {
 		t.Errorf("WordCase mismatch")
 	}
+	if !slices.Equal(opts.Roots, []string{"a.proto", "b.proto"}) {
+		t.Errorf("Roots mismatch: %v", opts.Roots)
+	}
+}
+
+func writeProtoFiles(t *testing.T, files map[string]string) string {
+	dir := t.TempDir()
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatalf("failed to create dir: %v", err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatalf("failed to write %s: %v", name, err)
+		}
+	}
+	return dir
+}
+
+// parseSchema writes files to a temporary directory and parses all of them
+// as inputs, the directory being the import path. configure adjusts the
+// other parser options.
+func parseSchema(t *testing.T, files map[string]string, configure ...func(*ProtoParserOptions)) *GenIR {
+	t.Helper()
+	gen, _ := parseSchemaParser(t, files, configure...)
+	return gen
+}
+
+// parseSchemaParser is parseSchema also returning the parser, for the
+// descriptors of the schema.
+func parseSchemaParser(t *testing.T, files map[string]string, configure ...func(*ProtoParserOptions)) (*GenIR, *ProtoParser) {
+	t.Helper()
+
+	dir := writeProtoFiles(t, files)
+	options := &ProtoParserOptions{ImportPaths: []string{dir}, Quiet: true}
+	for _, name := range slices.Sorted(maps.Keys(files)) {
+		options.Inputs = append(options.Inputs, filepath.Join(dir, name))
+	}
+	for _, fn := range configure {
+		fn(options)
+	}
+
+	parser := NewProtoParser(options)
+	gen, err := parser.Parse()
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+	return gen, parser
+}
+
+func TestParseProtoSources(t *testing.T) {
+	dir := writeProtoFiles(t, map[string]string{
+		"common/user.proto": `syntax = "proto3";
+package common;
+
+message User {
+  int64 id = 1;
+  string name = 2;
+}
+`,
+		"api/users.proto": `syntax = "proto3";
+package api;
+
+import "common/user.proto";
+import "google/protobuf/empty.proto";
+
+service Users {
+  rpc Insert(common.User) returns (google.protobuf.Empty);
+}
+`,
+	})
+
+	opts := NewProtoParserOptions(map[ParseArgs]string{
+		Input:  filepath.Join(dir, "api/users.proto"),
+		Import: dir,
+	})
+	parser := NewProtoParser(opts)
+
+	gen, err := parser.Parse()
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+
+	if !slices.Equal(gen.Body.Roots, []string{"api/users.proto"}) {
+		t.Errorf("roots = %v", gen.Body.Roots)
+	}
+	if !slices.Contains(gen.Body.Files, "common/user.proto") {
+		t.Errorf("files = %v", gen.Body.Files)
+	}
+	if gen.Body.Group != "api" {
+		t.Errorf("group = %q, want api", gen.Body.Group)
+	}
+}
+
+func TestParseProtoSourcesDefaultImportPaths(t *testing.T) {
+	dir := writeProtoFiles(t, map[string]string{
+		"api/user.proto": `syntax = "proto3";
+package api;
+
+message User {
+  int64 id = 1;
+}
+`,
+		"api/users.proto": `syntax = "proto3";
+package api;
+
+import "user.proto";
+
+service Users {
+  rpc Get(User) returns (User);
+}
+`,
+		"admin/audit.proto": `syntax = "proto3";
+package admin;
+
+message Audit {
+  string actor = 1;
+}
+`,
+	})
+
+	gen, err := NewProtoParser(&ProtoParserOptions{
+		Inputs: []string{filepath.Join(dir, "api/users.proto"), filepath.Join(dir, "admin/audit.proto")},
+		Quiet:  true,
+	}).Parse()
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+
+	if !slices.Equal(gen.Body.Roots, []string{"users.proto", "audit.proto"}) {
+		t.Errorf("roots = %v", gen.Body.Roots)
+	}
+	if gen.Body.Message("api.User") == nil || gen.Body.Message("admin.Audit") == nil {
+		t.Errorf("messages of both input directories expected, got %d messages", len(gen.Body.Messages))
+	}
+}
+
+func TestParseProtoSourcesError(t *testing.T) {
+	dir := writeProtoFiles(t, map[string]string{
+		"bad.proto": `syntax = "proto3";
+
+message Bad {
+  unknown.Type field = 1;
+}
+`,
+	})
+
+	opts := &ProtoParserOptions{
+		Inputs:      []string{filepath.Join(dir, "bad.proto")},
+		ImportPaths: []string{dir},
+	}
+
+	_, err := NewProtoParser(opts).Parse()
+	if err == nil {
+		t.Fatalf("expected compile error")
+	}
+	if !strings.Contains(err.Error(), "bad.proto:4:3") {
+		t.Errorf("expected file:line:column in error, got %v", err)
+	}
+}
+
+func TestParseEnums(t *testing.T) {
+	dir := writeProtoFiles(t, map[string]string{
+		"shop.proto": `syntax = "proto3";
+package shop;
+
+enum Status {
+  option allow_alias = true;
+  STATUS_UNKNOWN = 0;
+  STATUS_ACTIVE = 1;
+  STATUS_LIVE = 1;
+  STATUS_CLOSED = 3;
+  reserved 5 to 7;
+  reserved "STATUS_GONE";
+}
+
+message Order {
+  enum Kind {
+    KIND_UNKNOWN = 0;
+    KIND_RETAIL = 1;
+  }
+  Kind kind = 1;
+  Status status = 2;
+}
+`,
+	})
+
+	parser := NewProtoParser(&ProtoParserOptions{
+		Inputs:      []string{filepath.Join(dir, "shop.proto")},
+		ImportPaths: []string{dir},
+	})
+	gen, err := parser.Parse()
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+
+	if gen.Header.NumEnums != 2 {
+		t.Errorf("NumEnums = %d, want 2", gen.Header.NumEnums)
+	}
+	if len(gen.Body.Enums) != 2 {
+		t.Fatalf("expected 2 enums, got %d", len(gen.Body.Enums))
+	}
+
+	status := gen.Body.Enums[0]
+	if status.Name != "shop.Status" || status.Closed {
+		t.Errorf("unexpected enum %+v", status)
+	}
+	if len(status.Values) != 4 || status.Values[2].AliasOf != "STATUS_ACTIVE" {
+		t.Errorf("unexpected values %+v", status.Values)
+	}
+	if !slices.Equal(status.ReservedRanges, []EnumRange{{Start: 5, End: 7}}) {
+		t.Errorf("reserved ranges = %v", status.ReservedRanges)
+	}
+	if !slices.Equal(status.ReservedNames, []string{"STATUS_GONE"}) {
+		t.Errorf("reserved names = %v", status.ReservedNames)
+	}
+	if ord, ok := status.Ordinal(3); !ok || ord != 2 {
+		t.Errorf("Ordinal(3) = %d, %v; want 2, true", ord, ok)
+	}
+	if status.Contains(4) || !status.Reserved(6) {
+		t.Errorf("unexpected membership for 4 or 6")
+	}
+
+	if gen.Body.Enums[1].Name != "shop.Order.Kind" {
+		t.Errorf("nested enum = %s", gen.Body.Enums[1].Name)
+	}
+
+	order := gen.Body.Messages[0]
+	for _, f := range order.Fields {
+		if enum := gen.Body.EnumOf(&f); enum == nil || enum.Name != f.Type {
+			t.Errorf("field %s does not point to its enum: %+v", f.Name, enum)
+		}
+	}
+}
+
+func TestParseFieldCardinality(t *testing.T) {
+	dir := writeProtoFiles(t, map[string]string{
+		"profile.proto": `syntax = "proto3";
+package profile;
+
+message Profile {
+  int64 id = 1;
+  repeated string tags = 2;
+  map<string, int32> scores = 3;
+  optional string nickname = 4;
+  oneof contact {
+    string email = 5;
+    string phone = 6;
+  }
+}
+`,
+	})
+
+	parser := NewProtoParser(&ProtoParserOptions{
+		Inputs:      []string{filepath.Join(dir, "profile.proto")},
+		ImportPaths: []string{dir},
+	})
+	gen, err := parser.Parse()
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+
+	msg := gen.Body.Messages[0]
+	fields := make(map[string]Field)
+	for _, f := range msg.Fields {
+		fields[f.Name] = f
+	}
+
+	if id := fields["id"]; id.Cardinality != CardinalityOptional || id.HasPresence {
+		t.Errorf("id: %+v", id)
+	}
+	if tags := fields["tags"]; !tags.IsRepeated() || tags.IsMap() {
+		t.Errorf("tags: %+v", tags)
+	}
+	scores := fields["scores"]
+	if !scores.IsMap() || scores.IsRepeated() {
+		t.Fatalf("scores: %+v", scores)
+	}
+	if scores.MapKey.Type != "string" || scores.MapValue.Type != "int32" {
+		t.Errorf("scores key/value = %s/%s", scores.MapKey.Type, scores.MapValue.Type)
+	}
+	if entry := gen.Body.MessageOf(&scores); entry == nil || !entry.MapEntry {
+		t.Errorf("scores entry not marked as map entry")
+	}
+	if nick := fields["nickname"]; !nick.Proto3Optional || !nick.HasPresence || nick.Oneof != "" {
+		t.Errorf("nickname: %+v", nick)
+	}
+	if email := fields["email"]; email.Oneof != "contact" || !email.HasPresence {
+		t.Errorf("email: %+v", email)
+	}
+
+	if len(msg.Oneofs) != 1 || msg.Oneofs[0].Name != "contact" || !slices.Equal(msg.Oneofs[0].Fields, []int32{5, 6}) {
+		t.Errorf("oneofs = %+v", msg.Oneofs)
+	}
+}
+
+func TestParseRecursiveAndSharedTypes(t *testing.T) {
+	dir := writeProtoFiles(t, map[string]string{
+		"tree.proto": `syntax = "proto3";
+package tree;
+
+message Meta {
+  string label = 1;
+}
+
+message Node {
+  int64 id = 1;
+  Node parent = 2;
+  repeated Node children = 3;
+  Meta meta = 4;
+}
+
+message Forest {
+  repeated Node roots = 1;
+  Meta meta = 2;
+}
+`,
+	})
+
+	parser := NewProtoParser(&ProtoParserOptions{
+		Inputs:      []string{filepath.Join(dir, "tree.proto")},
+		ImportPaths: []string{dir},
+	})
+	gen, err := parser.Parse()
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+
+	if len(gen.Body.Messages) != 3 || gen.Header.NumMessages != 3 {
+		t.Fatalf("expected 3 table entries, got %d (header %d)", len(gen.Body.Messages), gen.Header.NumMessages)
+	}
+
+	node := gen.Body.Message("tree.Node")
+	if node == nil {
+		t.Fatalf("tree.Node missing from type table")
+	}
+	for _, f := range node.Fields[1:3] {
+		if gen.Body.MessageOf(&f) != node {
+			t.Errorf("field %s does not refer back to tree.Node", f.Name)
+		}
+	}
+
+	forest := gen.Body.Message("tree.Forest")
+	if gen.Body.MessageOf(&forest.Fields[1]) != gen.Body.MessageOf(&node.Fields[3]) {
+		t.Errorf("shared type Meta is not a single entry")
+	}
+}
+
+const usersProto = `syntax = "proto3";
+package users;
+
+import "storpc/options.proto";
+import "google/protobuf/empty.proto";
+
+message User {
+  option (storpc.table) = "users";
+  int64 id = 1 [(storpc.key) = true];
+  string email = 2 [(storpc.index) = true, (storpc.unique) = true];
+  string name = 3;
+}
+
+message UserID {
+  int64 id = 1;
+}
+
+service Users {
+  rpc Create(User) returns (google.protobuf.Empty) {
+    option (storpc.op) = OP_INSERT;
+  }
+  rpc Fetch(UserID) returns (User) {
+    option (storpc.op) = OP_GET;
+  }
+  rpc Drop(UserID) returns (google.protobuf.Empty) {
+    option (storpc.op) = OP_DELETE;
+  }
+}
+`
+
+func TestParseLeavesOutImportedFiles(t *testing.T) {
+	gen := parseSchema(t, map[string]string{"schema.proto": `syntax = "proto3";
+package shop;
+
+import "google/protobuf/timestamp.proto";
+import "storpc/options.proto";
+
+enum Status {
+  STATUS_UNKNOWN = 0;
+}
+
+message Order {
+  option (storpc.table) = "orders";
+  int64 id = 1 [(storpc.key) = true];
+  google.protobuf.Timestamp placed = 2;
+  Status status = 3;
+}
+`})
+
+	var names []string
+	for _, m := range gen.Body.Messages {
+		names = append(names, m.Name)
+	}
+	for _, e := range gen.Body.Enums {
+		names = append(names, e.Name)
+	}
+	if !slices.Equal(names, []string{"shop.Order", "shop.Status"}) {
+		t.Errorf("body declares %v, want only the schema's own types", names)
+	}
+	if gen.Header.NumMessages != 1 || gen.Header.NumEnums != 1 {
+		t.Errorf("NumMessages = %d, NumEnums = %d, want 1 and 1", gen.Header.NumMessages, gen.Header.NumEnums)
+	}
+	if !slices.Contains(gen.Body.Files, OptionsFile) {
+		t.Errorf("files = %v, want the options listed", gen.Body.Files)
+	}
+}
+
+func TestParseStorpcOptions(t *testing.T) {
+	dir := writeProtoFiles(t, map[string]string{"users.proto": usersProto})
+
+	parser := NewProtoParser(&ProtoParserOptions{
+		Inputs:      []string{filepath.Join(dir, "users.proto")},
+		ImportPaths: []string{dir},
+	})
+	gen, err := parser.Parse()
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+
+	checkUsersOptions(t, gen)
+
+	svc := parser.Roots()[0].Services().Get(0)
+	wantOps := []uint8{OpInsert, OpGet, OpDelete}
+	for i, want := range wantOps {
+		if op, ok := methodOp(svc.Methods().Get(i)); !ok || op != want {
+			t.Errorf("method %s op = %d, %v; want %d", svc.Methods().Get(i).Name(), op, ok, want)
+		}
+	}
+
+	// a set built without --include_imports still resolves the options
+	set, _, err := parser.compileSources([]string{filepath.Join(dir, "users.proto")})
+	if err != nil {
+		t.Fatalf("compile failed: %v", err)
+	}
+	var files []*descriptorpb.FileDescriptorProto
+	for _, f := range set.File {
+		if f.GetName() == "users.proto" {
+			files = append(files, f)
+		}
+	}
+
+	gen, err = NewProtoParser(&ProtoParserOptions{Filepath: writeDescriptorSet(t, files...)}).Parse()
+	if err != nil {
+		t.Fatalf("Parse of descriptor set failed: %v", err)
+	}
+	checkUsersOptions(t, gen)
+}
+
+func checkUsersOptions(t *testing.T, gen *GenIR) {
+	t.Helper()
+
+	user := gen.Body.Message("users.User")
+	if user == nil {
+		t.Fatalf("users.User missing")
+	}
+	if user.Table != "users" {
+		t.Errorf("table = %q, want users", user.Table)
+	}
+	keys := user.KeyFields()
+	if len(keys) != 1 || keys[0].Name != "id" {
+		t.Errorf("key fields = %+v", keys)
+	}
+	if email := user.Fields[1]; !email.Index || !email.Unique || email.Key {
+		t.Errorf("email options: %+v", email)
+	}
+	if id := gen.Body.Message("users.UserID"); id.Table != "" || id.Fields[0].Key {
+		t.Errorf("UserID should carry no options: %+v", id)
+	}
+}
+
+func TestParseServices(t *testing.T) {
+	dir := writeProtoFiles(t, map[string]string{
+		"users.proto": usersProto,
+		"accounts.proto": `syntax = "proto3";
+package accounts;
+
+import "storpc/options.proto";
+import "google/protobuf/empty.proto";
+
+message Account {
+  option (storpc.table) = "accounts";
+  string id = 1 [(storpc.key) = true];
+}
+
+message ListAccountsResponse {
+  repeated Account accounts = 1;
+}
+
+message Ping {}
+
+service Accounts {
+  rpc UpdateAccount(Account) returns (Account);
+  rpc ListAccounts(google.protobuf.Empty) returns (ListAccountsResponse);
+  rpc Settle(Ping) returns (Ping);
+  rpc Everything(google.protobuf.Empty) returns (google.protobuf.Empty);
+}
+`,
+	})
+
+	parser := NewProtoParser(&ProtoParserOptions{
+		Inputs:      []string{filepath.Join(dir, "users.proto"), filepath.Join(dir, "accounts.proto")},
+		ImportPaths: []string{dir},
+	})
+	gen, err := parser.Parse()
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+
+	if len(gen.Body.Services) != 2 {
+		t.Fatalf("expected 2 services, got %d", len(gen.Body.Services))
+	}
+
+	tests := []struct {
+		service, method string
+		op              uint8
+		table           string
+	}{
+		{"users.Users", "Create", OpInsert, "users.User"},
+		{"users.Users", "Fetch", OpGet, "users.User"},
+		{"users.Users", "Drop", OpDelete, ""},
+		{"accounts.Accounts", "UpdateAccount", OpUpdate, "accounts.Account"},
+		{"accounts.Accounts", "ListAccounts", OpList, "accounts.Account"},
+		{"accounts.Accounts", "Settle", OpUnknown, ""},
+		{"accounts.Accounts", "Everything", OpGet, ""},
+	}
+	for _, tt := range tests {
+		m := gen.Body.Method(tt.service, tt.method)
+		if m == nil {
+			t.Errorf("%s.%s missing", tt.service, tt.method)
+			continue
+		}
+		if m.Operation != tt.op || m.Table != tt.table {
+			t.Errorf("%s.%s: op %d table %q, want op %d table %q", tt.service, tt.method, m.Operation, m.Table, tt.op, tt.table)
+		}
+	}
+
+	fetch := gen.Body.Method("users.Users", "Fetch")
+	if fetch.Input != "users.UserID" || fetch.Output != "users.User" {
+		t.Errorf("Fetch types = %s -> %s", fetch.Input, fetch.Output)
+	}
+}
+
+func TestParseWordCase(t *testing.T) {
+	file := createTestFileDescriptorSet(t)
+
+	gen, err := NewProtoParser(&ProtoParserOptions{Filepath: file, WordCase: "snake"}).Parse()
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+
+	login := gen.Body.Message("testpkg.LoginRequest")
+	if login.CaseName != "login_request" {
+		t.Errorf("CaseName = %q", login.CaseName)
+	}
+	asset := gen.Body.Message("testpkg.LoginRequest.Asset")
+	if asset.CaseName != "login_request_asset" {
+		t.Errorf("nested CaseName = %q", asset.CaseName)
+	}
+	if f := login.Fields[2]; f.Name != "asset" || f.CaseName != "asset" || f.Type != asset.Name {
+		t.Errorf("field %+v", f)
+	}
+
+	dir := writeProtoFiles(t, map[string]string{"users.proto": usersProto})
+	gen, err = NewProtoParser(&ProtoParserOptions{
+		Inputs:      []string{filepath.Join(dir, "users.proto")},
+		ImportPaths: []string{dir},
+		WordCase:    "pascal",
+	}).Parse()
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+	user := gen.Body.Message("users.User")
+	if user.Table != "users" || user.CaseTable != "Users" {
+		t.Errorf("table %q / %q", user.Table, user.CaseTable)
+	}
+	if user.Fields[1].CaseName != "Email" {
+		t.Errorf("field CaseName = %q", user.Fields[1].CaseName)
+	}
+
+	_, err = NewProtoParser(&ProtoParserOptions{Filepath: file, WordCase: "train"}).Parse()
+	if err == nil {
+		t.Fatalf("expected error for unknown word case")
+	}
+}
+
+func TestParseWellKnownTypes(t *testing.T) {
+	gen := parseSchema(t, map[string]string{"schema.proto": `syntax = "proto3";
+package shop;
+
+import "google/protobuf/any.proto";
+import "google/protobuf/duration.proto";
+import "google/protobuf/struct.proto";
+import "google/protobuf/timestamp.proto";
+import "google/protobuf/wrappers.proto";
+
+message Event {
+  int64 id = 1;
+  google.protobuf.Timestamp at = 2;
+  google.protobuf.Duration took = 3;
+  google.protobuf.Int32Value retries = 4;
+  google.protobuf.Struct attributes = 5;
+  google.protobuf.Any detail = 6;
+  repeated google.protobuf.Timestamp history = 7;
+  map<string, google.protobuf.StringValue> labels = 8;
+}
+`})
+
+	event := gen.Body.Message("shop.Event")
+	if event == nil {
+		t.Fatalf("shop.Event not parsed")
+	}
+
+	want := map[string]uint8{
+		"id":         LogicalNone,
+		"at":         LogicalTime,
+		"took":       LogicalDuration,
+		"retries":    LogicalNullable,
+		"attributes": LogicalJSON,
+		"detail":     LogicalAny,
+		"history":    LogicalTime,
+		"labels":     LogicalNone,
+	}
+	for _, f := range event.Fields {
+		if f.Logical != want[f.Name] {
+			t.Errorf("%s logical = %s, want %s", f.Name, LogicalString(f.Logical), LogicalString(want[f.Name]))
+		}
+	}
+
+	retries := event.Fields[3]
+	if retries.ScalarType() != "int32" {
+		t.Errorf("retries scalar type = %q", retries.ScalarType())
+	}
+	if labels := event.Fields[7]; labels.MapValue.Logical != LogicalNullable {
+		t.Errorf("labels value logical = %s", LogicalString(labels.MapValue.Logical))
+	}
+}
+
+func TestParseDefaultsAndRequired(t *testing.T) {
+	gen := parseSchema(t, map[string]string{"schema.proto": `syntax = "proto2";
+package shop;
+
+enum Size {
+  SMALL = 1;
+  LARGE = 2;
+}
+
+message Item {
+  required int64 id = 1;
+  optional string name = 2 [default = "unnamed"];
+  optional Size size = 3 [default = LARGE];
+  optional double price = 4 [default = -inf];
+  repeated int32 codes = 5 [packed = true];
+  optional group Extra = 6 {
+    optional bytes blob = 7 [default = "a\001"];
+  }
+  optional int32 stock = 8;
+}
+`})
+
+	item := gen.Body.Message("shop.Item")
+	if item == nil {
+		t.Fatalf("shop.Item not parsed")
+	}
+
+	byName := make(map[string]*Field)
+	for i := range item.Fields {
+		byName[item.Fields[i].Name] = &item.Fields[i]
+	}
+
+	if !byName["id"].IsRequired() || byName["name"].IsRequired() {
+		t.Errorf("required fields not resolved")
+	}
+	for name, want := range map[string]string{"name": "unnamed", "size": "LARGE", "price": "-inf"} {
+		if f := byName[name]; !f.HasDefault || f.Default != want {
+			t.Errorf("%s default = %q (%v), want %q", name, f.Default, f.HasDefault, want)
+		}
+	}
+	if f := byName["stock"]; f.HasDefault || f.Presence != PresenceExplicit {
+		t.Errorf("stock default %v presence %d", f.HasDefault, f.Presence)
+	}
+	if !byName["codes"].Packed {
+		t.Errorf("codes not packed")
+	}
+	if !byName["Extra"].Delimited {
+		t.Errorf("group not delimited")
+	}
+	if blob := gen.Body.Message("shop.Item.Extra").Fields[0]; blob.Default != `a\001` {
+		t.Errorf("blob default = %q", blob.Default)
+	}
+}
+
+func TestParseEditionsFeatures(t *testing.T) {
+	gen := parseSchema(t, map[string]string{"schema.proto": `edition = "2023";
+package shop;
+
+option features.field_presence = IMPLICIT;
+
+message Part {
+  int64 id = 1 [features.field_presence = LEGACY_REQUIRED];
+  string name = 2;
+  int32 stock = 3 [features.field_presence = EXPLICIT, default = 10];
+  repeated int32 codes = 4 [features.repeated_field_encoding = EXPANDED];
+  Part parent = 5 [features.message_encoding = DELIMITED];
+}
+`})
+
+	part := gen.Body.Message("shop.Part")
+	if part == nil {
+		t.Fatalf("shop.Part not parsed")
+	}
+
+	id, name, stock, codes, parent := part.Fields[0], part.Fields[1], part.Fields[2], part.Fields[3], part.Fields[4]
+	if !id.IsRequired() || id.Cardinality != CardinalityRequired {
+		t.Errorf("id presence = %d", id.Presence)
+	}
+	if name.Presence != PresenceImplicit || name.HasPresence {
+		t.Errorf("name presence = %d", name.Presence)
+	}
+	if stock.Presence != PresenceExplicit || stock.Default != "10" {
+		t.Errorf("stock presence = %d default = %q", stock.Presence, stock.Default)
+	}
+	if codes.Packed {
+		t.Errorf("codes should be expanded")
+	}
+	if !parent.Delimited || parent.Type != "shop.Part" {
+		t.Errorf("parent delimited = %v type = %s", parent.Delimited, parent.Type)
+	}
+}
+
+func TestParseReportsAllDiagnostics(t *testing.T) {
+	dir := writeProtoFiles(t, map[string]string{
+		"bad.proto": `syntax = "proto3";
+package bad;
+
+message Bad {
+  unknown.Type first = 1;
+  other.Type second = 2;
+}
+`,
+	})
+
+	parser := NewProtoParser(&ProtoParserOptions{
+		Inputs:      []string{filepath.Join(dir, "bad.proto")},
+		ImportPaths: []string{dir},
+		Quiet:       true,
+	})
+	_, err := parser.Parse()
+
+	var diagnostics Diagnostics
+	if !errors.As(err, &diagnostics) {
+		t.Fatalf("expected Diagnostics, got %v", err)
+	}
+	if len(diagnostics) != 2 {
+		t.Fatalf("expected both errors, got %v", diagnostics)
+	}
+	if d := diagnostics[1]; d.Code != CodeCompile || d.File != "bad.proto" || d.Line != 6 || d.Column != 3 {
+		t.Errorf("second diagnostic = %+v", d)
+	}
+}
+
+func TestParseDiagnosticsLocated(t *testing.T) {
+	dir := writeProtoFiles(t, map[string]string{
+		"stream.proto": `syntax = "proto3";
+package streams;
+
+import "google/protobuf/empty.proto";
+
+service Feeds {
+  rpc Watch(google.protobuf.Empty) returns (stream google.protobuf.Empty);
+  rpc Frobnicate(google.protobuf.Empty) returns (google.protobuf.Empty);
+  rpc Upload(stream google.protobuf.Empty) returns (google.protobuf.Empty);
+}
+`,
+	})
+
+	parser := NewProtoParser(&ProtoParserOptions{
+		Inputs:      []string{filepath.Join(dir, "stream.proto")},
+		ImportPaths: []string{dir},
+		Quiet:       true,
+	})
+	_, err := parser.Parse()
+	if err == nil {
+		t.Fatalf("expected streaming errors")
+	}
+
+	want := []string{
+		"stream.proto:7:3: error STREAMING_RPC: streaming RPC detected: service streams.Feeds, method Watch",
+		"stream.proto:9:3: error STREAMING_RPC: streaming RPC detected: service streams.Feeds, method Upload",
+	}
+	if got := strings.Split(err.Error(), "\n"); !slices.Equal(got, want) {
+		t.Errorf("errors =\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+
+	var out bytes.Buffer
+	if err := parser.Diagnostics().WriteJSON(&out); err != nil {
+		t.Fatalf("WriteJSON failed: %v", err)
+	}
+	var decoded []map[string]any
+	if err := json.Unmarshal(out.Bytes(), &decoded); err != nil {
+		t.Fatalf("bad JSON: %v", err)
+	}
+	if len(decoded) != 2 || decoded[0]["severity"] != "error" || decoded[0]["line"] != float64(7) {
+		t.Errorf("JSON = %s", out.String())
+	}
+}
+
+func TestParseCollectsMissingImports(t *testing.T) {
+	fd := &descriptorpb.FileDescriptorProto{
+		Name:       proto.String("a.proto"),
+		Package:    proto.String("a"),
+		Dependency: []string{"missing/one.proto", "missing/two.proto"},
+	}
+	parser := NewProtoParser(&ProtoParserOptions{Quiet: true})
+	_, err := parser.ParseSet(&descriptorpb.FileDescriptorSet{File: []*descriptorpb.FileDescriptorProto{fd}})
+
+	var diagnostics Diagnostics
+	if !errors.As(err, &diagnostics) || len(diagnostics) != 2 {
+		t.Fatalf("expected two import errors, got %v", err)
+	}
+	for _, d := range diagnostics {
+		if d.Code != CodeImport || d.File != "a.proto" {
+			t.Errorf("diagnostic = %+v", d)
+		}
+	}
 }
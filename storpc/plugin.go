@@ -0,0 +1,171 @@
+package storpc
+
+import (
+	"fmt"
+	"io"
+	"strings"
+
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/pluginpb"
+)
+
+// suffixes of the files written by the plugin. They are named after the
+// first file to generate, next to it, so runs over several directories, as
+// buf makes them, write distinct files.
+const (
+	PluginGenIRSuffix  = ".storpc.genir"
+	PluginReportSuffix = ".storpc.report.txt"
+	PluginGoSuffix     = ".storpc.go"
+	PluginDocsSuffix   = ".storpc_docs" // with .md or .html
+)
+
+// PluginOutput names an output file of the plugin for the files to generate.
+func PluginOutput(filesToGenerate []string, suffix string) string {
+	if len(filesToGenerate) == 0 {
+		return strings.TrimPrefix(suffix, ".")
+	}
+	return strings.TrimSuffix(filesToGenerate[0], ".proto") + suffix
+}
+
+// plugin parameters, without dashes, and the parser flags they set
+var pluginParams = map[string]ParseArgs{
+	"case":       Case,
+	"group":      Group,
+	"v":          Verbose,
+	"verbose":    Verbose,
+	"q":          Quiet,
+	"quiet":      Quiet,
+	"go_package": GoPackage,
+	"log_format": LogFormat,
+	"docs":       Docs,
+}
+
+// ParsePluginParameter reads the comma separated parameter protoc passes to
+// the plugin, e.g. "case=snake,group=shop,verbose,log_format=json". Names
+// may keep the dashes of the command line flags.
+func ParsePluginParameter(parameter string) (map[ParseArgs]string, error) {
+	args := make(map[ParseArgs]string)
+	for _, param := range splitArg(parameter) {
+		name, value, _ := strings.Cut(param, "=")
+		flag, ok := pluginParams[strings.TrimLeft(name, "-")]
+		if !ok {
+			return nil, fmt.Errorf("unknown plugin parameter %q", name)
+		}
+		if flag == Verbose || flag == Quiet {
+			value = string(flag)
+		}
+		args[flag] = value
+	}
+	return args, nil
+}
+
+// Generate runs the parser over the files of a protoc request and returns
+// the binary GenIR, a validation report, with go_package set a typed Go
+// client and with docs set a data dictionary. Parser logs go to log, as
+// stdout carries the response. Failures are reported in the response error.
+func Generate(req *pluginpb.CodeGeneratorRequest, log io.Writer) *pluginpb.CodeGeneratorResponse {
+	resp := &pluginpb.CodeGeneratorResponse{
+		SupportedFeatures: proto.Uint64(uint64(pluginpb.CodeGeneratorResponse_FEATURE_PROTO3_OPTIONAL |
+			pluginpb.CodeGeneratorResponse_FEATURE_SUPPORTS_EDITIONS)),
+		MinimumEdition: proto.Int32(int32(descriptorpb.Edition_EDITION_PROTO2)),
+		MaximumEdition: proto.Int32(int32(descriptorpb.Edition_EDITION_2023)),
+	}
+	fail := func(err error) *pluginpb.CodeGeneratorResponse {
+		resp.Error = proto.String(err.Error())
+		return resp
+	}
+
+	args, err := ParsePluginParameter(req.GetParameter())
+	if err != nil {
+		return fail(err)
+	}
+
+	options := NewProtoParserOptions(args)
+	options.Roots = req.GetFileToGenerate()
+	options.Log, err = NewLogConfig(args[LogFormat], "")
+	if err != nil {
+		return fail(err)
+	}
+	options.Log.Output = log
+	options.Log.Level = options.level()
+
+	// protoc sends every file the generated ones need, dependencies first
+	set := &descriptorpb.FileDescriptorSet{File: req.GetProtoFile()}
+	parser := NewProtoParser(options)
+	gen, err := parser.ParseSet(set)
+	if err != nil {
+		return fail(err)
+	}
+
+	data, err := MarshalGenIR(gen)
+	if err != nil {
+		return fail(err)
+	}
+
+	resp.File = append(resp.File,
+		&pluginpb.CodeGeneratorResponse_File{
+			Name:    proto.String(PluginOutput(options.Roots, PluginGenIRSuffix)),
+			Content: proto.String(string(data)),
+		},
+		&pluginpb.CodeGeneratorResponse_File{
+			Name:    proto.String(PluginOutput(options.Roots, PluginReportSuffix)),
+			Content: proto.String(validationReport(gen, parser.Diagnostics())),
+		},
+	)
+
+	if pkg := args[GoPackage]; pkg != "" {
+		src, err := GenerateGoClient(gen, pkg)
+		if err != nil {
+			return fail(err)
+		}
+		resp.File = append(resp.File, &pluginpb.CodeGeneratorResponse_File{
+			Name:    proto.String(PluginOutput(options.Roots, PluginGoSuffix)),
+			Content: proto.String(string(src)),
+		})
+	}
+
+	if format := args[Docs]; format != "" {
+		docs, err := GenerateDocs(gen, format)
+		if err != nil {
+			return fail(err)
+		}
+		ext := ".md"
+		if format == DocFormatHTML {
+			ext = ".html"
+		}
+		resp.File = append(resp.File, &pluginpb.CodeGeneratorResponse_File{
+			Name:    proto.String(PluginOutput(options.Roots, PluginDocsSuffix+ext)),
+			Content: proto.String(string(docs)),
+		})
+	}
+
+	return resp
+}
+
+// validationReport lists the parser's warnings and what the IR could not
+// work out, one problem per line, so pipelines can keep it next to the
+// generated files.
+func validationReport(gen *GenIR, diagnostics Diagnostics) string {
+	var b strings.Builder
+	diagnostics.WriteText(&b)
+
+	for _, m := range gen.Body.Messages {
+		if m.Table != "" && len(m.KeyFields()) == 0 {
+			fmt.Fprintf(&b, "%s: table %s has no key field\n", m.Name, m.Table)
+		}
+	}
+	for _, svc := range gen.Body.Services {
+		for _, method := range svc.Methods {
+			path := svc.Name + "/" + method.Name
+			if method.Table == "" {
+				fmt.Fprintf(&b, "%s: no stored message in input or output\n", path)
+			}
+		}
+	}
+
+	if b.Len() == 0 {
+		return "ok\n"
+	}
+	return b.String()
+}
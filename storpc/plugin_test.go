@@ -0,0 +1,78 @@
+package storpc
+
+import (
+	"bytes"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/pluginpb"
+)
+
+func pluginRequest(t *testing.T, parameter string) *pluginpb.CodeGeneratorRequest {
+	t.Helper()
+
+	dir := writeProtoFiles(t, map[string]string{"users.proto": usersProto})
+	set, names, err := NewProtoParser(&ProtoParserOptions{Quiet: true}).compileSources([]string{filepath.Join(dir, "users.proto")})
+	if err != nil {
+		t.Fatalf("compile failed: %v", err)
+	}
+
+	return &pluginpb.CodeGeneratorRequest{
+		FileToGenerate: names,
+		Parameter:      proto.String(parameter),
+		ProtoFile:      set.GetFile(),
+	}
+}
+
+func TestGenerate(t *testing.T) {
+	resp := Generate(pluginRequest(t, "case=snake,--group=users,verbose"), &bytes.Buffer{})
+	if resp.Error != nil {
+		t.Fatalf("Generate failed: %v", resp.GetError())
+	}
+
+	files := make(map[string]string)
+	for _, f := range resp.GetFile() {
+		files[f.GetName()] = f.GetContent()
+	}
+
+	gen, err := UnmarshalGenIR([]byte(files["users.storpc.genir"]))
+	if err != nil {
+		t.Fatalf("bad GenIR: %v", err)
+	}
+	checkUsersOptions(t, gen)
+	if m := gen.Body.Message("users.UserID"); m == nil || m.CaseName != "user_id" {
+		t.Errorf("case parameter not applied: %+v", m)
+	}
+
+	report := files["users.storpc.report.txt"]
+	if !strings.Contains(report, "users.Users/Drop: no stored message") {
+		t.Errorf("report = %q", report)
+	}
+}
+
+func TestPluginOutput(t *testing.T) {
+	// buf runs the plugin once per directory
+	for _, tt := range []struct {
+		files []string
+		want  string
+	}{
+		{[]string{"shop/v1/orders.proto", "shop/v1/items.proto"}, "shop/v1/orders.storpc.genir"},
+		{[]string{"billing/v1/invoices.proto"}, "billing/v1/invoices.storpc.genir"},
+		{nil, "storpc.genir"},
+	} {
+		if got := PluginOutput(tt.files, PluginGenIRSuffix); got != tt.want {
+			t.Errorf("PluginOutput(%v) = %q, want %q", tt.files, got, tt.want)
+		}
+	}
+}
+
+func TestGeneratePluginErrors(t *testing.T) {
+	if resp := Generate(pluginRequest(t, "colour=blue"), &bytes.Buffer{}); !strings.Contains(resp.GetError(), "colour") {
+		t.Errorf("unknown parameter error = %q", resp.GetError())
+	}
+	if resp := Generate(pluginRequest(t, "case=loud"), &bytes.Buffer{}); resp.GetError() == "" {
+		t.Errorf("expected error for unknown word case")
+	}
+}
@@ -28,6 +28,26 @@ func NewMethodIR(header *MethodHeader, body *MethodBody) *MethodIR {
 	}
 }
 
+// MethodBatch is a group of calls sent and applied together. Items may
+// operate on different tables; each carries its own header.
+type MethodBatch struct {
+	Atomic bool // apply every item or none
+	Items  []*MethodIR
+}
+
+func NewMethodBatch(atomic bool, items ...*MethodIR) *MethodBatch {
+	return &MethodBatch{
+		Atomic: atomic,
+		Items:  items,
+	}
+}
+
+// MethodResult is the outcome of one item of a MethodBatch.
+type MethodResult struct {
+	Status uint8  // ResultOK, ResultFailed or ResultAborted
+	Error  string // why the item failed, empty if it did not
+}
+
 // 11 bytes header
 type GenHeader struct {
 	VersionMajor uint8  // 1 byte
@@ -47,23 +67,98 @@ func NewGenHeader(numMessages, numEnums uint32) *GenHeader {
 	}
 }
 
+// GenBody holds one entry per message and enum of every file covered, nested
+// declarations included, except imported well-known types and storpc
+// options. Fields refer to these entries by full name. Bodies built by the
+// parser or decoder are indexed by name; call Reindex after changing
+// Messages or Enums.
 type GenBody struct {
 	Messages []Message
 	Enums    []Enum
 	Group    string
+	Services []Service
+	Files    []string // every file covered, dependencies first
+	Roots    []string // files whose services are hosted
+	KeyGroup string   // group the tables are stored under, see GroupRegistry
+
+	messageIndex map[string]int
+	enumIndex    map[string]int
 }
 
 func NewGenBody() *GenBody {
 	return &GenBody{}
 }
 
-// 8 bytes header
+// Message looks up a message of the type table by full name.
+func (b *GenBody) Message(name string) *Message {
+	i, ok := b.messageIndex[name]
+	if !ok {
+		return nil
+	}
+	return &b.Messages[i]
+}
+
+// Enum looks up an enum of the type table by full name.
+func (b *GenBody) Enum(name string) *Enum {
+	i, ok := b.enumIndex[name]
+	if !ok {
+		return nil
+	}
+	return &b.Enums[i]
+}
+
+// MessageOf resolves the message type of a message typed field.
+func (b *GenBody) MessageOf(f *Field) *Message {
+	if f.Kind != KindMessage {
+		return nil
+	}
+	return b.Message(f.Type)
+}
+
+// EnumOf resolves the enum type of an enum typed field.
+func (b *GenBody) EnumOf(f *Field) *Enum {
+	if f.Kind != KindEnum {
+		return nil
+	}
+	return b.Enum(f.Type)
+}
+
+// Method looks up a hosted method by service full name and method name.
+func (b *GenBody) Method(service, method string) *Method {
+	for i := range b.Services {
+		if b.Services[i].Name != service {
+			continue
+		}
+		for j := range b.Services[i].Methods {
+			if b.Services[i].Methods[j].Name == method {
+				return &b.Services[i].Methods[j]
+			}
+		}
+	}
+	return nil
+}
+
+// Reindex rebuilds the name index of Messages and Enums used by Message and
+// Enum. It is not safe to call while the body is read concurrently.
+func (b *GenBody) Reindex() {
+	b.messageIndex = make(map[string]int, len(b.Messages))
+	for i, m := range b.Messages {
+		b.messageIndex[m.Name] = i
+	}
+	b.enumIndex = make(map[string]int, len(b.Enums))
+	for i, e := range b.Enums {
+		b.enumIndex[e.Name] = i
+	}
+}
+
+// 10 bytes header, followed by the frame checksum in the binary format
 type MethodHeader struct {
 	VersionMajor uint8  // 1 byte
 	VersionMinor uint8  // 1 byte
 	VersionPatch uint8  // 1 byte
 	Group        uint32 // 4 bytes
 	Operation    uint8  // 1 byte operation type
+	Features     uint16 // 2 bytes, Feature bits the frame uses
 }
 
 func NewMethodHeader(operation uint8) *MethodHeader {
@@ -75,36 +170,178 @@ func NewMethodHeader(operation uint8) *MethodHeader {
 	}
 }
 
+// MethodBody is a message of a call: the fields that are set, in field
+// number order. Fields at their zero value without explicit presence are
+// unset, as on the wire.
 type MethodBody struct {
-	Type    string
-	Message map[string]interface{}
+	Type   string // full name of the message
+	Fields []FieldValue
 }
 
-func NewMethodBody(typeof string, payload map[string]interface{}) *MethodBody {
+// NewMethodBody sorts fields by number in place.
+func NewMethodBody(typeof string, fields []FieldValue) *MethodBody {
+	sortFields(fields)
 	return &MethodBody{
-		Type:    typeof,
-		Message: payload,
+		Type:   typeof,
+		Fields: fields,
 	}
 }
 
 type Message struct {
-	Name   string
-	Fields []Field
+	Name       string // proto full name, the key other entries refer to
+	CaseName   string // name relative to the package in the configured word case
+	Fields     []Field
+	Oneofs     []Oneof  // declared oneofs, synthetic proto3 optional ones excluded
+	MapEntry   bool     // generated entry type of a map field
+	Table      string   // (storpc.table), empty if the message is not stored
+	CaseTable  string   // Table in the configured word case
+	LintIgnore []string // (storpc.lint_ignore), lint rules not applied
+	Comments   Comments
+	Location   Location
+}
+
+// KeyFields returns the (storpc.key) fields in declaration order.
+func (m *Message) KeyFields() []Field {
+	var keys []Field
+	for _, f := range m.Fields {
+		if f.Key {
+			keys = append(keys, f)
+		}
+	}
+	return keys
 }
 
 type Field struct {
+	Name           string // proto name
+	CaseName       string // Name in the configured word case
+	Type           string // scalar kind, or full name of a GenBody message or enum
+	Kind           uint8  // KindScalar, KindMessage or KindEnum
+	Logical        uint8  // column type of well-known message types, LogicalNone otherwise
+	Number         int32
+	Key            bool   // (storpc.key)
+	Index          bool   // (storpc.index)
+	Unique         bool   // (storpc.unique)
+	Cardinality    uint8  // CardinalityOptional, CardinalityRequired or CardinalityRepeated
+	Oneof          string // name of the containing oneof, empty if none
+	HasPresence    bool   // unset can be told apart from the zero value
+	Proto3Optional bool   // declared with the proto3 optional keyword
+	MapKey         *Field // set for map fields
+	MapValue       *Field // set for map fields
+	Presence       uint8  // PresenceImplicit, PresenceExplicit or PresenceRequired
+	Default        string // declared default in descriptor text form
+	HasDefault     bool   // a default was declared, Default may still be ""
+	Packed         bool   // repeated scalars use the packed encoding
+	Delimited      bool   // message encoded as a group
+	Comments       Comments
+}
+
+type Oneof struct {
 	Name   string
-	Type   string
-	Number int32
-	Nested *Message
+	Fields []int32 // numbers of the member fields
+}
+
+func (f *Field) IsRepeated() bool {
+	return f.Cardinality == CardinalityRepeated && f.MapKey == nil
+}
+
+func (f *Field) IsMap() bool {
+	return f.MapKey != nil
+}
+
+func (f *Field) IsRequired() bool {
+	return f.Presence == PresenceRequired
 }
 
 type Enum struct {
-	Name   string
-	Values []EnumValue
+	Name           string // proto full name, the key fields refer to
+	CaseName       string // name relative to the package in the configured word case
+	Values         []EnumValue
+	Closed         bool // unknown values are rejected rather than preserved
+	ReservedRanges []EnumRange
+	ReservedNames  []string
+	Comments       Comments
 }
 
 type EnumValue struct {
-	Name  string
-	Value int32
+	Name    string
+	Value   int32
+	AliasOf string // name of the first value sharing this number, if any
+}
+
+// inclusive on both ends, as in the .proto source
+type EnumRange struct {
+	Start int32
+	End   int32
+}
+
+// Contains reports whether v is a declared value of the enum.
+func (e *Enum) Contains(v int32) bool {
+	_, ok := e.Ordinal(v)
+	return ok
+}
+
+// Ordinal returns the dense index of v among the distinct values of the enum,
+// in declaration order. Aliases share the ordinal of the value they alias.
+// Storage uses the ordinal to keep enum columns as small as possible.
+func (e *Enum) Ordinal(v int32) (int, bool) {
+	ordinal := 0
+	for _, value := range e.Values {
+		if value.AliasOf != "" {
+			continue
+		}
+		if value.Value == v {
+			return ordinal, true
+		}
+		ordinal++
+	}
+	return 0, false
+}
+
+// Reserved reports whether v falls in one of the reserved ranges.
+func (e *Enum) Reserved(v int32) bool {
+	for _, r := range e.ReservedRanges {
+		if v >= r.Start && v <= r.End {
+			return true
+		}
+	}
+	return false
+}
+
+type Service struct {
+	Name     string // full name
+	File     string
+	Methods  []Method
+	KeyGroup string // group its tables are stored under, see GroupRegistry
+}
+
+type Method struct {
+	Name            string
+	Input           string // full name of the request message
+	Output          string // full name of the response message
+	Operation       uint8  // OpInsert, OpGet, ... or OpUnknown
+	Table           string // full name of the stored message operated on, empty if none
+	ClientStreaming bool   // only kept by parsers with KeepStreaming
+	ServerStreaming bool
+	Comments        Comments
+	Location        Location
+}
+
+// Location is where a declaration is in the .proto source. Line and Column
+// are 1-based and zero when the source info is missing.
+type Location struct {
+	File   string
+	Line   int
+	Column int
+}
+
+// Comments are the comments attached to a declaration in the .proto source,
+// without comment markers. They are empty when the source info is missing.
+type Comments struct {
+	Leading  string
+	Trailing string
+	Detached []string // leading comments separated by a blank line
+}
+
+func (c Comments) IsEmpty() bool {
+	return c.Leading == "" && c.Trailing == "" && len(c.Detached) == 0
 }
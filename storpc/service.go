@@ -2,60 +2,191 @@ package storpc
 
 import (
 	"context"
+	"fmt"
+	"log/slog"
 	"net"
+	"strings"
 
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
 	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
 	"google.golang.org/protobuf/types/dynamicpb"
 )
 
 type RpcMethod struct {
+	schema *GenBody
 	md     protoreflect.MethodDescriptor
+	ir     *Method
+	group  uint32 // id of the key group, see GroupRegistry
 	Output *dynamicpb.Message
 }
 
+func NewRpcMethod(schema *GenBody, md protoreflect.MethodDescriptor, ir *Method, group uint32) RpcMethod {
+	return RpcMethod{
+		schema: schema,
+		md:     md,
+		ir:     ir,
+		group:  group,
+		Output: dynamicpb.NewMessage(md.Output()),
+	}
+}
+
+// Operate turns a request into the MethodIR handed to storage. The body
+// holds the set fields of the request, linked to the fields of the schema.
 func (m RpcMethod) Operate(input *dynamicpb.Message) MethodIR {
-	var operation uint8
-	if m.md.Input().FullName() == "google.protobuf.Empty" {
-		operation = OpGet
-	} else {
-		operation = OpInsert
+	header := NewMethodHeader(m.ir.Operation)
+	header.Group = m.group
+
+	return MethodIR{
+		Header: header,
+		Body:   messageBody(m.schema, input),
 	}
-	fields := input.Descriptor().Fields()
-	payload := make(map[string]interface{})
+}
+
+// MissingRequired lists the required fields of msg that are not set,
+// including those of the messages it holds, as dotted paths.
+func MissingRequired(body *GenBody, msg protoreflect.Message) []string {
+	return missingRequired(body, msg, "")
+}
 
-	for i := 0; i < fields.Len(); i++ {
-		f := fields.Get(i)
-		val := input.Get(f)                         // protoreflect.Value
-		payload[string(f.Name())] = val.Interface() // convert to Go type
+func missingRequired(body *GenBody, msg protoreflect.Message, prefix string) []string {
+	ir := body.Message(string(msg.Descriptor().FullName()))
+	if ir == nil {
+		return nil
 	}
 
-	header := NewMethodHeader(operation)
-	body := NewMethodBody(string(input.Descriptor().FullName()), payload)
+	var missing []string
+	fields := msg.Descriptor().Fields()
+	for i := range ir.Fields {
+		f := &ir.Fields[i]
+		fd := fields.ByNumber(protoreflect.FieldNumber(f.Number))
+		if fd == nil {
+			continue
+		}
 
-	serialiasedMethod := MethodIR{
-		Header: header,
-		Body:   body,
+		path := prefix + f.Name
+		if !msg.Has(fd) {
+			if f.IsRequired() {
+				missing = append(missing, path)
+			}
+			continue
+		}
+
+		switch {
+		case f.IsMap():
+			if f.MapValue.Kind != KindMessage {
+				continue
+			}
+			msg.Get(fd).Map().Range(func(k protoreflect.MapKey, v protoreflect.Value) bool {
+				missing = append(missing, missingRequired(body, v.Message(), fmt.Sprintf("%s[%v].", path, k.Interface()))...)
+				return true
+			})
+		case f.Kind != KindMessage:
+		case f.IsRepeated():
+			list := msg.Get(fd).List()
+			for j := 0; j < list.Len(); j++ {
+				missing = append(missing, missingRequired(body, list.Get(j).Message(), fmt.Sprintf("%s[%d].", path, j))...)
+			}
+		default:
+			missing = append(missing, missingRequired(body, msg.Get(fd).Message(), path+".")...)
+		}
 	}
+	return missing
+}
 
-	return serialiasedMethod
+// partialCodec leaves required fields to the handlers: reads, updates and
+// deletes may carry nothing but the key, only inserts must be complete.
+type partialCodec struct{}
+
+func (partialCodec) Marshal(v any) ([]byte, error) {
+	return proto.MarshalOptions{AllowPartial: true}.Marshal(v.(proto.Message))
 }
 
-func RunDynamicServer(fd protoreflect.FileDescriptor) error {
-	lis, err := net.Listen("tcp", ":50051")
-	if err != nil {
-		return err
+func (partialCodec) Unmarshal(data []byte, v any) error {
+	return proto.UnmarshalOptions{AllowPartial: true}.Unmarshal(data, v.(proto.Message))
+}
+
+func (partialCodec) Name() string {
+	return "proto"
+}
+
+// Dispatch hands a decoded call over to storage.
+type Dispatch func(ctx context.Context, method *MethodIR) error
+
+// ServerOptions configures RunDynamicServerWithOptions.
+type ServerOptions struct {
+	Address string         // ":50051" if empty
+	Log     *LogConfig     // info and above to stdout if nil
+	Groups  *GroupRegistry // ids of key groups, in memory if nil
+}
+
+// RunDynamicServer hosts every service of the IR, resolving request and
+// response types from files. Calls are turned into MethodIR and passed to
+// dispatch, which may be nil. Streaming methods are rejected.
+func RunDynamicServer(gen *GenIR, files *protoregistry.Files, dispatch Dispatch) error {
+	return RunDynamicServerWithOptions(gen, files, dispatch, ServerOptions{})
+}
+
+func RunDynamicServerWithOptions(gen *GenIR, files *protoregistry.Files, dispatch Dispatch, options ServerOptions) error {
+	config := options.Log
+	if config == nil {
+		config = &LogConfig{Level: slog.LevelInfo}
+	}
+	logger := config.For(SubsystemServer)
+
+	address := options.Address
+	if address == "" {
+		address = ":50051"
+	}
+
+	groups := options.Groups
+	if groups == nil {
+		groups, _ = NewGroupRegistry(nil)
 	}
 
-	server := grpc.NewServer()
+	server := grpc.NewServer(grpc.ForceServerCodec(partialCodec{}))
+
+	for s := range gen.Body.Services {
+		svc := &gen.Body.Services[s]
+
+		desc, err := files.FindDescriptorByName(protoreflect.FullName(svc.Name))
+		if err != nil {
+			return fmt.Errorf("service %v: %w", svc.Name, err)
+		}
+		sd, ok := desc.(protoreflect.ServiceDescriptor)
+		if !ok {
+			return fmt.Errorf("%v is not a service", svc.Name)
+		}
+
+		// GenIR written before services carried their key group
+		key := svc.KeyGroup
+		if key == "" {
+			key = gen.Body.KeyGroup
+		}
+		group, err := groups.ID(key)
+		if err != nil {
+			return fmt.Errorf("service %v: %w", svc.Name, err)
+		}
+		logger.Debug("hosting", "service", svc.Name, "group", key, "group_id", group)
 
-	for s := 0; s < fd.Services().Len(); s++ {
-		svc := fd.Services().Get(s)
+		methods := make([]grpc.MethodDesc, 0, len(svc.Methods))
 
-		methods := make([]grpc.MethodDesc, 0, svc.Methods().Len())
+		for m := range svc.Methods {
+			method := &svc.Methods[m]
 
-		for m := 0; m < svc.Methods().Len(); m++ {
-			md := svc.Methods().Get(m)
+			md := sd.Methods().ByName(protoreflect.Name(method.Name))
+			if md == nil {
+				return fmt.Errorf("method %v.%v not found", svc.Name, method.Name)
+			}
+			// only unary handlers are registered
+			if md.IsStreamingClient() || md.IsStreamingServer() {
+				return fmt.Errorf("method %v.%v is a streaming RPC and cannot be served", svc.Name, method.Name)
+			}
+			rpc := NewRpcMethod(gen.Body, md, method, group)
+			path := "/" + svc.Name + "/" + method.Name
 
 			handler := func(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
 				req := dynamicpb.NewMessage(md.Input())
@@ -63,23 +194,50 @@ func RunDynamicServer(fd protoreflect.FileDescriptor) error {
 					return nil, err
 				}
 
+				if method.Operation == OpUnknown {
+					logger.Warn("call to method without storage operation", "method", path)
+					return nil, status.Errorf(codes.Unimplemented, "no storage operation for %v.%v", svc.Name, method.Name)
+				}
+
+				if method.Operation == OpInsert {
+					if missing := MissingRequired(gen.Body, req); len(missing) > 0 {
+						logger.Debug("insert rejected", "method", path, "missing", missing)
+						return nil, status.Errorf(codes.InvalidArgument, "insert is missing required fields: %v", strings.Join(missing, ", "))
+					}
+				}
+
+				logger.Debug("call", "method", path, "op", OpString(method.Operation))
+				ir := rpc.Operate(req)
+				if dispatch != nil {
+					if err := dispatch(ctx, &ir); err != nil {
+						logger.Error("dispatch failed", "method", path, "err", err)
+						return nil, err
+					}
+				}
+
 				reply := dynamicpb.NewMessage(md.Output())
 				return reply, nil
 			}
 
 			methods = append(methods, grpc.MethodDesc{
-				MethodName: string(md.Name()),
+				MethodName: method.Name,
 				Handler:    handler,
 			})
 		}
 
 		server.RegisterService(&grpc.ServiceDesc{
-			ServiceName: string(svc.FullName()),
+			ServiceName: svc.Name,
 			HandlerType: (*interface{})(nil),
 			Methods:     methods,
 			Streams:     []grpc.StreamDesc{},
 		}, nil)
 	}
 
+	lis, err := net.Listen("tcp", address)
+	if err != nil {
+		return err
+	}
+	logger.Info("serving", "address", lis.Addr().String(), "services", len(gen.Body.Services))
+
 	return server.Serve(lis)
 }
@@ -0,0 +1,68 @@
+package storpc
+
+import (
+	"slices"
+	"strings"
+	"testing"
+
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/dynamicpb"
+)
+
+func TestMissingRequired(t *testing.T) {
+	gen, parser := parseSchemaParser(t, map[string]string{"shop.proto": `syntax = "proto2";
+package shop;
+
+message Line {
+  required string sku = 1;
+  optional int32 quantity = 2;
+}
+
+message Order {
+  required int64 id = 1;
+  required string customer = 2;
+  repeated Line lines = 3;
+  map<string, Line> extras = 4;
+}
+`})
+
+	desc, err := parser.Files().FindDescriptorByName("shop.Order")
+	if err != nil {
+		t.Fatalf("shop.Order not found: %v", err)
+	}
+	md := desc.(protoreflect.MessageDescriptor)
+	fields := md.Fields()
+
+	order := dynamicpb.NewMessage(md)
+	order.Set(fields.ByName("id"), protoreflect.ValueOfInt64(1))
+
+	lines := order.Mutable(fields.ByName("lines")).List()
+	line := lines.NewElement()
+	line.Message().Set(line.Message().Descriptor().Fields().ByName("quantity"), protoreflect.ValueOfInt32(2))
+	lines.Append(line)
+
+	extras := order.Mutable(fields.ByName("extras")).Map()
+	extras.Set(protoreflect.ValueOfString("gift").MapKey(), extras.NewValue())
+
+	got := MissingRequired(gen.Body, order)
+	want := []string{"customer", "lines[0].sku", "extras[gift].sku"}
+	if !slices.Equal(got, want) {
+		t.Errorf("MissingRequired = %v, want %v", got, want)
+	}
+
+	order.Set(fields.ByName("customer"), protoreflect.ValueOfString("ada"))
+	order.Clear(fields.ByName("lines"))
+	order.Clear(fields.ByName("extras"))
+	if got := MissingRequired(gen.Body, order); len(got) != 0 {
+		t.Errorf("complete order reported missing %v", got)
+	}
+}
+
+func TestRunDynamicServerRejectsStreaming(t *testing.T) {
+	gen, parser := parseSchemaParser(t, map[string]string{"schema.proto": lintSchema}, keepStreaming)
+
+	err := RunDynamicServerWithOptions(gen, parser.Files(), nil, ServerOptions{Address: "127.0.0.1:0"})
+	if err == nil || !strings.Contains(err.Error(), "WatchOrders is a streaming RPC") {
+		t.Errorf("err = %v, want streaming method rejected", err)
+	}
+}
@@ -0,0 +1,185 @@
+package storpc
+
+import (
+	"cmp"
+	"slices"
+	"strings"
+
+	"google.golang.org/protobuf/reflect/protoreflect"
+)
+
+// FieldValue is the value of a set field. Field links it to the IR and is
+// nil when the schema in use does not declare the number.
+type FieldValue struct {
+	Number int32
+	Field  *Field
+	Value  Value
+}
+
+// Value is a typed value. Kind selects the member that holds it, so
+// scalars are stored without boxing.
+type Value struct {
+	Kind    uint8
+	Int     int64       // ValueInt and ValueEnum
+	Uint    uint64      // ValueUint
+	Float   float64     // ValueDouble and ValueFloat
+	Bool    bool        // ValueBool
+	String  string      // ValueString
+	Bytes   []byte      // ValueBytes
+	Message *MethodBody // ValueMessage
+	List    []Value     // ValueList
+	Map     []MapEntry  // ValueMap, in key order
+}
+
+type MapEntry struct {
+	Key   Value
+	Value Value
+}
+
+// Get returns the value of field number, nil when it is unset.
+func (b *MethodBody) Get(number int32) *FieldValue {
+	i, ok := slices.BinarySearchFunc(b.Fields, number, func(f FieldValue, n int32) int {
+		return cmp.Compare(f.Number, n)
+	})
+	if !ok {
+		return nil
+	}
+	return &b.Fields[i]
+}
+
+// Lookup returns the value of the field with the given IR name, nil when it
+// is unset or the field is not linked.
+func (b *MethodBody) Lookup(name string) *FieldValue {
+	for i := range b.Fields {
+		if f := b.Fields[i].Field; f != nil && f.Name == name {
+			return &b.Fields[i]
+		}
+	}
+	return nil
+}
+
+// messageBody copies the set fields of msg, linking them to the fields of
+// schema.
+func messageBody(schema *GenBody, msg protoreflect.Message) *MethodBody {
+	desc := msg.Descriptor()
+	body := &MethodBody{Type: string(desc.FullName())}
+	ir := schema.Message(body.Type)
+
+	msg.Range(func(fd protoreflect.FieldDescriptor, v protoreflect.Value) bool {
+		fv := FieldValue{Number: int32(fd.Number()), Value: fieldValue(schema, fd, v)}
+		if ir != nil {
+			fv.Field = fieldByNumber(ir, fv.Number)
+		}
+		body.Fields = append(body.Fields, fv)
+		return true
+	})
+	// Range visits fields in no particular order
+	sortFields(body.Fields)
+	return body
+}
+
+// sortFields puts fields in number order, as Get expects.
+func sortFields(fields []FieldValue) {
+	slices.SortStableFunc(fields, func(a, b FieldValue) int { return cmp.Compare(a.Number, b.Number) })
+}
+
+func fieldValue(schema *GenBody, fd protoreflect.FieldDescriptor, v protoreflect.Value) Value {
+	switch {
+	case fd.IsMap():
+		m := v.Map()
+		entries := make([]MapEntry, 0, m.Len())
+		m.Range(func(k protoreflect.MapKey, v protoreflect.Value) bool {
+			entries = append(entries, MapEntry{
+				Key:   scalarValue(schema, fd.MapKey(), k.Value()),
+				Value: scalarValue(schema, fd.MapValue(), v),
+			})
+			return true
+		})
+		slices.SortFunc(entries, func(a, b MapEntry) int { return compareValues(a.Key, b.Key) })
+		return Value{Kind: ValueMap, Map: entries}
+	case fd.IsList():
+		l := v.List()
+		elems := make([]Value, l.Len())
+		for i := range elems {
+			elems[i] = scalarValue(schema, fd, l.Get(i))
+		}
+		return Value{Kind: ValueList, List: elems}
+	}
+	return scalarValue(schema, fd, v)
+}
+
+// scalarValue converts a single value of fd, a message included.
+func scalarValue(schema *GenBody, fd protoreflect.FieldDescriptor, v protoreflect.Value) Value {
+	switch fd.Kind() {
+	case protoreflect.Int32Kind, protoreflect.Sint32Kind, protoreflect.Sfixed32Kind,
+		protoreflect.Int64Kind, protoreflect.Sint64Kind, protoreflect.Sfixed64Kind:
+		return Value{Kind: ValueInt, Int: v.Int()}
+	case protoreflect.Uint32Kind, protoreflect.Fixed32Kind,
+		protoreflect.Uint64Kind, protoreflect.Fixed64Kind:
+		return Value{Kind: ValueUint, Uint: v.Uint()}
+	case protoreflect.DoubleKind:
+		return Value{Kind: ValueDouble, Float: v.Float()}
+	case protoreflect.FloatKind:
+		return Value{Kind: ValueFloat, Float: v.Float()}
+	case protoreflect.BoolKind:
+		return Value{Kind: ValueBool, Bool: v.Bool()}
+	case protoreflect.StringKind:
+		return Value{Kind: ValueString, String: v.String()}
+	case protoreflect.BytesKind:
+		return Value{Kind: ValueBytes, Bytes: v.Bytes()}
+	case protoreflect.EnumKind:
+		return Value{Kind: ValueEnum, Int: int64(v.Enum())}
+	case protoreflect.MessageKind, protoreflect.GroupKind:
+		return Value{Kind: ValueMessage, Message: messageBody(schema, v.Message())}
+	}
+	return Value{}
+}
+
+// valueKind is the kind of a single value of f, ignoring its cardinality.
+func valueKind(f *Field) uint8 {
+	switch f.Kind {
+	case KindEnum:
+		return ValueEnum
+	case KindMessage:
+		return ValueMessage
+	}
+	switch f.Type {
+	case "int32", "int64", "sint32", "sint64", "sfixed32", "sfixed64":
+		return ValueInt
+	case "uint32", "uint64", "fixed32", "fixed64":
+		return ValueUint
+	case "double":
+		return ValueDouble
+	case "float":
+		return ValueFloat
+	case "bool":
+		return ValueBool
+	case "string":
+		return ValueString
+	case "bytes":
+		return ValueBytes
+	}
+	return ValueNone
+}
+
+// compareValues orders map keys, which are integers, bools or strings.
+func compareValues(a, b Value) int {
+	if c := cmp.Compare(a.Kind, b.Kind); c != 0 {
+		return c
+	}
+	switch a.Kind {
+	case ValueInt:
+		return cmp.Compare(a.Int, b.Int)
+	case ValueUint:
+		return cmp.Compare(a.Uint, b.Uint)
+	case ValueBool:
+		switch {
+		case a.Bool == b.Bool:
+			return 0
+		case b.Bool:
+			return -1
+		}
+		return 1
+	}
+	return strings.Compare(a.String, b.String)
+}
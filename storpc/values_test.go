@@ -0,0 +1,65 @@
+package storpc
+
+import (
+	"testing"
+
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/dynamicpb"
+)
+
+func orderRequest(t *testing.T, schema *GenBody, parser *ProtoParser) (RpcMethod, *dynamicpb.Message) {
+	t.Helper()
+
+	desc, err := parser.Files().FindDescriptorByName("shop.Orders")
+	if err != nil {
+		t.Fatalf("service not found: %v", err)
+	}
+	md := desc.(protoreflect.ServiceDescriptor).Methods().ByName("InsertOrder")
+	rpc := NewRpcMethod(schema, md, schema.Method("shop.Orders", "InsertOrder"), 2)
+	return rpc, dynamicpb.NewMessage(md.Input())
+}
+
+func TestOperatePresence(t *testing.T) {
+	gen, parser := parseSchemaParser(t, map[string]string{"schema.proto": methodSchema})
+	schema := gen.Body
+	rpc, req := orderRequest(t, schema, parser)
+
+	fields := req.Descriptor().Fields()
+	req.Set(fields.ByName("note"), protoreflect.ValueOfString("hi"))
+	req.Set(fields.ByName("priority"), protoreflect.ValueOfInt32(0))
+	req.Mutable(fields.ByName("refunds")).List().AppendMutable()
+
+	body := rpc.Operate(req).Body
+	if body.Type != "shop.Order" {
+		t.Errorf("type = %q", body.Type)
+	}
+
+	var numbers []int32
+	for _, fv := range body.Fields {
+		numbers = append(numbers, fv.Number)
+	}
+	// id and gift are at their implicit zero and so unset, priority was
+	// set to zero explicitly
+	if len(numbers) != 3 || numbers[0] != 7 || numbers[1] != 12 || numbers[2] != 15 {
+		t.Fatalf("set fields = %v, want [7 12 15]", numbers)
+	}
+
+	note := body.Get(7)
+	if note.Field == nil || note.Field.Name != "note" || note.Value.Kind != ValueString || note.Value.String != "hi" {
+		t.Errorf("note = %+v", note)
+	}
+	if p := body.Lookup("priority"); p == nil || p.Value.Kind != ValueInt || p.Value.Int != 0 {
+		t.Errorf("priority = %+v", p)
+	}
+	if body.Get(1) != nil || body.Lookup("gift") != nil {
+		t.Error("unset fields reported as set")
+	}
+
+	refunds := body.Lookup("refunds").Value
+	if refunds.Kind != ValueList || len(refunds.List) != 1 {
+		t.Fatalf("refunds = %+v", refunds)
+	}
+	if refund := refunds.List[0].Message; refund.Type != "shop.Money" || len(refund.Fields) != 0 {
+		t.Errorf("refund = %+v, want an empty shop.Money", refund)
+	}
+}
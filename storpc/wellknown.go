@@ -0,0 +1,47 @@
+package storpc
+
+var wellKnownLogical = map[string]uint8{
+	"google.protobuf.Timestamp": LogicalTime,
+	"google.protobuf.Duration":  LogicalDuration,
+	"google.protobuf.Struct":    LogicalJSON,
+	"google.protobuf.Value":     LogicalJSON,
+	"google.protobuf.ListValue": LogicalJSON,
+	"google.protobuf.Any":       LogicalAny,
+}
+
+// scalar kinds carried by the wrapper types
+var wrapperScalars = map[string]string{
+	"google.protobuf.DoubleValue": "double",
+	"google.protobuf.FloatValue":  "float",
+	"google.protobuf.Int64Value":  "int64",
+	"google.protobuf.UInt64Value": "uint64",
+	"google.protobuf.Int32Value":  "int32",
+	"google.protobuf.UInt32Value": "uint32",
+	"google.protobuf.BoolValue":   "bool",
+	"google.protobuf.StringValue": "string",
+	"google.protobuf.BytesValue":  "bytes",
+}
+
+// LogicalOf returns the logical column type stored for a message type,
+// LogicalNone for anything but a well-known type.
+func LogicalOf(typeName string) uint8 {
+	if logical, ok := wellKnownLogical[typeName]; ok {
+		return logical
+	}
+	if _, ok := wrapperScalars[typeName]; ok {
+		return LogicalNullable
+	}
+	return LogicalNone
+}
+
+// ScalarType is the scalar kind a column holds: the field type for scalar
+// fields, the wrapped kind for nullable fields and "" otherwise.
+func (f *Field) ScalarType() string {
+	switch {
+	case f.Kind == KindScalar:
+		return f.Type
+	case f.Logical == LogicalNullable:
+		return wrapperScalars[f.Type]
+	}
+	return ""
+}
@@ -0,0 +1,118 @@
+package storpc
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+const (
+	CaseCamel          = "camel"
+	CasePascal         = "pascal"
+	CaseSnake          = "snake"
+	CaseKebab          = "kebab"
+	CaseScreamingSnake = "screaming-snake"
+)
+
+// spellings accepted for --case besides the canonical ones above
+var caseAliases = map[string]string{
+	"camelcase":            CaseCamel,
+	"pascalcase":           CasePascal,
+	"snake_case":           CaseSnake,
+	"kebab-case":           CaseKebab,
+	"screaming_snake":      CaseScreamingSnake,
+	"screaming_snake_case": CaseScreamingSnake,
+	"screaming-snake-case": CaseScreamingSnake,
+}
+
+// NormaliseCase returns the canonical name of a word case, or "" when no
+// case is requested.
+func NormaliseCase(wordCase string) (string, error) {
+	lower := strings.ToLower(strings.TrimSpace(wordCase))
+	switch lower {
+	case "", CaseCamel, CasePascal, CaseSnake, CaseKebab, CaseScreamingSnake:
+		return lower, nil
+	}
+	if canonical, ok := caseAliases[lower]; ok {
+		return canonical, nil
+	}
+	return "", fmt.Errorf("unknown word case %q", wordCase)
+}
+
+// ToCase rewrites an identifier in the given word case. An empty case
+// returns the name unchanged.
+func ToCase(name, wordCase string) (string, error) {
+	wordCase, err := NormaliseCase(wordCase)
+	if err != nil {
+		return "", err
+	}
+	if wordCase == "" {
+		return name, nil
+	}
+
+	words := splitWords(name)
+	for i, w := range words {
+		words[i] = strings.ToLower(w)
+	}
+
+	switch wordCase {
+	case CaseCamel:
+		for i := 1; i < len(words); i++ {
+			words[i] = title(words[i])
+		}
+		return strings.Join(words, ""), nil
+	case CasePascal:
+		for i := range words {
+			words[i] = title(words[i])
+		}
+		return strings.Join(words, ""), nil
+	case CaseSnake:
+		return strings.Join(words, "_"), nil
+	case CaseKebab:
+		return strings.Join(words, "-"), nil
+	default:
+		return strings.ToUpper(strings.Join(words, "_")), nil
+	}
+}
+
+func title(word string) string {
+	if word == "" {
+		return word
+	}
+	r := []rune(word)
+	r[0] = unicode.ToUpper(r[0])
+	return string(r)
+}
+
+// splitWords breaks an identifier at separators and case changes, keeping
+// acronyms together: "HTTPServerID" is HTTP, Server, ID.
+func splitWords(name string) []string {
+	var words []string
+	var current []rune
+
+	flush := func() {
+		if len(current) > 0 {
+			words = append(words, string(current))
+			current = current[:0]
+		}
+	}
+
+	runes := []rune(name)
+	for i, r := range runes {
+		switch {
+		case r == '_' || r == '-' || r == '.' || unicode.IsSpace(r):
+			flush()
+			continue
+		case unicode.IsUpper(r) && len(current) > 0:
+			prev := runes[i-1]
+			nextLower := i+1 < len(runes) && unicode.IsLower(runes[i+1])
+			if unicode.IsLower(prev) || unicode.IsDigit(prev) || (unicode.IsUpper(prev) && nextLower) {
+				flush()
+			}
+		}
+		current = append(current, r)
+	}
+	flush()
+
+	return words
+}
@@ -0,0 +1,50 @@
+package storpc
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestSplitWords(t *testing.T) {
+	tests := map[string][]string{
+		"user_id":            {"user", "id"},
+		"UserID":             {"User", "ID"},
+		"HTTPServerConfig":   {"HTTP", "Server", "Config"},
+		"loginRequest.Asset": {"login", "Request", "Asset"},
+		"v2Field":            {"v2", "Field"},
+		"kebab-case-name":    {"kebab", "case", "name"},
+	}
+	for in, want := range tests {
+		if got := splitWords(in); !slices.Equal(got, want) {
+			t.Errorf("splitWords(%q) = %v, want %v", in, got, want)
+		}
+	}
+}
+
+func TestToCase(t *testing.T) {
+	tests := []struct {
+		name, wordCase, want string
+	}{
+		{"user_id", CaseCamel, "userId"},
+		{"UserID", CasePascal, "UserId"},
+		{"LoginRequest.Asset", CaseSnake, "login_request_asset"},
+		{"createdAt", CaseKebab, "created-at"},
+		{"createdAt", CaseScreamingSnake, "CREATED_AT"},
+		{"createdAt", "SCREAMING_SNAKE_CASE", "CREATED_AT"},
+		{"createdAt", "", "createdAt"},
+	}
+	for _, tt := range tests {
+		got, err := ToCase(tt.name, tt.wordCase)
+		if err != nil {
+			t.Errorf("ToCase(%q, %q) failed: %v", tt.name, tt.wordCase, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("ToCase(%q, %q) = %q, want %q", tt.name, tt.wordCase, got, tt.want)
+		}
+	}
+
+	if _, err := ToCase("x", "train"); err == nil {
+		t.Errorf("expected error for unknown case")
+	}
+}